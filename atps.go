@@ -0,0 +1,147 @@
+package dkim
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base32"
+	"net/mail"
+	"strings"
+)
+
+// ATPSStatus is the result of an Authorized Third-Party Signature check
+// (RFC 6541), as in the dkim-atps Authentication-Results method
+type ATPSStatus int
+
+const (
+	// ATPSNone the signature has no atps= tag, didn't verify or is made by
+	// the author domain
+	ATPSNone ATPSStatus = iota
+	// ATPSPass the author domain authorizes the signing domain
+	ATPSPass
+	// ATPSFail the author domain doesn't authorize the signing domain, or
+	// atps= is not the author domain
+	ATPSFail
+	// ATPSTempError the authorization record couldn't be retrieved
+	ATPSTempError
+	// ATPSPermError the atpsh= hash or the From field is invalid
+	ATPSPermError
+)
+
+// String returns the status as in an Authentication-Results field
+func (s ATPSStatus) String() string {
+	switch s {
+	case ATPSPass:
+		return "pass"
+	case ATPSFail:
+		return "fail"
+	case ATPSTempError:
+		return "temperror"
+	case ATPSPermError:
+		return "permerror"
+	}
+	return "none"
+}
+
+// isValidATPSHash returns true if hash is a valid atpsh= value
+func isValidATPSHash(hash string) bool {
+	return hash == "none" || hash == "sha1" || hash == "sha256"
+}
+
+// ATPSQueryName returns the name of the record by which authorDomain
+// authorizes signatures of signingDomain: the signing domain hashed with
+// hash ("sha1", "sha256" or "none"), base32 encoded, under _atps.<authorDomain>
+func ATPSQueryName(signingDomain, authorDomain, hash string) (string, error) {
+	signingDomain = strings.ToLower(signingDomain)
+	var label string
+	switch strings.ToLower(hash) {
+	case "none":
+		label = signingDomain
+	case "sha1":
+		sum := sha1.Sum([]byte(signingDomain))
+		label = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:])
+	case "sha256":
+		sum := sha256.Sum256([]byte(signingDomain))
+		label = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString(sum[:])
+	default:
+		return "", ErrATPSBadHash
+	}
+	return strings.ToLower(label) + "._atps." + strings.ToLower(authorDomain), nil
+}
+
+// ATPSRecord returns the record authorDomain publishes to authorize
+// signatures of signingDomain, hash is the atpsh= of the signatures
+func ATPSRecord(signingDomain, authorDomain, hash string) (DNSRecord, error) {
+	name, err := ATPSQueryName(signingDomain, authorDomain, hash)
+	if err != nil {
+		return DNSRecord{}, err
+	}
+	return DNSRecord{Name: name, Value: "v=ATPS1; d=" + strings.ToLower(signingDomain)}, nil
+}
+
+// checkATPS checks if the author domain of a message authorizes its valid
+// signature dkimHeader
+func checkATPS(rawHeaders []byte, dkimHeader *DKIMHeader, verifyOpts *VerifyOptions) ATPSStatus {
+	if dkimHeader.ATPSDomain == "" {
+		return ATPSNone
+	}
+	if !isValidATPSHash(dkimHeader.ATPSHash) {
+		return ATPSPermError
+	}
+
+	// the author domain, from the single From address
+	headers, err := getHeadersList(&rawHeaders)
+	if err != nil {
+		return ATPSPermError
+	}
+	var authors []*mail.Address
+	for _, h := range headers {
+		if name, value, _ := strings.Cut(h, ":"); strings.EqualFold(strings.TrimSpace(name), "from") {
+			if authors != nil {
+				return ATPSPermError
+			}
+			if authors, err = mail.ParseAddressList(strings.TrimSpace(value)); err != nil {
+				return ATPSPermError
+			}
+		}
+	}
+	if len(authors) != 1 {
+		return ATPSPermError
+	}
+	authorDomain := strings.ToLower(authors[0].Address[strings.LastIndex(authors[0].Address, "@")+1:])
+	if authorDomain == dkimHeader.Domain {
+		// not a third party
+		return ATPSNone
+	}
+	if authorDomain != dkimHeader.ATPSDomain {
+		return ATPSFail
+	}
+
+	name, err := ATPSQueryName(dkimHeader.Domain, authorDomain, dkimHeader.ATPSHash)
+	if err != nil {
+		return ATPSPermError
+	}
+	dnsOpts := newDNSOptions([]DNSOpt{dnsOpt(func(opts *DNSOptions) {
+		*opts = verifyOpts.DNSOptions
+	})})
+	txt, err := dnsOpts.lookupTXT(name)
+	if err != nil {
+		if newKeyLookupError(name, err).Reason.Status() == TEMPFAIL {
+			return ATPSTempError
+		}
+		return ATPSFail
+	}
+	for _, record := range txt {
+		tags, err := ParseTagList(record)
+		if err != nil {
+			continue
+		}
+		if v, _ := tags.Get("v"); removeWS(v) != "ATPS1" {
+			continue
+		}
+		if d, ok := tags.Get("d"); ok && !strings.EqualFold(removeWS(d), dkimHeader.Domain) {
+			continue
+		}
+		return ATPSPass
+	}
+	return ATPSFail
+}
@@ -0,0 +1,173 @@
+package dkim
+
+import (
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestATPSQueryName(t *testing.T) {
+	t.Parallel()
+
+	name, err := ATPSQueryName("ESP.example", "Author.example", "none")
+	require.NoError(t, err)
+	assert.Equal(t, "esp.example._atps.author.example", name)
+
+	for hash, length := range map[string]int{"sha1": 32, "sha256": 52} {
+		name, err := ATPSQueryName("ESP.example", "author.example", hash)
+		require.NoError(t, err)
+		label, parent, _ := strings.Cut(name, ".")
+		assert.Len(t, label, length, hash)
+		assert.Equal(t, strings.ToLower(label), label, hash)
+		assert.Equal(t, "_atps.author.example", parent, hash)
+
+		same, _ := ATPSQueryName("esp.example", "author.example", strings.ToUpper(hash))
+		assert.Equal(t, name, same, hash)
+	}
+
+	_, err = ATPSQueryName("esp.example", "author.example", "md5")
+	assert.Equal(t, ErrATPSBadHash, err)
+
+	record, err := ATPSRecord("esp.example", "author.example", "none")
+	require.NoError(t, err)
+	assert.Equal(t, DNSRecord{Name: "esp.example._atps.author.example", Value: "v=ATPS1; d=esp.example"}, record)
+}
+
+func TestATPSStatus_String(t *testing.T) {
+	assert.Equal(t, "none", ATPSNone.String())
+	assert.Equal(t, "pass", ATPSPass.String())
+	assert.Equal(t, "fail", ATPSFail.String())
+	assert.Equal(t, "temperror", ATPSTempError.String())
+	assert.Equal(t, "permerror", ATPSPermError.String())
+}
+
+func TestVerifyATPS(t *testing.T) {
+	// emailBase is from toorop@tmail.io
+	const esp = "esp.example"
+	sign := func(t *testing.T, modify func(o *SigOptions)) []byte {
+		t.Helper()
+		options := NewSigOptions()
+		options.PrivateKey = []byte(privKey)
+		options.Domain = esp
+		options.Selector = selector
+		options.ATPSDomain = domain
+		if modify != nil {
+			modify(&options)
+		}
+		email := []byte(emailBase)
+		require.NoError(t, Sign(&email, options))
+		return email
+	}
+	resolver := func(records map[string]string) DNSOpt {
+		return DNSOptLookupTXT(func(name string) ([]string, error) {
+			if strings.HasPrefix(name, selector+"._domainkey.") {
+				return []string{"v=DKIM1; p=" + pubKey}, nil
+			}
+			if record, ok := records[name]; ok {
+				if record == "timeout" {
+					return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+				}
+				return []string{record}, nil
+			}
+			return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		})
+	}
+	authorized, err := ATPSRecord(esp, domain, "sha256")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name    string
+		Modify  func(o *SigOptions)
+		Records map[string]string
+		ATPS    ATPSStatus
+	}{
+		{
+			Name:    "authorized",
+			Records: map[string]string{authorized.Name: authorized.Value},
+			ATPS:    ATPSPass,
+		},
+		{
+			Name:   "authorized without hash",
+			Modify: func(o *SigOptions) { o.ATPSHash = "none" },
+			Records: map[string]string{
+				esp + "._atps." + domain: "v=ATPS1",
+			},
+			ATPS: ATPSPass,
+		},
+		{
+			Name: "not authorized",
+			ATPS: ATPSFail,
+		},
+		{
+			Name:    "record for another domain",
+			Records: map[string]string{authorized.Name: "v=ATPS1; d=other.example"},
+			ATPS:    ATPSFail,
+		},
+		{
+			Name:    "not an ATPS record",
+			Records: map[string]string{authorized.Name: "v=spf1 -all"},
+			ATPS:    ATPSFail,
+		},
+		{
+			Name:    "lookup timeout",
+			Records: map[string]string{authorized.Name: "timeout"},
+			ATPS:    ATPSTempError,
+		},
+		{
+			Name:    "atps is not the author domain",
+			Modify:  func(o *SigOptions) { o.ATPSDomain = "toorop.fr" },
+			Records: map[string]string{authorized.Name: authorized.Value},
+			ATPS:    ATPSFail,
+		},
+		{
+			Name:   "first party",
+			Modify: func(o *SigOptions) { o.Domain = domain },
+			ATPS:   ATPSNone,
+		},
+		{
+			Name:   "no atps",
+			Modify: func(o *SigOptions) { o.ATPSDomain = "" },
+			ATPS:   ATPSNone,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			email := sign(t, tc.Modify)
+			res := VerifyWithResult(&email, resolver(tc.Records))
+			require.Equal(t, SUCCESS, res.Status, res.Err)
+			assert.Equal(t, tc.ATPS, res.ATPS)
+		})
+	}
+
+	t.Run("tags", func(t *testing.T) {
+		email := sign(t, nil)
+		assert.Contains(t, string(email), " atps=tmail.io; atpsh=sha256;")
+		res := VerifyWithResult(&email, resolver(nil))
+		assert.Equal(t, domain, res.Header.ATPSDomain)
+		assert.Equal(t, "sha256", res.Header.ATPSHash)
+	})
+	t.Run("bad signature", func(t *testing.T) {
+		email := []byte(strings.Replace(string(sign(t, nil)), "Hello world", "Hello", 1))
+		res := VerifyWithResult(&email, resolver(map[string]string{authorized.Name: authorized.Value}))
+		assert.Equal(t, PERMFAIL, res.Status)
+		assert.Equal(t, ATPSNone, res.ATPS)
+	})
+	t.Run("two authors", func(t *testing.T) {
+		dkimHeader := &DKIMHeader{Domain: esp, ATPSDomain: domain, ATPSHash: "sha256"}
+		rawHeaders := []byte("From: toorop@tmail.io, joe@tmail.io")
+		assert.Equal(t, ATPSPermError, checkATPS(rawHeaders, dkimHeader, &VerifyOptions{}))
+	})
+	t.Run("bad hash", func(t *testing.T) {
+		options := NewSigOptions()
+		options.PrivateKey = []byte(privKey)
+		options.Domain = esp
+		options.Selector = selector
+		options.ATPSDomain = domain
+		options.ATPSHash = "md5"
+		email := []byte(emailBase)
+		assert.Equal(t, ErrATPSBadHash, Sign(&email, options))
+	})
+}
@@ -0,0 +1,320 @@
+package dkim
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// MessageReader reads the messages of an archive
+type MessageReader interface {
+	// Next returns an identifier of the next message and its content.
+	// It returns io.EOF when there are no more messages.
+	Next() (id string, email []byte, err error)
+}
+
+// mboxReader reads an mbox file
+type mboxReader struct {
+	r    *bufio.Reader
+	n    int
+	from []byte
+	err  error
+}
+
+// NewMboxReader returns a MessageReader of the messages of an mbox file.
+// Messages start with a "From " line, which is not part of the message, and
+// ">From " lines are unescaped (mboxrd). The identifier of a message is its
+// position in the file ("1", "2", ...).
+func NewMboxReader(r io.Reader) MessageReader {
+	return &mboxReader{r: bufio.NewReader(r)}
+}
+
+var mboxFrom = []byte("From ")
+
+// Next implements MessageReader
+func (m *mboxReader) Next() (string, []byte, error) {
+	// skip to the first "From " line
+	for m.from == nil {
+		if m.err != nil {
+			return "", nil, m.err
+		}
+		var line []byte
+		line, m.err = m.r.ReadBytes('\n')
+		if m.err != nil && m.err != io.EOF {
+			return "", nil, m.err
+		}
+		if bytes.HasPrefix(line, mboxFrom) {
+			m.from = line
+		}
+	}
+
+	var email []byte
+	m.from = nil
+	for m.err == nil {
+		var line []byte
+		line, m.err = m.r.ReadBytes('\n')
+		if m.err != nil && m.err != io.EOF {
+			return "", nil, m.err
+		}
+		if bytes.HasPrefix(line, mboxFrom) {
+			m.from = line
+			break
+		}
+		// mboxrd: >From, >>From... lose one >
+		if unquoted := bytes.TrimLeft(line, ">"); len(unquoted) < len(line) && bytes.HasPrefix(unquoted, mboxFrom) {
+			line = line[1:]
+		}
+		email = append(email, line...)
+	}
+	// the empty line separating messages is not part of the message
+	if bytes.HasSuffix(email, []byte("\r\n\r\n")) {
+		email = email[:len(email)-2]
+	} else if bytes.HasSuffix(email, []byte("\n\n")) {
+		email = email[:len(email)-1]
+	}
+	m.n++
+	return strconv.Itoa(m.n), email, nil
+}
+
+// maildirReader reads the messages of a Maildir tree
+type maildirReader struct {
+	paths []string
+	err   error
+}
+
+// NewMaildirReader returns a MessageReader of the messages in the "cur" and
+// "new" directories of the Maildir tree dir (Maildir++ sub-folders
+// included). The identifier of a message is its path.
+func NewMaildirReader(dir string) MessageReader {
+	m := &maildirReader{}
+	m.err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() || d.Name()[0] == '.' {
+			return nil
+		}
+		if parent := filepath.Base(filepath.Dir(path)); parent == "cur" || parent == "new" {
+			m.paths = append(m.paths, path)
+		}
+		return nil
+	})
+	sort.Strings(m.paths)
+	return m
+}
+
+// Next implements MessageReader
+func (m *maildirReader) Next() (string, []byte, error) {
+	if m.err != nil {
+		return "", nil, m.err
+	}
+	if len(m.paths) == 0 {
+		return "", nil, io.EOF
+	}
+	path := m.paths[0]
+	m.paths = m.paths[1:]
+	email, err := os.ReadFile(path)
+	return path, email, err
+}
+
+// BatchResult is the verification result of a message of a batch
+type BatchResult struct {
+	// ID identifies the message in its archive
+	ID string
+
+	// Results has a result per signature (see VerifyAll)
+	Results []*VerifyResult
+
+	// Err is set if the message couldn't be read
+	Err error
+}
+
+// batchSignature is the JSON form of a VerifyResult
+type batchSignature struct {
+	Domain    string `json:"domain,omitempty"`
+	Selector  string `json:"selector,omitempty"`
+	Algorithm string `json:"algorithm,omitempty"`
+	Status    string `json:"status"`
+	Error     string `json:"error,omitempty"`
+	KeySource string `json:"key_source,omitempty"`
+	ATPS      string `json:"atps,omitempty"`
+}
+
+// MarshalJSON implements json.Marshaler:
+//
+//	{"id": "1", "signatures": [{"domain": "example.com", "selector": "s1", "algorithm": "rsa-sha256", "status": "SUCCESS", "key_source": "dns"}]}
+func (r BatchResult) MarshalJSON() ([]byte, error) {
+	out := struct {
+		ID         string           `json:"id"`
+		Error      string           `json:"error,omitempty"`
+		Signatures []batchSignature `json:"signatures,omitempty"`
+	}{ID: r.ID}
+	if r.Err != nil {
+		out.Error = r.Err.Error()
+	}
+	for _, res := range r.Results {
+		sig := batchSignature{Status: res.Status.String()}
+		if res.Header != nil {
+			sig.Domain = res.Header.Domain
+			sig.Selector = res.Header.Selector
+			sig.Algorithm = res.Header.Algorithm
+		}
+		if res.Err != nil {
+			sig.Error = res.Err.Error()
+		}
+		if res.KeySource != KeySourceNone {
+			sig.KeySource = res.KeySource.String()
+		}
+		if res.ATPS != ATPSNone {
+			sig.ATPS = res.ATPS.String()
+		}
+		out.Signatures = append(out.Signatures, sig)
+	}
+	return json.Marshal(out)
+}
+
+// VerifyBatch verifies all the signatures of the messages read from r with
+// workers goroutines and calls fn with the result of each message, in the
+// order of r, from a single goroutine. A KeyCache (see VerifyOptKeyCache)
+// avoids looking up the same key for each message.
+//
+// It returns the error of r, if any. Messages that can't be read from a
+// Maildir are reported in BatchResult.Err.
+func VerifyBatch(r MessageReader, workers int, fn func(BatchResult), opts ...VerifyOpt) error {
+	if workers < 1 {
+		workers = 1
+	}
+	type job struct {
+		result BatchResult
+		email  []byte
+		done   chan struct{}
+	}
+	jobs := make(chan *job)
+	ordered := make(chan *job, workers)
+
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				j.result.Results = VerifyAll(&j.email, opts...)
+				j.email = nil
+				close(j.done)
+			}
+		}()
+	}
+
+	var readErr error
+	go func() {
+		defer close(ordered)
+		defer close(jobs)
+		for {
+			id, email, err := r.Next()
+			if errors.Is(err, io.EOF) {
+				return
+			}
+			j := &job{result: BatchResult{ID: id}, email: email, done: make(chan struct{})}
+			if err != nil {
+				if id == "" {
+					// the reader can't go on
+					readErr = err
+					return
+				}
+				j.result.Err = err
+				close(j.done)
+			} else {
+				jobs <- j
+			}
+			ordered <- j
+		}
+	}()
+
+	for j := range ordered {
+		<-j.done
+		fn(j.result)
+	}
+	wg.Wait()
+	return readErr
+}
+
+// BatchSummary counts the results of a batch
+type BatchSummary struct {
+	// Messages read, Errors is the number which couldn't be read
+	Messages int `json:"messages"`
+	Errors   int `json:"errors"`
+
+	// Unsigned messages
+	Unsigned int `json:"unsigned"`
+
+	// Domains counts the signatures by d= domain
+	Domains map[string]*PassFail `json:"domains"`
+
+	// Reasons counts the failed signatures by error
+	Reasons map[string]int `json:"reasons"`
+}
+
+// PassFail counts passed and failed signatures
+type PassFail struct {
+	Pass int `json:"pass"`
+	Fail int `json:"fail"`
+}
+
+// NewBatchSummary returns an empty BatchSummary
+func NewBatchSummary() *BatchSummary {
+	return &BatchSummary{Domains: map[string]*PassFail{}, Reasons: map[string]int{}}
+}
+
+// Add counts r
+func (s *BatchSummary) Add(r BatchResult) {
+	s.Messages++
+	if r.Err != nil {
+		s.Errors++
+		return
+	}
+	for _, res := range r.Results {
+		if res.Status == NOTSIGNED {
+			s.Unsigned++
+			continue
+		}
+		domain := ""
+		if res.Header != nil {
+			domain = res.Header.Domain
+		}
+		count := s.Domains[domain]
+		if count == nil {
+			count = &PassFail{}
+			s.Domains[domain] = count
+		}
+		if res.Status == SUCCESS || res.Status == TESTINGSUCCESS {
+			count.Pass++
+			continue
+		}
+		count.Fail++
+		s.Reasons[failureReason(res.Err)]++
+	}
+}
+
+// failureReason returns the reason of a verification failure, without the
+// details specific to the message
+func failureReason(err error) string {
+	var lookupErr *KeyLookupError
+	switch {
+	case err == nil:
+		return "unknown"
+	case errors.As(err, &lookupErr):
+		if lookupErr.Reason.Status() == PERMFAIL {
+			return ErrVerifyNoKeyForSignature.Error() + ": " + lookupErr.Reason.String()
+		}
+		return ErrVerifyKeyUnavailable.Error() + ": " + lookupErr.Reason.String()
+	}
+	return err.Error()
+}
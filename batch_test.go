@@ -0,0 +1,155 @@
+package dkim
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// readAll returns the identifiers and messages of r
+func readAll(t *testing.T, r MessageReader) ([]string, []string) {
+	var ids, emails []string
+	for {
+		id, email, err := r.Next()
+		if err == io.EOF {
+			return ids, emails
+		}
+		require.NoError(t, err)
+		ids = append(ids, id)
+		emails = append(emails, string(email))
+	}
+}
+
+func TestMboxReader(t *testing.T) {
+	mbox := "preamble\n" +
+		"From joe@example.com Mon May  4 14:00:47 2015\n" +
+		"From: joe@example.com\n\nhello\n>From here\n>>From there\n>not from\n\n" +
+		"From jane@example.com Mon May  4 14:00:48 2015\r\n" +
+		"From: jane@example.com\r\n\r\nbye\r\n\r\n" +
+		"From bob@example.com Mon May  4 14:00:49 2015\n" +
+		"From: bob@example.com\n\nno final line"
+
+	ids, emails := readAll(t, NewMboxReader(strings.NewReader(mbox)))
+	assert.Equal(t, []string{"1", "2", "3"}, ids)
+	assert.Equal(t, []string{
+		"From: joe@example.com\n\nhello\nFrom here\n>From there\n>not from\n",
+		"From: jane@example.com\r\n\r\nbye\r\n",
+		"From: bob@example.com\n\nno final line",
+	}, emails)
+
+	ids, _ = readAll(t, NewMboxReader(strings.NewReader("")))
+	assert.Empty(t, ids)
+}
+
+func TestMaildirReader(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"cur/1:2,S":              "From: a\r\n\r\n",
+		"new/2":                  "From: b\r\n\r\n",
+		"tmp/3":                  "From: c\r\n\r\n",
+		".Sent/cur/4:2,S":        "From: d\r\n\r\n",
+		".Sent/cur/.hidden":      "From: e\r\n\r\n",
+		".Sent/dovecot.index":    "index",
+		"dovecot-uidlist":        "uids",
+		".Sent/maildirfolder":    "",
+		".Trash/new/5":           "From: f\r\n\r\n",
+		".Trash/courierimapkeyw": "",
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0o755))
+		require.NoError(t, os.WriteFile(path, []byte(content), 0o644))
+	}
+
+	ids, emails := readAll(t, NewMaildirReader(dir))
+	assert.Equal(t, []string{
+		filepath.Join(dir, ".Sent", "cur", "4:2,S"),
+		filepath.Join(dir, ".Trash", "new", "5"),
+		filepath.Join(dir, "cur", "1:2,S"),
+		filepath.Join(dir, "new", "2"),
+	}, ids)
+	assert.Equal(t, []string{"From: d\r\n\r\n", "From: f\r\n\r\n", "From: a\r\n\r\n", "From: b\r\n\r\n"}, emails)
+
+	_, _, err := NewMaildirReader(filepath.Join(dir, "missing")).Next()
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+// sliceReader is a MessageReader of at most 4 emails, with an error for
+// the empty ones
+type sliceReader []string
+
+func (s *sliceReader) Next() (string, []byte, error) {
+	if len(*s) == 0 {
+		return "", nil, io.EOF
+	}
+	id := strconv.Itoa(5 - len(*s))
+	email := (*s)[0]
+	*s = (*s)[1:]
+	if email == "" {
+		return id, nil, errors.New("unreadable")
+	}
+	return id, []byte(email), nil
+}
+
+func TestVerifyBatch(t *testing.T) {
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+	tampered := strings.Replace(signedRelaxedRelaxed, "Hello world", "Hello World", 1)
+	r := sliceReader{signedRelaxedRelaxed, emailBase, "", tampered}
+
+	var results []BatchResult
+	summary := NewBatchSummary()
+	err := VerifyBatch(&r, 3, func(res BatchResult) {
+		results = append(results, res)
+		summary.Add(res)
+	}, resolveTXT, VerifyOptKeyCache(NewKeyCache(0)))
+	require.NoError(t, err)
+	require.Len(t, results, 4)
+
+	lines := make([]string, len(results))
+	for i, res := range results {
+		b, err := json.Marshal(res)
+		require.NoError(t, err)
+		lines[i] = string(b)
+	}
+	assert.Equal(t, []string{
+		`{"id":"1","signatures":[{"domain":"tmail.io","selector":"test","algorithm":"rsa-sha256","status":"SUCCESS","key_source":"dns"}]}`,
+		`{"id":"2","signatures":[{"status":"NOTSIGNED","error":"no DKIM-Signature header field found "}]}`,
+		`{"id":"3","error":"unreadable"}`,
+		`{"id":"4","signatures":[{"domain":"tmail.io","selector":"test","algorithm":"rsa-sha256","status":"PERMFAIL","error":"body hash did not verify","key_source":"dns"}]}`,
+	}, lines)
+
+	assert.Equal(t, &BatchSummary{
+		Messages: 4,
+		Errors:   1,
+		Unsigned: 1,
+		Domains:  map[string]*PassFail{"tmail.io": {Pass: 1, Fail: 1}},
+		Reasons:  map[string]int{ErrVerifyBodyHash.Error(): 1},
+	}, summary)
+
+	fatal := errors.New("read error")
+	err = VerifyBatch(readerFunc(func() (string, []byte, error) {
+		return "", nil, fatal
+	}), 1, func(BatchResult) { t.Fatal("unexpected result") })
+	assert.Equal(t, fatal, err)
+}
+
+type readerFunc func() (string, []byte, error)
+
+func (f readerFunc) Next() (string, []byte, error) {
+	return f()
+}
+
+func TestFailureReason(t *testing.T) {
+	assert.Equal(t, "no key for verify: nxdomain", failureReason(&KeyLookupError{Name: "a", Reason: KeyLookupNXDomain}))
+	assert.Equal(t, ErrVerifyBodyHash.Error(), failureReason(ErrVerifyBodyHash))
+}
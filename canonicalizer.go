@@ -0,0 +1,326 @@
+package dkim
+
+import (
+	"bytes"
+	"io"
+)
+
+const (
+	// SimpleCanonicalization is the "simple" canonicalization algorithm
+	SimpleCanonicalization = "simple"
+
+	// RelaxedCanonicalization is the "relaxed" canonicalization algorithm
+	RelaxedCanonicalization = "relaxed"
+)
+
+// LineEndings is the policy applied to line terminators other than CRLF
+// (bare LF and bare CR) before a message is canonicalized.
+//
+// RFC 5322 messages only use CRLF. Canonicalization (RFC 6376 section 3.4)
+// is defined on lines terminated by CRLF: a bare CR or a bare LF is an
+// ordinary octet of the line it belongs to. It is not whitespace, so it is
+// neither reduced nor removed by relaxed canonicalization.
+type LineEndings int
+
+const (
+	// LineEndingsAuto converts every bare LF to CRLF (eg a message read from
+	// a Unix mailbox, or a CRLF message with a LF between its header and its
+	// body). CRLF and bare CR are left untouched. This is the default.
+	LineEndingsAuto LineEndings = iota
+
+	// LineEndingsStrict leaves the message untouched: only CRLF terminates a
+	// line, bare CR and bare LF are part of the line.
+	LineEndingsStrict
+
+	// LineEndingsFixCRLF converts every bare LF and bare CR to CRLF.
+	LineEndingsFixCRLF
+)
+
+// normalizeLineEndings returns email with line endings converted according to policy
+func normalizeLineEndings(email []byte, policy LineEndings) []byte {
+	switch policy {
+	case LineEndingsAuto:
+		return fixLineEndings(email, false)
+	case LineEndingsFixCRLF:
+		return fixLineEndings(email, true)
+	}
+	return email
+}
+
+// fixLineEndings returns email with bare LF, and bare CR if cr is true,
+// converted to CRLF
+func fixLineEndings(email []byte, cr bool) []byte {
+	var out []byte
+	last := 0
+	for i := 0; i < len(email); i++ {
+		c := email[i]
+		if c != '\r' && c != '\n' {
+			continue
+		}
+		if c == '\r' && i+1 < len(email) && email[i+1] == '\n' {
+			i++
+			continue
+		}
+		if c == '\r' && !cr {
+			continue
+		}
+		// bare CR or bare LF
+		if out == nil {
+			out = make([]byte, 0, len(email)+len(email)/32)
+		}
+		out = append(out, email[last:i]...)
+		out = append(out, CRLF...)
+		last = i + 1
+	}
+	if out == nil {
+		return email
+	}
+	return append(out, email[last:]...)
+}
+
+// HeaderCanonicalizer canonicalizes header fields (RFC 6376 section 3.4.1 and 3.4.2)
+type HeaderCanonicalizer struct {
+	algo string
+}
+
+// NewHeaderCanonicalizer returns a HeaderCanonicalizer for algo ("simple" or "relaxed")
+func NewHeaderCanonicalizer(algo string) (*HeaderCanonicalizer, error) {
+	if algo != SimpleCanonicalization && algo != RelaxedCanonicalization {
+		return nil, ErrSignBadCanonicalization
+	}
+	return &HeaderCanonicalizer{algo: algo}, nil
+}
+
+// Canonicalize returns the canonicalized version of a raw header field.
+// field must be a complete header field (name, colon, value, folding and
+// terminating CRLF).
+func (c *HeaderCanonicalizer) Canonicalize(field string) (string, error) {
+	return canonicalizeHeader(field, c.algo)
+}
+
+// BodyCanonicalizer is an io.WriteCloser which canonicalizes a body
+// (RFC 6376 section 3.4.3 and 3.4.4) and writes the result to an
+// underlying writer, typically a hash.
+//
+// The body is processed as a stream: only trailing empty lines are held
+// back until more content or Close is written.
+// Close must be called once the whole body has been written.
+type BodyCanonicalizer struct {
+	w       io.Writer
+	relaxed bool
+
+	// l= tag, 0 means no limit
+	limit uint
+	// octets beyond limit are written to rest (if not nil)
+	rest io.Writer
+
+	// canonicalized octets, including those beyond limit
+	n int64
+	// octets written to w
+	written int64
+
+	out []byte
+
+	// pending empty lines
+	crlfs int
+	// pending WSP (relaxed)
+	wsp bool
+	// pending CR
+	cr bool
+	// current line has content
+	content bool
+	closed  bool
+}
+
+// bodyBufferSize is the size of the buffer used between BodyCanonicalizer and the underlying writer
+const bodyBufferSize = 4096
+
+// NewBodyCanonicalizer returns a BodyCanonicalizer for algo ("simple" or
+// "relaxed") writing to w. If limit is not 0 only the first limit octets
+// of the canonicalized body are written to w (l= tag).
+func NewBodyCanonicalizer(w io.Writer, algo string, limit uint) (*BodyCanonicalizer, error) {
+	if algo != SimpleCanonicalization && algo != RelaxedCanonicalization {
+		return nil, ErrSignBadCanonicalization
+	}
+	return &BodyCanonicalizer{
+		w:       w,
+		relaxed: algo == RelaxedCanonicalization,
+		limit:   limit,
+		out:     make([]byte, 0, bodyBufferSize),
+	}, nil
+}
+
+// Write canonicalizes p
+func (c *BodyCanonicalizer) Write(p []byte) (int, error) {
+	if c.closed {
+		return 0, io.ErrClosedPipe
+	}
+	n := len(p)
+	for len(p) > 0 {
+		if c.cr {
+			c.cr = false
+			if p[0] == '\n' {
+				c.endLine()
+				p = p[1:]
+				continue
+			}
+			c.writeContent('\r')
+		}
+
+		// copy octets which don't need any processing at once
+		i := c.indexSpecial(p)
+		if i == -1 {
+			i = len(p)
+		}
+		if i > 0 {
+			c.writeRun(p[:i])
+			p = p[i:]
+		} else {
+			switch b := p[0]; b {
+			case '\r':
+				c.cr = true
+			case ' ', '\t':
+				c.wsp = true
+			}
+			p = p[1:]
+		}
+
+		if len(c.out) >= bodyBufferSize {
+			if err := c.flush(); err != nil {
+				return 0, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// indexSpecial returns the index of the first octet of p which needs to be
+// processed (CR, and WSP sequences for relaxed) or -1
+func (c *BodyCanonicalizer) indexSpecial(p []byte) int {
+	if !c.relaxed {
+		return bytes.IndexByte(p, '\r')
+	}
+	for i := 0; i < len(p); i++ {
+		if p[i] > ' ' {
+			continue
+		}
+		switch p[i] {
+		case '\r', '\t':
+			return i
+		case ' ':
+			// a single SP between content octets is kept as is
+			if i+1 == len(p) || i == 0 && c.wsp {
+				return i
+			}
+			if next := p[i+1]; next == ' ' || next == '\t' || next == '\r' {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// Close terminates the body and flushes it to the underlying writer.
+// It returns ErrBadDKimTagLBodyTooShort if the canonicalized body is
+// shorter than the limit.
+func (c *BodyCanonicalizer) Close() error {
+	if c.closed {
+		return nil
+	}
+	if c.cr {
+		c.cr = false
+		c.writeContent('\r')
+	}
+	// trailing WSP of the last line is ignored (relaxed)
+	c.wsp = false
+	switch {
+	case c.content:
+		// no CRLF at the end of the body, a CRLF is added
+		c.emit('\r', '\n')
+	case c.crlfs > 0 && c.Len() > 0:
+		// trailing empty lines are ignored
+		c.emit('\r', '\n')
+	case c.Len() == 0 && !c.relaxed:
+		// simple: an empty body is canonicalized as a single CRLF
+		c.emit('\r', '\n')
+	}
+	c.closed = true
+	if err := c.flush(); err != nil {
+		return err
+	}
+	if c.limit != 0 && int64(c.limit) > c.n {
+		return ErrBadDKimTagLBodyTooShort
+	}
+	return nil
+}
+
+// Len returns the length of the canonicalized body written so far,
+// including octets beyond the limit
+func (c *BodyCanonicalizer) Len() int64 {
+	return c.n + int64(len(c.out))
+}
+
+// writeContent writes a content octet of the current line
+func (c *BodyCanonicalizer) writeContent(b byte) {
+	c.startContent()
+	c.out = append(c.out, b)
+}
+
+// writeRun writes content octets of the current line
+func (c *BodyCanonicalizer) writeRun(run []byte) {
+	c.startContent()
+	c.out = append(c.out, run...)
+}
+
+// startContent writes pending empty lines and WSP before content
+func (c *BodyCanonicalizer) startContent() {
+	for ; c.crlfs > 0; c.crlfs-- {
+		c.out = append(c.out, '\r', '\n')
+	}
+	if c.wsp {
+		c.wsp = false
+		c.out = append(c.out, ' ')
+	}
+	c.content = true
+}
+
+// endLine handles a CRLF
+func (c *BodyCanonicalizer) endLine() {
+	// WSP at the end of line are ignored (relaxed)
+	c.wsp = false
+	c.crlfs++
+	c.content = false
+}
+
+// emit adds canonicalized octets to the output buffer
+func (c *BodyCanonicalizer) emit(b ...byte) {
+	c.out = append(c.out, b...)
+}
+
+// flush writes the output buffer to the underlying writer up to the limit
+func (c *BodyCanonicalizer) flush() error {
+	out := c.out
+	c.n += int64(len(out))
+	c.out = c.out[:0]
+	if c.limit != 0 {
+		remaining := int64(c.limit) - c.written
+		if remaining <= 0 {
+			if c.rest != nil {
+				_, err := c.rest.Write(out)
+				return err
+			}
+			return nil
+		}
+		if int64(len(out)) > remaining {
+			if c.rest != nil {
+				if _, err := c.rest.Write(out[remaining:]); err != nil {
+					return err
+				}
+			}
+			out = out[:remaining]
+		}
+	}
+	written, err := c.w.Write(out)
+	c.written += int64(written)
+	return err
+}
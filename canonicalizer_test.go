@@ -0,0 +1,364 @@
+package dkim
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHeaderCanonicalizer(t *testing.T) {
+	t.Parallel()
+
+	// RFC 6376 section 3.4.5
+	fields := []string{"A: X\r\n", "B : Y\t\r\n\tZ  \r\n"}
+
+	simple, err := NewHeaderCanonicalizer(SimpleCanonicalization)
+	require.NoError(t, err)
+	relaxed, err := NewHeaderCanonicalizer(RelaxedCanonicalization)
+	require.NoError(t, err)
+
+	expectRelaxed := []string{"a:X\r\n", "b:Y Z\r\n"}
+	for i, f := range fields {
+		c, err := simple.Canonicalize(f)
+		assert.NoError(t, err)
+		assert.Equal(t, f, c)
+
+		c, err = relaxed.Canonicalize(f)
+		assert.NoError(t, err)
+		assert.Equal(t, expectRelaxed[i], c)
+	}
+
+	_, err = NewHeaderCanonicalizer("nofws")
+	assert.Equal(t, ErrSignBadCanonicalization, err)
+}
+
+func TestBodyCanonicalizer(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		Name    string
+		Body    string
+		Simple  string
+		Relaxed string
+	}
+
+	testCases := []testCase{
+		{
+			Name:    "RFC 6376 example",
+			Body:    " C \r\nD \t E\r\n\r\n\r\n",
+			Simple:  " C \r\nD \t E\r\n",
+			Relaxed: " C\r\nD E\r\n",
+		},
+		{
+			Name:    "empty body",
+			Body:    "",
+			Simple:  "\r\n",
+			Relaxed: "",
+		},
+		{
+			Name:    "only empty lines",
+			Body:    "\r\n\r\n",
+			Simple:  "\r\n",
+			Relaxed: "",
+		},
+		{
+			Name:    "only whitespace lines",
+			Body:    "  \r\n\t\r\n",
+			Simple:  "  \r\n\t\r\n",
+			Relaxed: "",
+		},
+		{
+			Name:    "no trailing CRLF",
+			Body:    "abc  ",
+			Simple:  "abc  \r\n",
+			Relaxed: "abc\r\n",
+		},
+		{
+			Name:    "empty lines inside body",
+			Body:    "a\r\n\r\n \r\nb\r\n",
+			Simple:  "a\r\n\r\n \r\nb\r\n",
+			Relaxed: "a\r\n\r\n\r\nb\r\n",
+		},
+		{
+			Name:    "single and multiple spaces",
+			Body:    "a b  c \t\r\n d e\r\n",
+			Simple:  "a b  c \t\r\n d e\r\n",
+			Relaxed: "a b c\r\n d e\r\n",
+		},
+		{
+			Name:    "trailing CR",
+			Body:    "a\r",
+			Simple:  "a\r\r\n",
+			Relaxed: "a\r\r\n",
+		},
+	}
+
+	canonicalize := func(t *testing.T, algo string, body string, chunk int) string {
+		buf := new(bytes.Buffer)
+		c, err := NewBodyCanonicalizer(buf, algo, 0)
+		require.NoError(t, err)
+		for i := 0; i < len(body); i += chunk {
+			end := i + chunk
+			if end > len(body) {
+				end = len(body)
+			}
+			_, err = c.Write([]byte(body[i:end]))
+			require.NoError(t, err)
+		}
+		require.NoError(t, c.Close())
+		assert.Equal(t, int64(buf.Len()), c.Len())
+		return buf.String()
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			for _, chunk := range []int{1, 2, 3, len(tc.Body) + 1} {
+				assert.Equal(t, tc.Simple, canonicalize(t, SimpleCanonicalization, tc.Body, chunk))
+				assert.Equal(t, tc.Relaxed, canonicalize(t, RelaxedCanonicalization, tc.Body, chunk))
+			}
+		})
+	}
+}
+
+// TestCanonicalizationVectors checks the canonicalization of messages signed
+// by another implementation (see testdata/canonicalization/README.md)
+func TestCanonicalizationVectors(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join("testdata", "canonicalization", "vectors.json"))
+	require.NoError(t, err)
+	var vectors []struct {
+		Name    string `json:"name"`
+		Message string `json:"message"`
+		Simple  string `json:"simple"`
+		Relaxed string `json:"relaxed"`
+	}
+	require.NoError(t, json.Unmarshal(data, &vectors))
+	require.NotEmpty(t, vectors)
+
+	resolver := conformanceResolver(t)
+	for _, v := range vectors {
+		v := v
+		t.Run(v.Name, func(t *testing.T) {
+			for algo, signature := range map[string]string{SimpleCanonicalization: v.Simple, RelaxedCanonicalization: v.Relaxed} {
+				// body hash
+				dkh, err := parseDkHeader(signature)
+				require.NoError(t, err, algo)
+				email := []byte(v.Message)
+				_, body, err := getHeadersBody(&email, LineEndingsStrict)
+				require.NoError(t, err, algo)
+				h := sha256.New()
+				c, err := NewBodyCanonicalizer(h, algo, 0)
+				require.NoError(t, err)
+				_, err = c.Write(body)
+				require.NoError(t, err)
+				require.NoError(t, c.Close())
+				assert.Equal(t, dkh.BodyHash, base64.StdEncoding.EncodeToString(h.Sum(nil)), algo)
+
+				// header fields
+				email = []byte(signature + v.Message)
+				status, err := Verify(&email, resolver, VerifyOptLineEndings(LineEndingsStrict))
+				assert.NoError(t, err, algo)
+				assert.Equal(t, SUCCESS, status, algo)
+			}
+		})
+	}
+}
+
+func TestBodyCanonicalizerLimit(t *testing.T) {
+	t.Parallel()
+
+	body := bytes.Repeat([]byte("line with           space         \r\n"), 1000)
+	buf := new(bytes.Buffer)
+	c, err := NewBodyCanonicalizer(buf, RelaxedCanonicalization, 20)
+	require.NoError(t, err)
+	_, err = c.Write(body)
+	require.NoError(t, err)
+	require.NoError(t, c.Close())
+	assert.Equal(t, "line with space\r\nlin", buf.String())
+	assert.Equal(t, int64(len("line with space\r\n")*1000), c.Len())
+
+	// l= larger than the body
+	c, err = NewBodyCanonicalizer(new(bytes.Buffer), SimpleCanonicalization, 20)
+	require.NoError(t, err)
+	_, err = c.Write([]byte("short\r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, ErrBadDKimTagLBodyTooShort, c.Close())
+}
+
+func TestLineEndings(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		Name    string
+		Email   string
+		Policy  LineEndings
+		Simple  string
+		Relaxed string
+		Err     error
+	}
+
+	testCases := []testCase{
+		// LF only message
+		{
+			Name:    "LF only, auto",
+			Email:   "A: b\n\nx \ny\n\n",
+			Policy:  LineEndingsAuto,
+			Simple:  "x \r\ny\r\n",
+			Relaxed: "x\r\ny\r\n",
+		},
+		{
+			Name:   "LF only, strict",
+			Email:  "A: b\n\nx \ny\n\n",
+			Policy: LineEndingsStrict,
+			Err:    ErrBadMailFormat,
+		},
+		{
+			Name:    "LF only, fix",
+			Email:   "A: b\n\nx \ny\n\n",
+			Policy:  LineEndingsFixCRLF,
+			Simple:  "x \r\ny\r\n",
+			Relaxed: "x\r\ny\r\n",
+		},
+
+		// bare LF in a CRLF message
+		{
+			Name:    "bare LF, auto",
+			Email:   "A: b\r\n\r\nx\ny \r\n\n",
+			Policy:  LineEndingsAuto,
+			Simple:  "x\r\ny \r\n",
+			Relaxed: "x\r\ny\r\n",
+		},
+		{
+			Name:    "bare LF, strict",
+			Email:   "A: b\r\n\r\nx\ny \r\n\n",
+			Policy:  LineEndingsStrict,
+			Simple:  "x\ny \r\n\n\r\n",
+			Relaxed: "x\ny\r\n\n\r\n",
+		},
+		{
+			Name:    "bare LF, fix",
+			Email:   "A: b\r\n\r\nx\ny \r\n\n",
+			Policy:  LineEndingsFixCRLF,
+			Simple:  "x\r\ny \r\n",
+			Relaxed: "x\r\ny\r\n",
+		},
+
+		// bare CR
+		{
+			Name:    "bare CR, strict",
+			Email:   "A: b\r\n\r\nx\ry \r\r\n",
+			Policy:  LineEndingsStrict,
+			Simple:  "x\ry \r\r\n",
+			Relaxed: "x\ry \r\r\n",
+		},
+		{
+			Name:    "bare CR at end, strict",
+			Email:   "A: b\r\n\r\nx\r",
+			Policy:  LineEndingsStrict,
+			Simple:  "x\r\r\n",
+			Relaxed: "x\r\r\n",
+		},
+		{
+			Name:    "bare CR, auto",
+			Email:   "A: b\r\n\r\nx\ry \r\r\n",
+			Policy:  LineEndingsAuto,
+			Simple:  "x\ry \r\r\n",
+			Relaxed: "x\ry \r\r\n",
+		},
+		{
+			Name:    "bare CR, fix",
+			Email:   "A: b\r\n\r\nx\ry \r\r\n",
+			Policy:  LineEndingsFixCRLF,
+			Simple:  "x\r\ny \r\n",
+			Relaxed: "x\r\ny\r\n",
+		},
+
+		// LF between header and body of a CRLF message
+		{
+			Name:    "mixed separator, auto",
+			Email:   "A: b\r\n\nx\r\n",
+			Policy:  LineEndingsAuto,
+			Simple:  "x\r\n",
+			Relaxed: "x\r\n",
+		},
+		{
+			Name:   "mixed separator, strict",
+			Email:  "A: b\r\n\nx\r\n",
+			Policy: LineEndingsStrict,
+			Err:    ErrBadMailFormat,
+		},
+		{
+			Name:    "mixed separator, fix",
+			Email:   "A: b\r\n\nx\r\n",
+			Policy:  LineEndingsFixCRLF,
+			Simple:  "x\r\n",
+			Relaxed: "x\r\n",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			email := []byte(tc.Email)
+			headers, body, err := getHeadersBody(&email, tc.Policy)
+			assert.Equal(t, tc.Err, err)
+			if err != nil {
+				return
+			}
+			assert.Equal(t, "A: b", string(headers))
+			for algo, expect := range map[string]string{SimpleCanonicalization: tc.Simple, RelaxedCanonicalization: tc.Relaxed} {
+				buf := new(bytes.Buffer)
+				c, err := NewBodyCanonicalizer(buf, algo, 0)
+				require.NoError(t, err)
+				_, err = c.Write(body)
+				require.NoError(t, err)
+				require.NoError(t, c.Close())
+				assert.Equal(t, expect, buf.String(), algo)
+			}
+			// input is not modified
+			assert.Equal(t, tc.Email, string(email))
+		})
+	}
+}
+
+func Test_SignVerifyLineEndings(t *testing.T) {
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	options.Canonicalization = "relaxed/relaxed"
+	options.LineEndings = LineEndingsFixCRLF
+
+	email := []byte("From: joe@tmail.io\r\n\r\nline one\nline two\r\n")
+	require.NoError(t, Sign(&email, options))
+
+	status, err := Verify(&email, resolveTXT, VerifyOptLineEndings(LineEndingsFixCRLF))
+	assert.NoError(t, err)
+	assert.Equal(t, SUCCESS, status)
+
+	status, err = Verify(&email, resolveTXT, VerifyOptLineEndings(LineEndingsStrict))
+	assert.Equal(t, ErrVerifyBodyHash, err)
+	assert.Equal(t, PERMFAIL, status)
+
+	// mixed line endings, signed and verified with LineEndingsAuto
+	options.LineEndings = LineEndingsAuto
+	email = []byte("From: joe@tmail.io\r\nSubject: mixed\n\nline one\r\nline two\n")
+	require.NoError(t, Sign(&email, options))
+	dkh, err := GetHeader(&email)
+	require.NoError(t, err)
+	bh := sha256.Sum256([]byte("line one\r\nline two\r\n"))
+	assert.Equal(t, base64.StdEncoding.EncodeToString(bh[:]), dkh.BodyHash)
+	status, err = Verify(&email, resolveTXT)
+	assert.NoError(t, err)
+	assert.Equal(t, SUCCESS, status)
+}
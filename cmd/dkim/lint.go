@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	dkim "github.com/toorop/go-dkim"
+)
+
+// lint runs the lint command
+func lint(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("lint", "selector domain", stderr)
+	keyFile := fs.String("key", "", "PEM `file` of the private key which must match the record")
+	record := fs.String("record", "", "lint this `record` instead of the published one")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (*record == "" && fs.NArg() != 2) || (*record != "" && fs.NArg() != 0) {
+		fs.Usage()
+		return 2
+	}
+
+	var opts []dkim.LintOpt
+	if *keyFile != "" {
+		key, err := os.ReadFile(*keyFile)
+		if err != nil {
+			fmt.Fprintln(stderr, "dkim:", err)
+			return 1
+		}
+		opts = append(opts, dkim.LintOptPrivateKey(key))
+	}
+
+	var findings []dkim.LintFinding
+	if *record != "" {
+		findings = dkim.LintKeyRecord(*record, opts...)
+	} else {
+		var err error
+		findings, err = dkim.LintDNSKeyRecord(fs.Arg(0), fs.Arg(1), opts...)
+		if err != nil {
+			fmt.Fprintln(stderr, "dkim:", err)
+			return 1
+		}
+	}
+
+	code := 0
+	for _, f := range findings {
+		fmt.Fprintln(stdout, f)
+		if f.Severity == dkim.LintError {
+			code = 1
+		}
+	}
+	if len(findings) == 0 {
+		fmt.Fprintln(stdout, "ok")
+	}
+	return code
+}
@@ -0,0 +1,24 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLint(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"lint", "-record", "v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="}, &stdout, &stderr)
+	assert.Equal(t, 0, code)
+	assert.Equal(t, "ok\n", stdout.String())
+
+	stdout.Reset()
+	code = run([]string{"lint", "-record", "v=DKIM1; t=y; p="}, &stdout, &stderr)
+	assert.Equal(t, 1, code)
+	assert.Equal(t, "warning: testing: t=y: the domain is testing DKIM, verifiers may ignore failures\n"+
+		"error: key-revoked: p= is empty, the key is revoked\n", stdout.String())
+
+	assert.Equal(t, 2, run([]string{"lint"}, &stdout, &stderr))
+	assert.Equal(t, 2, run([]string{"unknown"}, &stdout, &stderr))
+}
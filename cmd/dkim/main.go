@@ -0,0 +1,57 @@
+// Command dkim is a command line tool for DKIM key records and signatures.
+//
+// Usage:
+//
+//	dkim lint [-key private.pem] [-record "v=DKIM1; p=..."] selector domain
+//	dkim verify [-workers n] [-mbox file | -maildir dir | file...]
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"os"
+)
+
+func main() {
+	os.Exit(run(os.Args[1:], os.Stdout, os.Stderr))
+}
+
+// run runs the command and returns the exit code
+func run(args []string, stdout, stderr io.Writer) int {
+	if len(args) == 0 {
+		usage(stderr)
+		return 2
+	}
+	switch args[0] {
+	case "lint":
+		return lint(args[1:], stdout, stderr)
+	case "verify":
+		return verify(args[1:], stdout, stderr)
+	case "help", "-h", "-help", "--help":
+		usage(stdout)
+		return 0
+	}
+	fmt.Fprintf(stderr, "dkim: unknown command %q\n", args[0])
+	usage(stderr)
+	return 2
+}
+
+func usage(w io.Writer) {
+	fmt.Fprintln(w, "usage: dkim <command> [arguments]")
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "commands:")
+	fmt.Fprintln(w, "  lint    check a published key record")
+	fmt.Fprintln(w, "  verify  verify the signatures of messages, mbox files or Maildirs")
+}
+
+// newFlagSet returns a flag set for a command
+func newFlagSet(name, args string, stderr io.Writer) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	fs.Usage = func() {
+		fmt.Fprintf(stderr, "usage: dkim %s [flags] %s\n", name, args)
+		fs.PrintDefaults()
+	}
+	return fs
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	dkim "github.com/toorop/go-dkim"
+)
+
+// verify runs the verify command
+func verify(args []string, stdout, stderr io.Writer) int {
+	fs := newFlagSet("verify", "[-mbox file | -maildir dir | file...]", stderr)
+	mbox := fs.String("mbox", "", "verify the messages of the mbox `file`")
+	maildir := fs.String("maildir", "", "verify the messages of the Maildir `dir`ectory tree")
+	workers := fs.Int("workers", runtime.NumCPU(), "`number` of messages verified concurrently")
+	cacheTTL := fs.Duration("cache", time.Hour, "keep key records for `duration`")
+	dnsServer := fs.String("dns", "", "DNS server `address` (host:port) used to retrieve keys")
+	dnsTimeout := fs.Duration("dns-timeout", 5*time.Second, "key lookup `timeout`")
+	if err := fs.Parse(args); err != nil {
+		return 2
+	}
+	if (*mbox != "" && *maildir != "") || ((*mbox != "" || *maildir != "") && fs.NArg() != 0) || (*mbox == "" && *maildir == "" && fs.NArg() == 0) {
+		fs.Usage()
+		return 2
+	}
+
+	var r dkim.MessageReader
+	switch {
+	case *mbox != "":
+		f, err := os.Open(*mbox)
+		if err != nil {
+			fmt.Fprintln(stderr, "dkim:", err)
+			return 1
+		}
+		defer f.Close()
+		r = dkim.NewMboxReader(f)
+	case *maildir != "":
+		r = dkim.NewMaildirReader(*maildir)
+	default:
+		r = &filesReader{paths: fs.Args()}
+	}
+
+	resolver := &net.Resolver{}
+	if *dnsServer != "" {
+		resolver.PreferGo = true
+		resolver.Dial = func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, *dnsServer)
+		}
+	}
+	lookupTXT := func(name string) ([]string, error) {
+		if !strings.HasSuffix(name, ".") {
+			// rooted name, so that search domains are not tried
+			name += "."
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), *dnsTimeout)
+		defer cancel()
+		return resolver.LookupTXT(ctx, name)
+	}
+
+	code := 0
+	enc := json.NewEncoder(stdout)
+	summary := dkim.NewBatchSummary()
+	err := dkim.VerifyBatch(r, *workers, func(res dkim.BatchResult) {
+		summary.Add(res)
+		if res.Err != nil {
+			code = 1
+		}
+		if err := enc.Encode(res); err != nil {
+			fmt.Fprintln(stderr, "dkim:", err)
+			code = 1
+		}
+	}, dkim.DNSOptLookupTXT(lookupTXT), dkim.VerifyOptKeyCache(dkim.NewKeyCache(*cacheTTL)))
+	if err != nil {
+		fmt.Fprintln(stderr, "dkim:", err)
+		code = 1
+	}
+	printSummary(stderr, summary)
+	return code
+}
+
+// printSummary prints s as text
+func printSummary(w io.Writer, s *dkim.BatchSummary) {
+	fmt.Fprintf(w, "messages: %d, unreadable: %d, unsigned: %d\n", s.Messages, s.Errors, s.Unsigned)
+	domains := make([]string, 0, len(s.Domains))
+	for domain := range s.Domains {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	for _, domain := range domains {
+		fmt.Fprintf(w, "domain %s: %d pass, %d fail\n", domain, s.Domains[domain].Pass, s.Domains[domain].Fail)
+	}
+	reasons := make([]string, 0, len(s.Reasons))
+	for reason := range s.Reasons {
+		reasons = append(reasons, reason)
+	}
+	sort.Strings(reasons)
+	for _, reason := range reasons {
+		fmt.Fprintf(w, "reason %s: %d\n", reason, s.Reasons[reason])
+	}
+}
+
+// filesReader reads messages from files, one per file
+type filesReader struct {
+	paths []string
+}
+
+// Next implements dkim.MessageReader
+func (f *filesReader) Next() (string, []byte, error) {
+	if len(f.paths) == 0 {
+		return "", nil, io.EOF
+	}
+	path := f.paths[0]
+	f.paths = f.paths[1:]
+	if path == "-" {
+		email, err := io.ReadAll(os.Stdin)
+		return path, email, err
+	}
+	email, err := os.ReadFile(path)
+	return path, email, err
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/toorop/go-dkim/dkimtest"
+)
+
+func TestVerify(t *testing.T) {
+	server, err := dkimtest.NewServer()
+	require.NoError(t, err)
+	defer server.Close()
+	key, err := dkimtest.NewKeyPair("ed25519-sha256")
+	require.NoError(t, err)
+	require.NoError(t, server.SetKey("s1", "example.com", key))
+
+	signed, err := key.Sign(dkimtest.Message(), "example.com", "s1")
+	require.NoError(t, err)
+	tampered := bytes.Replace(signed, []byte("Subject: "), []byte("Subject: Re: "), 1)
+	missing, err := key.Sign(dkimtest.Message(), "example.com", "missing")
+	require.NoError(t, err)
+
+	var mbox bytes.Buffer
+	for _, email := range [][]byte{signed, tampered, dkimtest.Message(), missing} {
+		mbox.WriteString("From joe@example.com Mon May  4 14:00:47 2015\r\n")
+		mbox.Write(email)
+		mbox.WriteString("\r\n")
+	}
+	path := filepath.Join(t.TempDir(), "mbox")
+	require.NoError(t, os.WriteFile(path, mbox.Bytes(), 0o644))
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"verify", "-mbox", path, "-dns", server.Addr(), "-workers", "2"}, &stdout, &stderr)
+	assert.Equal(t, 0, code, stderr.String())
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Len(t, lines, 4)
+	assert.Contains(t, lines[0], `"id":"1","signatures":[{"domain":"example.com","selector":"s1","algorithm":"ed25519-sha256","status":"SUCCESS"`)
+	assert.Contains(t, lines[1], `"id":"2","signatures":[{"domain":"example.com","selector":"s1","algorithm":"ed25519-sha256","status":"PERMFAIL"`)
+	assert.Contains(t, lines[2], `"id":"3","signatures":[{"status":"NOTSIGNED"`)
+	assert.Contains(t, lines[3], `"id":"4","signatures":[{"domain":"example.com","selector":"missing","algorithm":"ed25519-sha256","status":"PERMFAIL"`)
+	assert.Equal(t, "messages: 4, unreadable: 0, unsigned: 1\n"+
+		"domain example.com: 1 pass, 2 fail\n"+
+		"reason ed25519: verification error: 1\n"+
+		"reason no key for verify: nxdomain: 1\n", stderr.String())
+
+	assert.Equal(t, 2, run([]string{"verify"}, &stdout, &stderr))
+	assert.Equal(t, 2, run([]string{"verify", "-mbox", path, "-maildir", "dir"}, &stdout, &stderr))
+	stderr.Reset()
+	assert.Equal(t, 1, run([]string{"verify", filepath.Join(t.TempDir(), "missing.eml")}, &stdout, &stderr))
+}
@@ -0,0 +1,119 @@
+package dkim
+
+import (
+	"crypto/rsa"
+	"encoding/json"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// conformanceDir contains the conformance corpus (see README in this directory)
+const conformanceDir = "testdata/conformance"
+
+// conformanceCase is an entry of expected.json
+type conformanceCase struct {
+	File        string `json:"file"`
+	Description string `json:"description"`
+	Status      string `json:"status"`
+	Error       string `json:"error"`
+}
+
+var conformanceStatus = map[string]verifyOutput{
+	"SUCCESS":         SUCCESS,
+	"PERMFAIL":        PERMFAIL,
+	"TEMPFAIL":        TEMPFAIL,
+	"NOTSIGNED":       NOTSIGNED,
+	"TESTINGSUCCESS":  TESTINGSUCCESS,
+	"TESTINGPERMFAIL": TESTINGPERMFAIL,
+	"TESTINGTEMPFAIL": TESTINGTEMPFAIL,
+}
+
+var conformanceErrors = map[string]error{
+	"ErrVerifyBodyHash":            ErrVerifyBodyHash,
+	"ErrVerifyNoKeyForSignature":   ErrVerifyNoKeyForSignature,
+	"ErrVerifyRevokedKey":          ErrVerifyRevokedKey,
+	"ErrVerifySignatureHasExpired": ErrVerifySignatureHasExpired,
+	"ErrVerifyBadKeyType":          ErrVerifyBadKeyType,
+	"ErrVerifyEd25519Signature":    ErrVerifyEd25519Signature,
+	"ErrVerifyUnsignedBodyContent": ErrVerifyUnsignedBodyContent,
+	"ErrDkimHeaderBadFormat":       ErrDkimHeaderBadFormat,
+	"ErrDkimHeaderDomainMismatch":  ErrDkimHeaderDomainMismatch,
+	"ErrDkimHeaderNotFound":        ErrDkimHeaderNotFound,
+	"rsa.ErrVerification":          rsa.ErrVerification,
+}
+
+// conformanceResolver serves the key records of keys.json
+func conformanceResolver(t *testing.T) DNSOpt {
+	data, err := os.ReadFile(filepath.Join(conformanceDir, "keys.json"))
+	require.NoError(t, err)
+	keys := map[string][]string{}
+	require.NoError(t, json.Unmarshal(data, &keys))
+
+	return DNSOptLookupTXT(func(name string) ([]string, error) {
+		if records, ok := keys[name]; ok {
+			return records, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	})
+}
+
+func TestConformance(t *testing.T) {
+	data, err := os.ReadFile(filepath.Join(conformanceDir, "expected.json"))
+	require.NoError(t, err)
+	var cases []conformanceCase
+	require.NoError(t, json.Unmarshal(data, &cases))
+
+	// every message of the corpus must have an expected result
+	files, err := filepath.Glob(filepath.Join(conformanceDir, "*.eml"))
+	require.NoError(t, err)
+	require.Len(t, cases, len(files))
+
+	resolver := conformanceResolver(t)
+	for _, c := range cases {
+		c := c
+		t.Run(c.File, func(t *testing.T) {
+			email, err := os.ReadFile(filepath.Join(conformanceDir, c.File))
+			require.NoError(t, err)
+
+			status, ok := conformanceStatus[c.Status]
+			require.True(t, ok, "unknown status %q", c.Status)
+			var wantErr error
+			if c.Error != "" {
+				wantErr, ok = conformanceErrors[c.Error]
+				require.True(t, ok, "unknown error %q", c.Error)
+			}
+
+			res := VerifyWithResult(&email, resolver)
+			assert.Equal(t, status, res.Status, c.Description)
+			if wantErr == nil {
+				assert.NoError(t, res.Err, c.Description)
+			} else {
+				assert.ErrorIs(t, res.Err, wantErr, c.Description)
+			}
+		})
+	}
+}
+
+// conformanceSigners are the implementations the corpus must have messages
+// of, by file name prefix
+var conformanceSigners = []string{"opendkim-", "microsoft365-", "amazonses-", "gmail-"}
+
+func TestConformanceSigners(t *testing.T) {
+	var missing []string
+	for _, prefix := range conformanceSigners {
+		files, err := filepath.Glob(filepath.Join(conformanceDir, prefix+"*.eml"))
+		require.NoError(t, err)
+		if len(files) == 0 {
+			missing = append(missing, strings.TrimSuffix(prefix, "-"))
+		}
+	}
+	if len(missing) != 0 {
+		t.Skip("no message signed by " + strings.Join(missing, ", ") + " in the corpus (see " + conformanceDir + "/README.md)")
+	}
+}
@@ -0,0 +1,170 @@
+package dkim
+
+import (
+	"bufio"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Counters is an Observer counting events, Prometheus style:
+//
+//	dkim_sign_total{domain, result="ok|error"}
+//	dkim_sign_seconds_total{domain}
+//	dkim_verify_total{domain, status}
+//	dkim_key_lookup_total{cached="true|false", result="ok|error"}
+//	dkim_key_lookup_seconds_total (lookups not answered by a KeyCache)
+//
+// WritePrometheus writes them in the Prometheus text format, to be served
+// by a metrics handler. Counters is safe for concurrent use, and its zero
+// value is ready to use.
+//
+// The domain of verified signatures comes from the messages received: to
+// bound the number of series it is "other" unless LabelDomain returns it.
+type Counters struct {
+	// LabelDomain returns the domain label of a verified signature of
+	// domain, eg LabelDomains("example.com"). If nil, the label is "other".
+	LabelDomain func(domain string) string
+
+	mu     sync.Mutex
+	values map[string]float64
+}
+
+// LabelDomains returns a Counters.LabelDomain function keeping domains
+// (case insensitive) and labeling any other domain "other"
+func LabelDomains(domains ...string) func(string) string {
+	keep := make(map[string]bool, len(domains))
+	for _, d := range domains {
+		keep[strings.ToLower(d)] = true
+	}
+	return func(domain string) string {
+		if domain = strings.ToLower(domain); keep[domain] {
+			return domain
+		}
+		return "other"
+	}
+}
+
+// counterHelp documents the counters
+var counterHelp = map[string]string{
+	"dkim_sign_total":               "Signatures by domain and result.",
+	"dkim_sign_seconds_total":       "Time spent signing by domain.",
+	"dkim_verify_total":             "Verified signatures by domain and status.",
+	"dkim_key_lookup_total":         "Key lookups by result, answered by a cache or not.",
+	"dkim_key_lookup_seconds_total": "Time spent looking up keys in DNS.",
+}
+
+// NewCounters returns Counters set to zero, like new(Counters)
+func NewCounters() *Counters {
+	return &Counters{values: map[string]float64{}}
+}
+
+// counterKey returns the Prometheus series of metric with labels (name,
+// value pairs)
+func counterKey(metric string, labels ...string) string {
+	if len(labels) == 0 {
+		return metric
+	}
+	var b strings.Builder
+	b.WriteString(metric)
+	b.WriteByte('{')
+	for i := 0; i+1 < len(labels); i += 2 {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(labels[i])
+		b.WriteString(`="`)
+		b.WriteString(strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[i+1]))
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+// add adds v to the series of metric with labels
+func (c *Counters) add(v float64, metric string, labels ...string) {
+	key := counterKey(metric, labels...)
+	c.mu.Lock()
+	if c.values == nil {
+		c.values = map[string]float64{}
+	}
+	c.values[key] += v
+	c.mu.Unlock()
+}
+
+// Value returns the value of metric with labels given as name, value pairs
+// in the documented order, eg Value("dkim_verify_total", "domain",
+// "example.com", "status", "SUCCESS")
+func (c *Counters) Value(metric string, labels ...string) float64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.values[counterKey(metric, labels...)]
+}
+
+// result returns the result label of err
+func result(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "ok"
+}
+
+// OnSign counts a signature and the time spent signing it, by domain
+func (c *Counters) OnSign(e SignEvent) {
+	c.add(1, "dkim_sign_total", "domain", e.Domain, "result", result(e.Err))
+	c.add(e.Duration.Seconds(), "dkim_sign_seconds_total", "domain", e.Domain)
+}
+
+// OnVerifyResult counts a verification result, by status and by domain as
+// labeled by LabelDomain
+func (c *Counters) OnVerifyResult(e VerifyEvent) {
+	domain := "other"
+	if c.LabelDomain != nil {
+		domain = c.LabelDomain(e.Domain())
+	}
+	c.add(1, "dkim_verify_total", "domain", domain, "status", e.Result.Status.String())
+}
+
+// OnKeyLookup counts a key lookup, and the time spent looking up keys not
+// answered by a KeyCache
+func (c *Counters) OnKeyLookup(e KeyLookupEvent) {
+	c.add(1, "dkim_key_lookup_total", "cached", strconv.FormatBool(e.Cached), "result", result(e.Err))
+	if !e.Cached {
+		c.add(e.Duration.Seconds(), "dkim_key_lookup_seconds_total")
+	}
+}
+
+// WritePrometheus writes the counters in the Prometheus text format
+func (c *Counters) WritePrometheus(w io.Writer) error {
+	c.mu.Lock()
+	keys := make([]string, 0, len(c.values))
+	values := make(map[string]float64, len(c.values))
+	for key, v := range c.values {
+		keys = append(keys, key)
+		values[key] = v
+	}
+	c.mu.Unlock()
+	// series of a metric together
+	sort.Slice(keys, func(i, j int) bool {
+		name1, _, _ := strings.Cut(keys[i], "{")
+		name2, _, _ := strings.Cut(keys[j], "{")
+		if name1 != name2 {
+			return name1 < name2
+		}
+		return keys[i] < keys[j]
+	})
+
+	bw := bufio.NewWriter(w)
+	metric := ""
+	for _, key := range keys {
+		if name, _, _ := strings.Cut(key, "{"); name != metric {
+			metric = name
+			bw.WriteString("# HELP " + metric + " " + counterHelp[metric] + "\n")
+			bw.WriteString("# TYPE " + metric + " counter\n")
+		}
+		bw.WriteString(key + " " + strconv.FormatFloat(values[key], 'g', -1, 64) + "\n")
+	}
+	return bw.Flush()
+}
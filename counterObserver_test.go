@@ -0,0 +1,83 @@
+package dkim
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCounters(t *testing.T) {
+	c := NewCounters()
+	c.LabelDomain = LabelDomains("TMAIL.io")
+	c.OnSign(SignEvent{Domain: domain, Duration: 500 * time.Millisecond})
+	c.OnSign(SignEvent{Domain: domain, Duration: 250 * time.Millisecond})
+	c.OnSign(SignEvent{Domain: `ex"ample`, Err: ErrSignBadAlgo})
+	c.OnVerifyResult(VerifyEvent{Result: &VerifyResult{Status: SUCCESS, Header: &DKIMHeader{Domain: domain}}})
+	c.OnVerifyResult(VerifyEvent{Result: &VerifyResult{Status: PERMFAIL, Header: &DKIMHeader{Domain: domain}}})
+	c.OnVerifyResult(VerifyEvent{Result: &VerifyResult{Status: PERMFAIL, Header: &DKIMHeader{Domain: "spam.example"}}})
+	c.OnVerifyResult(VerifyEvent{Result: &VerifyResult{Status: NOTSIGNED}})
+	c.OnKeyLookup(KeyLookupEvent{Duration: 2 * time.Second})
+	c.OnKeyLookup(KeyLookupEvent{Cached: true, Duration: time.Second})
+	c.OnKeyLookup(KeyLookupEvent{Duration: time.Second, Err: errors.New("timeout")})
+
+	assert.Equal(t, 2.0, c.Value("dkim_sign_total", "domain", domain, "result", "ok"))
+	assert.Equal(t, 1.0, c.Value("dkim_verify_total", "domain", domain, "status", "PERMFAIL"))
+	assert.Equal(t, 0.0, c.Value("dkim_verify_total", "domain", domain, "status", "TEMPFAIL"))
+
+	var b strings.Builder
+	assert.NoError(t, c.WritePrometheus(&b))
+	assert.Equal(t, `# HELP dkim_key_lookup_seconds_total Time spent looking up keys in DNS.
+# TYPE dkim_key_lookup_seconds_total counter
+dkim_key_lookup_seconds_total 3
+# HELP dkim_key_lookup_total Key lookups by result, answered by a cache or not.
+# TYPE dkim_key_lookup_total counter
+dkim_key_lookup_total{cached="false",result="error"} 1
+dkim_key_lookup_total{cached="false",result="ok"} 1
+dkim_key_lookup_total{cached="true",result="ok"} 1
+# HELP dkim_sign_seconds_total Time spent signing by domain.
+# TYPE dkim_sign_seconds_total counter
+dkim_sign_seconds_total{domain="ex\"ample"} 0
+dkim_sign_seconds_total{domain="tmail.io"} 0.75
+# HELP dkim_sign_total Signatures by domain and result.
+# TYPE dkim_sign_total counter
+dkim_sign_total{domain="ex\"ample",result="error"} 1
+dkim_sign_total{domain="tmail.io",result="ok"} 2
+# HELP dkim_verify_total Verified signatures by domain and status.
+# TYPE dkim_verify_total counter
+dkim_verify_total{domain="other",status="NOTSIGNED"} 1
+dkim_verify_total{domain="other",status="PERMFAIL"} 1
+dkim_verify_total{domain="tmail.io",status="PERMFAIL"} 1
+dkim_verify_total{domain="tmail.io",status="SUCCESS"} 1
+`, b.String())
+}
+
+func TestCounters_DomainLabel(t *testing.T) {
+	c := NewCounters()
+	for _, d := range []string{"a.example", "b.example", domain} {
+		c.OnVerifyResult(VerifyEvent{Result: &VerifyResult{Status: SUCCESS, Header: &DKIMHeader{Domain: d}}})
+	}
+	assert.Equal(t, 3.0, c.Value("dkim_verify_total", "domain", "other", "status", "SUCCESS"))
+
+	c.LabelDomain = func(domain string) string { return domain }
+	c.OnVerifyResult(VerifyEvent{Result: &VerifyResult{Status: SUCCESS, Header: &DKIMHeader{Domain: domain}}})
+	assert.Equal(t, 1.0, c.Value("dkim_verify_total", "domain", domain, "status", "SUCCESS"))
+}
+
+func TestCounters_ZeroValue(t *testing.T) {
+	var zero Counters
+	assert.Equal(t, 0.0, zero.Value("dkim_sign_total", "domain", domain, "result", "ok"))
+	var b strings.Builder
+	assert.NoError(t, zero.WritePrometheus(&b))
+	assert.Empty(t, b.String())
+	zero.OnSign(SignEvent{Domain: domain})
+	assert.Equal(t, 1.0, zero.Value("dkim_sign_total", "domain", domain, "result", "ok"))
+
+	c := &Counters{LabelDomain: LabelDomains(domain)}
+	c.OnVerifyResult(VerifyEvent{Result: &VerifyResult{Status: SUCCESS, Header: &DKIMHeader{Domain: domain}}})
+	c.OnKeyLookup(KeyLookupEvent{Duration: time.Second})
+	assert.Equal(t, 1.0, c.Value("dkim_verify_total", "domain", domain, "status", "SUCCESS"))
+	assert.Equal(t, 1.0, c.Value("dkim_key_lookup_seconds_total"))
+}
@@ -3,8 +3,8 @@ package dkim
 
 import (
 	"bytes"
-	"container/list"
 	"crypto"
+	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/sha1"
@@ -13,8 +13,12 @@ import (
 	"encoding/base64"
 	"encoding/pem"
 	"hash"
-	"regexp"
+	"io"
+	"mime"
+	"net/mail"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -37,6 +41,27 @@ const (
 	TESTINGTEMPFAIL
 )
 
+// String returns the name of the verification state ("SUCCESS", ...)
+func (v verifyOutput) String() string {
+	switch v {
+	case SUCCESS:
+		return "SUCCESS"
+	case PERMFAIL:
+		return "PERMFAIL"
+	case TEMPFAIL:
+		return "TEMPFAIL"
+	case NOTSIGNED:
+		return "NOTSIGNED"
+	case TESTINGSUCCESS:
+		return "TESTINGSUCCESS"
+	case TESTINGPERMFAIL:
+		return "TESTINGPERMFAIL"
+	case TESTINGTEMPFAIL:
+		return "TESTINGTEMPFAIL"
+	}
+	return "verifyOutput(" + strconv.Itoa(int(v)) + ")"
+}
+
 // sigOptions represents signing options
 type SigOptions struct {
 	// DKIM version (default 1)
@@ -60,7 +85,7 @@ type SigOptions struct {
 	Canonicalization string
 
 	// The algorithm used to generate the signature
-	//"rsa-sha1" or "rsa-sha256"
+	//"rsa-sha1", "rsa-sha256" or "ed25519-sha256"
 	Algo string
 
 	// Signed header fields
@@ -80,6 +105,21 @@ type SigOptions struct {
 
 	// CopiedHeaderFileds
 	CopiedHeaderFields []string
+
+	// Request failure reports (r=y, RFC 6651)
+	RequestReports bool
+
+	// ATPSDomain is the author domain the signature is made for by a third
+	// party (atps=, RFC 6541). ATPSHash is the hash of the query name
+	// (atpsh=): "sha256" (default), "sha1" or "none".
+	ATPSDomain string
+	ATPSHash   string
+
+	// Policy for bare LF and bare CR (default LineEndingsAuto)
+	LineEndings LineEndings
+
+	// Observer, if not nil, is notified of each signature
+	Observer Observer
 }
 
 // NewSigOptions returns new sigoption with some defaults value
@@ -98,49 +138,206 @@ func NewSigOptions() SigOptions {
 
 // Sign signs an email
 func Sign(email *[]byte, options SigOptions) error {
-	var privateKey *rsa.PrivateKey
-	var err error
+	return SignMulti(email, options)
+}
 
-	// PrivateKey
-	if len(options.PrivateKey) == 0 {
-		return ErrSignPrivateKeyRequired
+// SignMulti signs an email once per options in a single pass, eg with RSA
+// and Ed25519 keys of the author domain and a key of the ESP domain.
+//
+// The message is parsed once per line endings policy and its body is
+// canonicalized and hashed once per canonicalization, hash algorithm and
+// body length. The signatures don't cover each other: their DKIM-Signature
+// headers are prepended in the order of options, the first one on top.
+// The email is left untouched if any of the signatures fails.
+func SignMulti(email *[]byte, options ...SigOptions) error {
+	dHeaders, err := signHeaders(*email, options)
+	if err != nil {
+		return err
 	}
-	d, _ := pem.Decode(options.PrivateKey)
-	if d == nil {
-		return ErrCandNotParsePrivateKey
+	size := len(*email)
+	for _, dHeader := range dHeaders {
+		size += len(dHeader)
 	}
+	signed := make([]byte, 0, size)
+	for _, dHeader := range dHeaders {
+		signed = append(signed, dHeader...)
+	}
+	*email = append(signed, *email...)
+	return nil
+}
 
-	// try to parse it as PKCS1 otherwise try PKCS8
-	if key, err := x509.ParsePKCS1PrivateKey(d.Bytes); err != nil {
-		if key, err := x509.ParsePKCS8PrivateKey(d.Bytes); err != nil {
-			return ErrCandNotParsePrivateKey
-		} else {
-			privateKey = key.(*rsa.PrivateKey)
+// SignHeader signs an email and returns the DKIM-Signature header field,
+// CRLF terminated, to prepend to it. The email is not modified.
+func SignHeader(email []byte, options SigOptions) (string, error) {
+	dHeaders, err := signHeaders(email, []SigOptions{options})
+	if err != nil {
+		return "", err
+	}
+	return dHeaders[0], nil
+}
+
+// SignReader signs a message given as its header fields (in message order,
+// eg "Subject: hello", folded lines included) and its body, and returns the
+// DKIM-Signature header field, CRLF terminated, to prepend to it.
+//
+// The body is canonicalized and hashed as it is read, it must use CRLF line
+// endings: options.LineEndings only applies to the header fields.
+func SignReader(header []string, body io.Reader, options SigOptions) (dHeader string, err error) {
+	start := time.Now()
+	defer func() {
+		observeSign(options, start, err)
+	}()
+	key, err := prepareSigOptions(&options)
+	if err != nil {
+		return "", err
+	}
+	rawHeaders := make([]byte, 0, 1024)
+	for _, h := range header {
+		rawHeaders = append(rawHeaders, strings.TrimRight(h, "\r\n")...)
+		rawHeaders = append(rawHeaders, CRLF...)
+	}
+	// line endings are normalized as in a message, with the empty line
+	// ending the header
+	rawHeaders = normalizeLineEndings(append(rawHeaders, CRLF...), options.LineEndings)
+	rawHeaders = rawHeaders[:len(rawHeaders)-len(CRLF)]
+
+	canonicalizations := strings.Split(options.Canonicalization, "/")
+	signHash := strings.Split(options.Algo, "-")
+	bodyHash, _, err := hashBodyReader(body, canonicalizations[1], signHash[1], options.BodyLength, nil)
+	if err != nil {
+		return "", err
+	}
+	return signatureHeader(rawHeaders, bodyHash, options, key)
+}
+
+// signHeaders returns a DKIM-Signature header field per options for email
+func signHeaders(email []byte, options []SigOptions) ([]string, error) {
+	options = append([]SigOptions(nil), options...)
+	keys := make([]crypto.Signer, len(options))
+	for i := range options {
+		start := time.Now()
+		key, err := prepareSigOptions(&options[i])
+		if err != nil {
+			observeSign(options[i], start, err)
+			return nil, err
 		}
-	} else {
-		privateKey = key
+		keys[i] = key
+	}
+
+	type message struct{ headers, body []byte }
+	type signHashKey struct {
+		lineEndings LineEndings
+		bodyHashKey
+	}
+	messages := map[LineEndings]message{}
+	bodyHashes := map[signHashKey]string{}
+
+	dHeaders := make([]string, len(options))
+	for i, o := range options {
+		start := time.Now()
+		dHeader, err := func() (string, error) {
+			// Normalize
+			msg, ok := messages[o.LineEndings]
+			if !ok {
+				rawHeaders, rawBody, err := getHeadersBody(&email, o.LineEndings)
+				if err != nil {
+					return "", err
+				}
+				msg = message{rawHeaders, rawBody}
+				messages[o.LineEndings] = msg
+			}
+
+			// hash body
+			bodyCano := strings.Split(o.Canonicalization, "/")[1]
+			signHash := strings.Split(o.Algo, "-")[1]
+			hashKey := signHashKey{o.LineEndings, bodyHashKey{bodyCano, signHash, o.BodyLength}}
+			bodyHash, ok := bodyHashes[hashKey]
+			if !ok {
+				var err error
+				if bodyHash, _, err = hashBody(msg.body, bodyCano, signHash, o.BodyLength, nil); err != nil {
+					return "", err
+				}
+				bodyHashes[hashKey] = bodyHash
+			}
+			return signatureHeader(msg.headers, bodyHash, o, keys[i])
+		}()
+		observeSign(o, start, err)
+		if err != nil {
+			return nil, err
+		}
+		dHeaders[i] = dHeader
+	}
+	return dHeaders, nil
+}
+
+// signatureHeader signs the header fields and body hash and returns the
+// DKIM-Signature header field. options must have been checked by
+// prepareSigOptions.
+func signatureHeader(rawHeaders []byte, bodyHash string, options SigOptions, key crypto.Signer) (string, error) {
+	canonicalizations := strings.Split(options.Canonicalization, "/")
+	headers, err := canonicalizeHeaders(rawHeaders, canonicalizations[0], options.Headers)
+	if err != nil {
+		return "", err
+	}
+
+	// Get dkim header base
+	dkimHeader := newDkimHeaderBySigOptions(options)
+	dHeader := dkimHeader.getHeaderBaseForSigning(bodyHash)
+
+	dHeaderCanonicalized, err := canonicalizeHeader(dHeader, canonicalizations[0])
+	if err != nil {
+		return "", err
+	}
+	headers = append(headers, dHeaderCanonicalized...)
+	headers = bytes.TrimRight(headers, " \r\n")
+
+	// sign
+	sig, err := getSignature(&headers, key, strings.Split(options.Algo, "-")[1])
+	if err != nil {
+		return "", err
+	}
+
+	// DKIM-Header
+	signed := make([]byte, 0, len(dHeader)+len(sig)+len(sig)/MaxHeaderLineLength*len(FWS)+len(CRLF))
+	signed = append(signed, dHeader...)
+	signed = appendFolded(signed, sig)
+	return string(append(signed, CRLF...)), nil
+}
+
+// prepareSigOptions checks and normalizes options and returns the private key
+func prepareSigOptions(options *SigOptions) (crypto.Signer, error) {
+	// PrivateKey
+	if len(options.PrivateKey) == 0 {
+		return nil, ErrSignPrivateKeyRequired
+	}
+	privateKey, err := parsePrivateKey(options.PrivateKey)
+	if err != nil {
+		return nil, err
 	}
 
 	// Domain required
 	if options.Domain == "" {
-		return ErrSignDomainRequired
+		return nil, ErrSignDomainRequired
 	}
 
 	// Selector required
 	if options.Selector == "" {
-		return ErrSignSelectorRequired
+		return nil, ErrSignSelectorRequired
 	}
 
 	// Canonicalization
 	options.Canonicalization, err = validateCanonicalization(strings.ToLower(options.Canonicalization))
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// Algo
 	options.Algo = strings.ToLower(options.Algo)
-	if options.Algo != "rsa-sha1" && options.Algo != "rsa-sha256" {
-		return ErrSignBadAlgo
+	if !isValidAlgo(options.Algo) {
+		return nil, ErrSignBadAlgo
+	}
+	if keyType(privateKey.Public()) != strings.Split(options.Algo, "-")[0] {
+		return nil, ErrSignKeyTypeMismatch
 	}
 
 	// Header must contain "from"
@@ -153,81 +350,308 @@ func Sign(email *[]byte, options SigOptions) error {
 		}
 	}
 	if !hasFrom {
-		return ErrSignHeaderShouldContainsFrom
+		return nil, ErrSignHeaderShouldContainsFrom
 	}
 
-	// Normalize
-	headers, body, err := canonicalize(email, options.Canonicalization, options.Headers)
-	if err != nil {
-		return err
+	// ATPS
+	if options.ATPSDomain != "" {
+		options.ATPSDomain = strings.ToLower(options.ATPSDomain)
+		options.ATPSHash = strings.ToLower(options.ATPSHash)
+		if options.ATPSHash == "" {
+			options.ATPSHash = "sha256"
+		}
+		if !isValidATPSHash(options.ATPSHash) {
+			return nil, ErrATPSBadHash
+		}
 	}
+	return privateKey, nil
+}
 
-	signHash := strings.Split(options.Algo, "-")
-
-	// hash body
-	bodyHash, err := getBodyHash(&body, signHash[1], options.BodyLength)
-	if err != nil {
-		return err
+// appendFolded appends s to dst inserting FWS every MaxHeaderLineLength chars
+func appendFolded(dst []byte, s string) []byte {
+	for len(s) >= MaxHeaderLineLength {
+		dst = append(dst, s[:MaxHeaderLineLength]...)
+		dst = append(dst, FWS...)
+		s = s[MaxHeaderLineLength:]
 	}
+	return append(dst, s...)
+}
 
-	// Get dkim header base
-	dkimHeader := newDkimHeaderBySigOptions(options)
-	dHeader := dkimHeader.getHeaderBaseForSigning(bodyHash)
+// VerifyResult holds the detailed outcome of a verification
+type VerifyResult struct {
+	// Status is the verification state (SUCCESS, PERMFAIL, ...)
+	Status verifyOutput
 
-	canonicalizations := strings.Split(options.Canonicalization, "/")
-	dHeaderCanonicalized, err := canonicalizeHeader(dHeader, canonicalizations[0])
-	if err != nil {
-		return err
-	}
-	headers = append(headers, []byte(dHeaderCanonicalized)...)
-	headers = bytes.TrimRight(headers, " \r\n")
+	// Err is the error that lead to Status, if any
+	Err error
 
-	// sign
-	sig, err := getSignature(&headers, privateKey, signHash[1])
-
-	// add to DKIM-Header
-	subh := ""
-	l := len(subh)
-	for _, c := range sig {
-		subh += string(c)
-		l++
-		if l >= MaxHeaderLineLength {
-			dHeader += subh + FWS
-			subh = ""
-			l = 0
-		}
-	}
-	dHeader += subh + CRLF
-	*email = append([]byte(dHeader), *email...)
-	return nil
+	// Header is the DKIM-Signature that was verified (nil if none could be parsed)
+	Header *DKIMHeader
+
+	// BodyLength is the length of the canonicalized body
+	BodyLength int64
+
+	// UnsignedBodyBytes is the number of canonicalized body octets that
+	// follow the part covered by the l= tag. These octets may have been
+	// appended by anybody after signing.
+	UnsignedBodyBytes int64
+
+	// UnsignedMIMEPart is true when the unsigned content contains a MIME
+	// boundary delimiter, ie when a new MIME part may have been appended.
+	UnsignedMIMEPart bool
+
+	// KeySource tells where the key record comes from (KeySourceNone if
+	// no key could be retrieved)
+	KeySource KeySource
+
+	// ATPS tells if the author domain authorizes the signature (RFC 6541),
+	// ATPSNone if the signature has no atps= tag or didn't verify
+	ATPS ATPSStatus
+}
+
+// BodyLengthPolicy defines how signatures using the l= tag are handled
+// when content follows the signed part of the body.
+type BodyLengthPolicy struct {
+	// MaxUnsignedBytes is the number of unsigned body octets tolerated.
+	// Zero or a negative value means no limit.
+	MaxUnsignedBytes int64
+
+	// RejectUnsignedContent makes verification fail when any content
+	// follows the signed part of the body
+	RejectUnsignedContent bool
+
+	// RejectMIMEParts makes verification fail when the unsigned content
+	// contains a MIME boundary delimiter.
+	RejectMIMEParts bool
+}
+
+// VerifyOptions holds settings for verifying signatures
+type VerifyOptions struct {
+	DNSOptions
+
+	bodyLengthPolicy *BodyLengthPolicy
+	lineEndings      LineEndings
+	keyStore         *KeyStore
+	keyCache         *KeyCache
+}
+
+// VerifyOpt represents an optional setting for verifying signatures
+type VerifyOpt interface {
+	applyVerify(*VerifyOptions)
+}
+
+type verifyOpt func(*VerifyOptions)
+
+func (opt verifyOpt) applyVerify(verifyOpts *VerifyOptions) {
+	opt(verifyOpts)
+}
+
+// VerifyOptBodyLengthPolicy sets the policy applied to signatures using the
+// l= tag. Without it, unsigned content is only reported in VerifyResult.
+//
+// Appending content (for example a new MIME part) after the signed part of
+// a body is a known way to abuse l= signatures.
+func VerifyOptBodyLengthPolicy(policy BodyLengthPolicy) VerifyOpt {
+	return verifyOpt(func(opts *VerifyOptions) {
+		opts.bodyLengthPolicy = &policy
+	})
+}
+
+// VerifyOptLineEndings sets the policy for bare LF and bare CR
+// (default LineEndingsAuto). Messages containing bare LF or bare CR only
+// verify if the signer applied the same policy.
+func VerifyOptLineEndings(policy LineEndings) VerifyOpt {
+	return verifyOpt(func(opts *VerifyOptions) {
+		opts.lineEndings = policy
+	})
 }
 
 // Verify verifies an email an return
 // state: SUCCESS or PERMFAIL or TEMPFAIL, TESTINGSUCCESS, TESTINGPERMFAIL
 // TESTINGTEMPFAIL or NOTSIGNED
 // error: if an error occurs during verification
-func Verify(email *[]byte, opts ...DNSOpt) (verifyOutput, error) {
+func Verify(email *[]byte, opts ...VerifyOpt) (verifyOutput, error) {
+	res := VerifyWithResult(email, opts...)
+	return res.Status, res.Err
+}
+
+// VerifyWithResult verifies an email like Verify but returns a detailed result
+func VerifyWithResult(email *[]byte, opts ...VerifyOpt) *VerifyResult {
+	verifyOpts := VerifyOptions{}
+	for _, opt := range opts {
+		opt.applyVerify(&verifyOpts)
+	}
+	res := new(VerifyResult)
+	start := time.Now()
+
 	// parse email
 	dkimHeader, err := GetHeader(email)
 	if err != nil {
 		if err == ErrDkimHeaderNotFound {
-			return NOTSIGNED, ErrDkimHeaderNotFound
+			return verifyOpts.observeVerify(res.set(NOTSIGNED, ErrDkimHeaderNotFound, false), start)
 		}
-		return PERMFAIL, err
+		return verifyOpts.observeVerify(res.set(PERMFAIL, err, false), start)
 	}
+	return verifyDkimHeader(newVerifyMessage(email, verifyOpts.lineEndings), dkimHeader, &verifyOpts)
+}
+
+// VerifyAll verifies every DKIM-Signature of email and returns a result per
+// signature, in message order. Malformed signatures get a PERMFAIL result
+// without Header. If email has no signature, the only result is NOTSIGNED.
+func VerifyAll(email *[]byte, opts ...VerifyOpt) []*VerifyResult {
+	verifyOpts := VerifyOptions{}
+	for _, opt := range opts {
+		opt.applyVerify(&verifyOpts)
+	}
+	start := time.Now()
+	dkimHeaders, results := parseSignatures(email)
+	msg := newVerifyMessage(email, verifyOpts.lineEndings)
+	for i, dkimHeader := range dkimHeaders {
+		if dkimHeader != nil {
+			results[i] = verifyDkimHeader(msg, dkimHeader, &verifyOpts)
+		} else {
+			verifyOpts.observeVerify(results[i], start)
+		}
+	}
+	return results
+}
+
+// parseSignatures parses the DKIM-Signature fields of email. The result of
+// signatures which can't be parsed is set in results and their header is
+// nil. A message which can't be parsed or has no signature gets a single
+// result.
+func parseSignatures(email *[]byte) (dkimHeaders []*DKIMHeader, results []*VerifyResult) {
+	fail := func(status verifyOutput, err error) ([]*DKIMHeader, []*VerifyResult) {
+		return []*DKIMHeader{nil}, []*VerifyResult{new(VerifyResult).set(status, err, false)}
+	}
+	if _, err := mail.ReadMessage(bytes.NewReader(*email)); err != nil {
+		return fail(PERMFAIL, err)
+	}
+	dkHeaders, err := getRawDkimHeaders(email)
+	if err != nil {
+		return fail(PERMFAIL, err)
+	}
+	if len(dkHeaders) == 0 {
+		return fail(NOTSIGNED, ErrDkimHeaderNotFound)
+	}
+	dkimHeaders = make([]*DKIMHeader, len(dkHeaders))
+	results = make([]*VerifyResult, len(dkHeaders))
+	for i, h := range dkHeaders {
+		dkimHeader, err := parseDkHeader(h)
+		if err != nil {
+			results[i] = new(VerifyResult).set(PERMFAIL, err, false)
+			continue
+		}
+		dkimHeaders[i] = dkimHeader
+	}
+	return dkimHeaders, results
+}
+
+// verifyMessage is a message being verified. It is parsed, and its body
+// hashed, once for all its signatures. It is safe for concurrent use.
+type verifyMessage struct {
+	email       *[]byte
+	lineEndings LineEndings
+
+	parseOnce  sync.Once
+	rawHeaders []byte
+	rawBody    []byte
+	parseErr   error
+
+	mu         sync.Mutex
+	bodyHashes map[bodyHashKey]*bodyHashResult
+}
+
+// bodyHashKey identifies the body hashes of a message: signatures with the
+// same body canonicalization, hash algorithm and l= share the body hash
+type bodyHashKey struct {
+	cano, hash string
+	bodyLength uint
+}
+
+type bodyHashResult struct {
+	once     sync.Once
+	hash     string
+	length   int64
+	unsigned []byte
+	err      error
+}
+
+func newVerifyMessage(email *[]byte, lineEndings LineEndings) *verifyMessage {
+	return &verifyMessage{email: email, lineEndings: lineEndings, bodyHashes: map[bodyHashKey]*bodyHashResult{}}
+}
+
+// headersBody returns the raw headers and body of the message
+func (m *verifyMessage) headersBody() ([]byte, []byte, error) {
+	m.parseOnce.Do(func() {
+		m.rawHeaders, m.rawBody, m.parseErr = getHeadersBody(m.email, m.lineEndings)
+	})
+	return m.rawHeaders, m.rawBody, m.parseErr
+}
+
+// bodyHash returns the body hash for key, and the part of the body not
+// covered by l=. The body must have been parsed by headersBody.
+func (m *verifyMessage) bodyHash(key bodyHashKey) *bodyHashResult {
+	m.mu.Lock()
+	r, ok := m.bodyHashes[key]
+	if !ok {
+		r = &bodyHashResult{}
+		m.bodyHashes[key] = r
+	}
+	m.mu.Unlock()
+
+	r.once.Do(func() {
+		var unsigned bytes.Buffer
+		var rest io.Writer
+		if key.bodyLength != 0 {
+			rest = &unsigned
+		}
+		r.hash, r.length, r.err = hashBody(m.rawBody, key.cano, key.hash, key.bodyLength, rest)
+		r.unsigned = unsigned.Bytes()
+	})
+	return r
+}
+
+// verifyDkimHeader verifies the signature dkimHeader of msg
+func verifyDkimHeader(msg *verifyMessage, dkimHeader *DKIMHeader, verifyOpts *VerifyOptions) *VerifyResult {
+	start := time.Now()
+	return verifyOpts.observeVerify(checkDkimHeader(msg, dkimHeader, verifyOpts), start)
+}
+
+// checkDkimHeader verifies the signature dkimHeader of msg
+func checkDkimHeader(msg *verifyMessage, dkimHeader *DKIMHeader, verifyOpts *VerifyOptions) *VerifyResult {
+	res := &VerifyResult{Header: dkimHeader}
 
 	// we do not set query method because if it's others, validation failed earlier
-	pubKey, verifyOutputOnError, err := NewPubKeyRespFromDNS(dkimHeader.Selector, dkimHeader.Domain, opts...)
+	pubKey, verifyOutputOnError, err := getPubKey(dkimHeader, verifyOpts, res)
 	if err != nil {
 		// fix https://github.com/toorop/go-dkim/issues/1
 		// return getVerifyOutput(verifyOutputOnError, err, pubKey.FlagTesting)
-		return verifyOutputOnError, err
+		return res.set(verifyOutputOnError, err, false)
+	}
+
+	// key must be usable for email (s= tag)
+	if !pubKey.allowsEmail() {
+		return res.set(PERMFAIL, ErrVerifyKeyServiceType, pubKey.FlagTesting)
+	}
+
+	// t=s, the i= domain must be the same as d= (no subdomain)
+	if pubKey.FlagIMustBeD {
+		auidDomain := dkimHeader.Auid[strings.LastIndex(dkimHeader.Auid, "@")+1:]
+		if !strings.EqualFold(auidDomain, dkimHeader.Domain) {
+			return res.set(PERMFAIL, ErrVerifyIMustBeD, pubKey.FlagTesting)
+		}
 	}
 
 	// Normalize
-	headers, body, err := canonicalize(email, dkimHeader.MessageCanonicalization, dkimHeader.Headers)
+	rawHeaders, _, err := msg.headersBody()
 	if err != nil {
-		return getVerifyOutput(PERMFAIL, err, pubKey.FlagTesting)
+		return res.set(PERMFAIL, err, pubKey.FlagTesting)
+	}
+	canonicalizations := strings.Split(dkimHeader.MessageCanonicalization, "/")
+	headers, err := canonicalizeHeaders(rawHeaders, canonicalizations[0], dkimHeader.Headers)
+	if err != nil {
+		return res.set(PERMFAIL, err, pubKey.FlagTesting)
 	}
 	sigHash := strings.Split(dkimHeader.Algorithm, "-")
 	// check if hash algo are compatible
@@ -239,38 +663,143 @@ func Verify(email *[]byte, opts ...DNSOpt) (verifyOutput, error) {
 		}
 	}
 	if !compatible {
-		return getVerifyOutput(PERMFAIL, ErrVerifyInappropriateHashAlgo, pubKey.FlagTesting)
+		return res.set(PERMFAIL, ErrVerifyInappropriateHashAlgo, pubKey.FlagTesting)
+	}
+	if sigKeyType := strings.Split(dkimHeader.Algorithm, "-")[0]; sigKeyType != pubKey.KeyType {
+		return res.set(PERMFAIL, ErrVerifyBadKeyType, pubKey.FlagTesting)
 	}
 
 	// expired ?
 	if !dkimHeader.SignatureExpiration.IsZero() && dkimHeader.SignatureExpiration.Before(time.Now()) {
-		return getVerifyOutput(PERMFAIL, ErrVerifySignatureHasExpired, pubKey.FlagTesting)
+		return res.set(PERMFAIL, ErrVerifySignatureHasExpired, pubKey.FlagTesting)
 	}
 
-	// println("|" + string(body) + "|")
-	// get body hash
-	bodyHash, err := getBodyHash(&body, sigHash[1], dkimHeader.BodyLength)
-	if err != nil {
-		return getVerifyOutput(PERMFAIL, err, pubKey.FlagTesting)
+	// get body hash, keep the part of the body not covered by l=
+	bodyHash := msg.bodyHash(bodyHashKey{canonicalizations[1], sigHash[1], dkimHeader.BodyLength})
+	if bodyHash.err != nil {
+		return res.set(PERMFAIL, bodyHash.err, pubKey.FlagTesting)
 	}
-	// println(bodyHash)
-	if bodyHash != dkimHeader.BodyHash {
-		return getVerifyOutput(PERMFAIL, ErrVerifyBodyHash, pubKey.FlagTesting)
+	if bodyHash.hash != dkimHeader.BodyHash {
+		return res.set(PERMFAIL, ErrVerifyBodyHash, pubKey.FlagTesting)
+	}
+
+	// content appended after the signed part of the body (l= tag)
+	res.BodyLength = bodyHash.length
+	if dkimHeader.BodyLength != 0 {
+		res.UnsignedBodyBytes = int64(len(bodyHash.unsigned))
+		res.UnsignedMIMEPart = hasMIMEBoundary(msg.email, bodyHash.unsigned)
+		if p := verifyOpts.bodyLengthPolicy; p != nil {
+			if (p.RejectUnsignedContent && res.UnsignedBodyBytes > 0) ||
+				(p.MaxUnsignedBytes > 0 && res.UnsignedBodyBytes > p.MaxUnsignedBytes) {
+				return res.set(PERMFAIL, ErrVerifyUnsignedBodyContent, pubKey.FlagTesting)
+			}
+			if p.RejectMIMEParts && res.UnsignedMIMEPart {
+				return res.set(PERMFAIL, ErrVerifyUnsignedMIMEPart, pubKey.FlagTesting)
+			}
+		}
 	}
 
 	// compute sig
-	dkimHeaderCano, err := canonicalizeHeader(dkimHeader.rawForSign, strings.Split(dkimHeader.MessageCanonicalization, "/")[0])
+	dkimHeaderCano, err := canonicalizeHeader(dkimHeader.rawForSign, canonicalizations[0])
 	if err != nil {
-		return getVerifyOutput(TEMPFAIL, err, pubKey.FlagTesting)
+		return res.set(TEMPFAIL, err, pubKey.FlagTesting)
 	}
-	toSignStr := string(headers) + dkimHeaderCano
-	toSign := bytes.TrimRight([]byte(toSignStr), " \r\n")
+	toSign := bytes.TrimRight(append(headers, dkimHeaderCano...), " \r\n")
 
-	err = verifySignature(toSign, dkimHeader.SignatureData, &pubKey.PubKey, sigHash[1])
+	err = verifySignature(toSign, dkimHeader.SignatureData, pubKey, sigHash[1])
 	if err != nil {
-		return getVerifyOutput(PERMFAIL, err, pubKey.FlagTesting)
+		return res.set(PERMFAIL, err, pubKey.FlagTesting)
+	}
+	res.ATPS = checkATPS(rawHeaders, dkimHeader, verifyOpts)
+	return res.set(SUCCESS, nil, false)
+}
+
+// getPubKey retrieves the key of the signature from the key store or DNS
+// and records its source in res
+func getPubKey(dkimHeader *DKIMHeader, verifyOpts *VerifyOptions, res *VerifyResult) (*PubKeyRep, verifyOutput, error) {
+	if verifyOpts.keyStore != nil {
+		at := dkimHeader.SignatureTimestamp
+		if at.IsZero() {
+			at = time.Now()
+		}
+		name := dkimHeader.Selector + "._domainkey." + dkimHeader.Domain
+		if records := verifyOpts.keyStore.Lookup(name, at); len(records) > 0 {
+			pubKey, vo, err := newPubKeyRespFromRecords(name, records, verifyOpts.multipleRecords)
+			if err == nil {
+				res.KeySource = KeySourceLocal
+			}
+			return pubKey, vo, err
+		}
+	}
+	fetch := func() (*PubKeyRep, verifyOutput, error) {
+		return NewPubKeyRespFromDNS(dkimHeader.Selector, dkimHeader.Domain, dnsOpt(func(opts *DNSOptions) {
+			*opts = verifyOpts.DNSOptions
+		}))
+	}
+	var pubKey *PubKeyRep
+	var vo verifyOutput
+	var err error
+	if verifyOpts.keyCache != nil {
+		name := dkimHeader.Selector + "._domainkey." + dkimHeader.Domain
+		start := time.Now()
+		var cached bool
+		pubKey, vo, cached, err = verifyOpts.keyCache.lookup(name, fetch)
+		if cached {
+			verifyOpts.observeKeyLookup(name, true, start, err)
+		}
+	} else {
+		pubKey, vo, err = fetch()
+	}
+	if err == nil {
+		res.KeySource = KeySourceDNS
+	}
+	return pubKey, vo, err
+}
+
+// set sets status and error of the result according to the testing flag
+func (r *VerifyResult) set(status verifyOutput, err error, flagTesting bool) *VerifyResult {
+	r.Status, r.Err = getVerifyOutput(status, err, flagTesting)
+	return r
+}
+
+// hasMIMEBoundary reports whether content contains the delimiter of a
+// boundary declared by the message: the top-level boundary or the boundary
+// of a nested multipart part
+func hasMIMEBoundary(email *[]byte, content []byte) bool {
+	var delimiters [][]byte
+	for _, boundary := range mimeBoundaries(*email) {
+		delimiters = append(delimiters, []byte("--"+boundary))
+	}
+	if len(delimiters) == 0 {
+		return false
+	}
+	for _, line := range bytes.Split(content, []byte(CRLF)) {
+		for _, delimiter := range delimiters {
+			if bytes.HasPrefix(line, delimiter) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// mimeBoundaries returns the boundaries declared by the Content-Type fields
+// of email, in its header and in the header of its parts
+func mimeBoundaries(email []byte) []string {
+	var boundaries []string
+	unfolded := bytes.ReplaceAll(normalizeLineEndings(email, LineEndingsAuto), []byte(CRLF+" "), []byte(" "))
+	unfolded = bytes.ReplaceAll(unfolded, []byte(CRLF+"\t"), []byte(" "))
+	for _, line := range bytes.Split(unfolded, []byte(CRLF)) {
+		name, value, found := bytes.Cut(line, []byte(":"))
+		if !found || !strings.EqualFold(string(bytes.TrimSpace(name)), "content-type") {
+			continue
+		}
+		mediaType, params, err := mime.ParseMediaType(string(bytes.TrimSpace(value)))
+		if err == nil && strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "" {
+			boundaries = append(boundaries, params["boundary"])
+		}
 	}
-	return SUCCESS, nil
+	return boundaries
 }
 
 // getVerifyOutput returns output of verify fct according to the testing flag
@@ -292,10 +821,7 @@ func getVerifyOutput(status verifyOutput, err error, flagTesting bool) (verifyOu
 
 // canonicalize returns canonicalized version of header and body
 func canonicalize(email *[]byte, cano string, h []string) (headers, body []byte, err error) {
-	body = []byte{}
-	rxReduceWS := regexp.MustCompile(`[ \t]+`)
-
-	rawHeaders, rawBody, err := getHeadersBody(email)
+	rawHeaders, rawBody, err := getHeadersBody(email, LineEndingsAuto)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -303,73 +829,57 @@ func canonicalize(email *[]byte, cano string, h []string) (headers, body []byte,
 	canonicalizations := strings.Split(cano, "/")
 
 	// canonicalyze header
+	headers, err = canonicalizeHeaders(rawHeaders, canonicalizations[0], h)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// canonicalyze body
+	buf := bytes.NewBuffer(make([]byte, 0, len(rawBody)))
+	bc, err := NewBodyCanonicalizer(buf, canonicalizations[1], 0)
+	if err != nil {
+		return nil, nil, err
+	}
+	bc.Write(rawBody)
+	bc.Close()
+	return headers, buf.Bytes(), nil
+}
+
+// canonicalizeHeaders returns the canonicalized header fields listed in h
+func canonicalizeHeaders(rawHeaders []byte, algo string, h []string) ([]byte, error) {
 	headersList, err := getHeadersList(&rawHeaders)
+	if err != nil {
+		return nil, err
+	}
+	names := make([]string, len(headersList))
+	for i, header := range headersList {
+		name := header
+		if colon := strings.IndexByte(header, ':'); colon != -1 {
+			name = header[:colon]
+		}
+		names[i] = strings.ToLower(strings.TrimRight(name, " \t"))
+	}
 
-	// pour chaque header a conserver on traverse tous les headers dispo
+	// for each header to keep we look at all available headers
 	// If multi instance of a field we must keep it from the bottom to the top
-	var match *list.Element
-	headersToKeepList := list.New()
-
+	used := make([]bool, len(headersList))
+	headers := make([]byte, 0, len(rawHeaders))
 	for _, headerToKeep := range h {
-		match = nil
 		headerToKeepToLower := strings.ToLower(headerToKeep)
-		for e := headersList.Front(); e != nil; e = e.Next() {
-			// fmt.Printf("|%s|\n", e.Value.(string))
-			t := strings.Split(e.Value.(string), ":")
-			if strings.ToLower(t[0]) == headerToKeepToLower {
-				match = e
+		for i := len(names) - 1; i >= 0; i-- {
+			if used[i] || names[i] != headerToKeepToLower {
+				continue
 			}
+			used[i] = true
+			cHeader, err := canonicalizeHeader(headersList[i]+CRLF, algo)
+			if err != nil {
+				return headers, err
+			}
+			headers = append(headers, cHeader...)
+			break
 		}
-		if match != nil {
-			headersToKeepList.PushBack(match.Value.(string) + "\r\n")
-			headersList.Remove(match)
-		}
-	}
-
-	// if canonicalizations[0] == "simple" {
-	for e := headersToKeepList.Front(); e != nil; e = e.Next() {
-		cHeader, err := canonicalizeHeader(e.Value.(string), canonicalizations[0])
-		if err != nil {
-			return headers, body, err
-		}
-		headers = append(headers, []byte(cHeader)...)
-	}
-	// canonicalyze body
-	if canonicalizations[1] == "simple" {
-		// simple
-		// The "simple" body canonicalization algorithm ignores all empty lines
-		// at the end of the message body.  An empty line is a line of zero
-		// length after removal of the line terminator.  If there is no body or
-		// no trailing CRLF on the message body, a CRLF is added.  It makes no
-		// other changes to the message body.  In more formal terms, the
-		// "simple" body canonicalization algorithm converts "*CRLF" at the end
-		// of the body to a single "CRLF".
-		// Note that a completely empty or missing body is canonicalized as a
-		// single "CRLF"; that is, the canonicalized length will be 2 octets.
-		body = bytes.TrimRight(rawBody, "\r\n")
-		body = append(body, []byte{13, 10}...)
-	} else {
-		// relaxed
-		// Ignore all whitespace at the end of lines.  Implementations
-		// MUST NOT remove the CRLF at the end of the line.
-		// Reduce all sequences of WSP within a line to a single SP
-		// character.
-		// Ignore all empty lines at the end of the message body.  "Empty
-		// line" is defined in Section 3.4.3.  If the body is non-empty but
-		// does not end with a CRLF, a CRLF is added.  (For email, this is
-		// only possible when using extensions to SMTP or non-SMTP transport
-		// mechanisms.)
-		rawBody = rxReduceWS.ReplaceAll(rawBody, []byte(" "))
-		for _, line := range bytes.SplitAfter(rawBody, []byte{10}) {
-			line = bytes.TrimRight(line, " \r\n")
-			body = append(body, line...)
-			body = append(body, []byte{13, 10}...)
-		}
-		body = bytes.TrimRight(body, "\r\n")
-		body = append(body, []byte{13, 10}...)
-
 	}
-	return
+	return headers, nil
 }
 
 // canonicalizeHeader returns canonicalized version of header
@@ -419,29 +929,78 @@ func canonicalizeHeader(header string, algo string) (string, error) {
 	return header, ErrSignBadCanonicalization
 }
 
-// getBodyHash return the hash (bas64encoded) of the body
-func getBodyHash(body *[]byte, algo string, bodyLength uint) (string, error) {
+// hashBody canonicalizes body and returns its hash (base64 encoded) and the
+// length of the canonicalized body.
+// If bodyLength is not 0 only the first bodyLength octets are hashed (l tag),
+// the remaining octets are written to rest if it's not nil.
+func hashBody(body []byte, cano, algo string, bodyLength uint, rest io.Writer) (string, int64, error) {
+	return hashBodyReader(bytes.NewReader(body), cano, algo, bodyLength, rest)
+}
+
+// hashBodyReader is hashBody reading the body from r
+func hashBodyReader(r io.Reader, cano, algo string, bodyLength uint, rest io.Writer) (string, int64, error) {
 	var h hash.Hash
 	if algo == "sha1" {
 		h = sha1.New()
 	} else {
 		h = sha256.New()
 	}
-	toH := *body
-	// if l tag (body length)
-	if bodyLength != 0 {
-		if uint(len(toH)) < bodyLength {
-			return "", ErrBadDKimTagLBodyTooShort
-		}
-		toH = toH[0:bodyLength]
+	bc, err := NewBodyCanonicalizer(h, cano, bodyLength)
+	if err != nil {
+		return "", 0, err
+	}
+	bc.rest = rest
+	if _, err = io.Copy(bc, r); err != nil {
+		return "", 0, err
 	}
+	if err = bc.Close(); err != nil {
+		return "", 0, err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), bc.Len(), nil
+}
 
-	h.Write(toH)
-	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+// parsePrivateKey parses a PEM encoded private key (PKCS1 or PKCS8, RSA or Ed25519)
+func parsePrivateKey(pemKey []byte) (crypto.Signer, error) {
+	d, _ := pem.Decode(pemKey)
+	if d == nil {
+		return nil, ErrCandNotParsePrivateKey
+	}
+
+	// try to parse it as PKCS1 otherwise try PKCS8
+	if key, err := x509.ParsePKCS1PrivateKey(d.Bytes); err == nil {
+		return key, nil
+	}
+	key, err := x509.ParsePKCS8PrivateKey(d.Bytes)
+	if err != nil {
+		return nil, ErrCandNotParsePrivateKey
+	}
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		return key, nil
+	case ed25519.PrivateKey:
+		return key, nil
+	}
+	return nil, ErrCandNotParsePrivateKey
+}
+
+// keyType returns the DKIM key type (k tag) of a public key
+func keyType(key crypto.PublicKey) string {
+	switch key.(type) {
+	case *rsa.PublicKey:
+		return "rsa"
+	case ed25519.PublicKey:
+		return "ed25519"
+	}
+	return ""
+}
+
+// isValidAlgo returns true if algo is a supported signing algorithm (a tag)
+func isValidAlgo(algo string) bool {
+	return algo == "rsa-sha1" || algo == "rsa-sha256" || algo == "ed25519-sha256"
 }
 
 // getSignature return signature of toSign using key
-func getSignature(toSign *[]byte, key *rsa.PrivateKey, algo string) (string, error) {
+func getSignature(toSign *[]byte, key crypto.Signer, algo string) (string, error) {
 	var h1 hash.Hash
 	var h2 crypto.Hash
 	switch algo {
@@ -459,7 +1018,17 @@ func getSignature(toSign *[]byte, key *rsa.PrivateKey, algo string) (string, err
 
 	// sign
 	h1.Write(*toSign)
-	sig, err := rsa.SignPKCS1v15(rand.Reader, key, h2, h1.Sum(nil))
+	var sig []byte
+	var err error
+	switch key := key.(type) {
+	case *rsa.PrivateKey:
+		sig, err = rsa.SignPKCS1v15(rand.Reader, key, h2, h1.Sum(nil))
+	case ed25519.PrivateKey:
+		// RFC 8463: Ed25519 signs the hash of the canonicalized headers
+		sig = ed25519.Sign(key, h1.Sum(nil))
+	default:
+		err = ErrCandNotParsePrivateKey
+	}
 	if err != nil {
 		return "", err
 	}
@@ -467,7 +1036,7 @@ func getSignature(toSign *[]byte, key *rsa.PrivateKey, algo string) (string, err
 }
 
 // verifySignature verify signature from pubkey
-func verifySignature(toSign []byte, sig64 string, key *rsa.PublicKey, algo string) error {
+func verifySignature(toSign []byte, sig64 string, key *PubKeyRep, algo string) error {
 	var h1 hash.Hash
 	var h2 crypto.Hash
 	switch algo {
@@ -488,16 +1057,40 @@ func verifySignature(toSign []byte, sig64 string, key *rsa.PublicKey, algo strin
 	if err != nil {
 		return err
 	}
-	return rsa.VerifyPKCS1v15(key, h2, h1.Sum(nil), sig)
+	if key.KeyType == "ed25519" {
+		if !ed25519.Verify(key.Ed25519PubKey, h1.Sum(nil), sig) {
+			return ErrVerifyEd25519Signature
+		}
+		return nil
+	}
+	return rsa.VerifyPKCS1v15(&key.PubKey, h2, h1.Sum(nil), sig)
 }
 
 // removeFWS removes all FWS from string
 func removeFWS(in string) string {
-	rxReduceWS := regexp.MustCompile(`[ \t]+`)
-	out := strings.Replace(in, "\n", "", -1)
-	out = strings.Replace(out, "\r", "", -1)
-	out = rxReduceWS.ReplaceAllString(out, " ")
-	return strings.TrimSpace(out)
+	var b strings.Builder
+	b.Grow(len(in))
+	wsp := false
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; c {
+		case '\r', '\n':
+		case ' ', '\t':
+			wsp = true
+		default:
+			// sequences of WSP are reduced to a single SP
+			if wsp {
+				b.WriteByte(' ')
+				wsp = false
+			}
+			b.WriteByte(c)
+		}
+	}
+	return strings.TrimSpace(b.String())
+}
+
+// removeWS removes all whitespace (including CR and LF) from string
+func removeWS(in string) string {
+	return strings.Join(strings.Fields(in), "")
 }
 
 // validateCanonicalization validate canonicalization (c flag)
@@ -517,46 +1110,54 @@ func validateCanonicalization(cano string) (string, error) {
 	return cano, nil
 }
 
-// getHeadersList returns headers as list
-func getHeadersList(rawHeader *[]byte) (*list.List, error) {
-	headersList := list.New()
-	currentHeader := []byte{}
-	for _, line := range bytes.SplitAfter(*rawHeader, []byte{10}) {
-		if line[0] == 32 || line[0] == 9 {
-			if len(currentHeader) == 0 {
+// getHeadersList returns the list of header fields (without trailing CRLF)
+func getHeadersList(rawHeader *[]byte) ([]string, error) {
+	raw := *rawHeader
+	headersList := []string{}
+	current := -1
+	for pos := 0; pos < len(raw); {
+		next := len(raw)
+		if nl := bytes.IndexByte(raw[pos:], '\n'); nl != -1 {
+			next = pos + nl + 1
+		}
+		if len(bytes.TrimRight(raw[pos:next], "\r\n")) == 0 {
+			// empty line, not part of any header
+			if current != -1 {
+				headersList = append(headersList, string(bytes.TrimRight(raw[current:pos], "\r\n")))
+			}
+			current = -1
+		} else if raw[pos] == ' ' || raw[pos] == '\t' {
+			// continuation line
+			if current == -1 {
 				return headersList, ErrBadMailFormatHeaders
 			}
-			currentHeader = append(currentHeader, line...)
 		} else {
 			// New header, save current if exists
-			if len(currentHeader) != 0 {
-				headersList.PushBack(string(bytes.TrimRight(currentHeader, "\r\n")))
-				currentHeader = []byte{}
+			if current != -1 {
+				headersList = append(headersList, string(bytes.TrimRight(raw[current:pos], "\r\n")))
 			}
-			currentHeader = append(currentHeader, line...)
+			current = pos
 		}
+		pos = next
+	}
+	if current != -1 {
+		headersList = append(headersList, string(bytes.TrimRight(raw[current:], "\r\n")))
 	}
-	headersList.PushBack(string(currentHeader))
 	return headersList, nil
 }
 
 // getHeadersBody return headers and body
-func getHeadersBody(email *[]byte) ([]byte, []byte, error) {
-	substitutedEmail := *email
-
-	// only replace \n with \r\n when \r\n\r\n not exists
-	if bytes.Index(*email, []byte{13, 10, 13, 10}) < 0 {
-		// \n -> \r\n
-		substitutedEmail = bytes.Replace(*email, []byte{10}, []byte{13, 10}, -1)
-	}
+func getHeadersBody(email *[]byte, lineEndings LineEndings) ([]byte, []byte, error) {
+	substitutedEmail := normalizeLineEndings(*email, lineEndings)
 
-	parts := bytes.SplitN(substitutedEmail, []byte{13, 10, 13, 10}, 2)
-	if len(parts) != 2 {
+	i := bytes.Index(substitutedEmail, []byte{13, 10, 13, 10})
+	if i < 0 {
 		return []byte{}, []byte{}, ErrBadMailFormat
 	}
+	headers, body := substitutedEmail[:i], substitutedEmail[i+4:]
 	// Empty body
-	if len(parts[1]) == 0 {
-		parts[1] = []byte{13, 10}
+	if len(body) == 0 {
+		body = []byte{13, 10}
 	}
-	return parts[0], parts[1], nil
+	return headers, body, nil
 }
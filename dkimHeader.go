@@ -19,6 +19,7 @@ type DKIMHeader struct {
 	// The algorithm used to generate the signature..
 	// Verifiers MUST support "rsa-sha1" and "rsa-sha256";
 	// Signers SHOULD sign using "rsa-sha256".
+	// "ed25519-sha256" is defined by RFC 8463.
 	// tag a
 	Algorithm string
 
@@ -186,6 +187,18 @@ type DKIMHeader struct {
 	// tag z
 	CopiedHeaderFields []string
 
+	// Reports requested (RFC 6651): the Signer wants failure reports sent
+	// to the address of its reporting record (see LookupReportRecord).
+	// tag r
+	ReportRequested bool
+
+	// Authorized Third-Party Signature (RFC 6541): the author domain the
+	// signature is made for and the hash used to build the name of its
+	// authorization record ("sha1", "sha256" or "none")
+	// tags atps and atpsh
+	ATPSDomain string
+	ATPSHash   string
+
 	// HeaderMailFromDomain store the raw email address of the header Mail From
 	// used for verifying in case of multiple DKIM header (we will prioritise
 	// header with d = mail from domain)
@@ -215,6 +228,9 @@ func newDkimHeaderBySigOptions(options SigOptions) *DKIMHeader {
 		h.SignatureExpiration = time.Now().Add(time.Duration(options.SignatureExpireIn) * time.Second)
 	}
 	h.CopiedHeaderFields = options.CopiedHeaderFields
+	h.ReportRequested = options.RequestReports
+	h.ATPSDomain = options.ATPSDomain
+	h.ATPSHash = options.ATPSHash
 	return h
 }
 
@@ -250,20 +266,10 @@ func GetHeader(email *[]byte) (*DKIMHeader, error) {
 	// we can't use m.header because header key will be converted with textproto.CanonicalMIMEHeaderKey
 	// ie if key in header is not DKIM-Signature but Dkim-Signature or DKIM-signature ot... other
 	// combination of case, verify will fail.
-	rawHeaders, _, err := getHeadersBody(email)
-	if err != nil {
-		return nil, ErrBadMailFormat
-	}
-	rawHeadersList, err := getHeadersList(&rawHeaders)
+	dkHeaders, err := getRawDkimHeaders(email)
 	if err != nil {
 		return nil, err
 	}
-	dkHeaders := []string{}
-	for h := rawHeadersList.Front(); h != nil; h = h.Next() {
-		if strings.HasPrefix(strings.ToLower(h.Value.(string)), "dkim-signature") {
-			dkHeaders = append(dkHeaders, h.Value.(string))
-		}
-	}
 
 	var keep *DKIMHeader
 	var keepErr error
@@ -290,21 +296,40 @@ func GetHeader(email *[]byte) (*DKIMHeader, error) {
 	return keep, nil
 }
 
+// getRawDkimHeaders returns the raw DKIM-Signature header fields of email,
+// in message order
+func getRawDkimHeaders(email *[]byte) ([]string, error) {
+	rawHeaders, _, err := getHeadersBody(email, LineEndingsAuto)
+	if err != nil {
+		return nil, ErrBadMailFormat
+	}
+	rawHeadersList, err := getHeadersList(&rawHeaders)
+	if err != nil {
+		return nil, err
+	}
+	dkHeaders := []string{}
+	for _, h := range rawHeadersList {
+		if len(h) >= 14 && strings.EqualFold(h[:14], "dkim-signature") {
+			dkHeaders = append(dkHeaders, h)
+		}
+	}
+	return dkHeaders, nil
+}
+
 // parseDkHeader parse raw dkim header
 func parseDkHeader(header string) (dkh *DKIMHeader, err error) {
 	dkh = new(DKIMHeader)
 
 	keyVal := strings.SplitN(header, ":", 2)
+	if len(keyVal) != 2 {
+		return nil, ErrDkimHeaderBadFormat
+	}
 
-	t := strings.LastIndex(header, "b=")
-	if t == -1 {
+	rawForSign, found := removeBTagValue(header)
+	if !found {
 		return nil, ErrDkimHeaderBTagNotFound
 	}
-	dkh.rawForSign = header[0 : t+2]
-	p := strings.IndexByte(header[t:], ';')
-	if p != -1 {
-		dkh.rawForSign = dkh.rawForSign + header[t+p:]
-	}
+	dkh.rawForSign = rawForSign
 
 	// Mandatory
 	mandatoryFlags := make(map[string]bool, 7) //(b'v', b'a', b'b', b'bh', b'd', b'h', b's')
@@ -320,24 +345,21 @@ func parseDkHeader(header string) (dkh *DKIMHeader, err error) {
 	dkh.MessageCanonicalization = "simple/simple"
 	dkh.QueryMethods = []string{"dns/txt"}
 
-	// unfold && clean
-	val := removeFWS(keyVal[1])
-	val = strings.Replace(val, " ", "", -1)
-
-	fs := strings.Split(val, ";")
-	for _, f := range fs {
-		if f == "" {
-			continue
-		}
-		flagData := strings.SplitN(f, "=", 2)
-
+	tags, err := ParseTagList(keyVal[1])
+	if err != nil {
 		// https://github.com/toorop/go-dkim/issues/2
 		// if flag is not in the form key=value (eg doesn't have "=")
-		if len(flagData) != 2 {
+		if err == ErrTagListSyntax {
 			return nil, ErrDkimHeaderBadFormat
 		}
-		flag := strings.ToLower(strings.TrimSpace(flagData[0]))
-		data := strings.TrimSpace(flagData[1])
+		return nil, err
+	}
+
+	for _, tag := range tags {
+		flag := strings.ToLower(tag.Name)
+		// whitespace is not significant in values of the plain-text, base64
+		// and dkim-quoted-printable tags we handle
+		data := removeWS(tag.Value)
 		switch flag {
 		case "v":
 			if data != "1" {
@@ -347,19 +369,17 @@ func parseDkHeader(header string) (dkh *DKIMHeader, err error) {
 			mandatoryFlags["v"] = true
 		case "a":
 			dkh.Algorithm = strings.ToLower(data)
-			if dkh.Algorithm != "rsa-sha1" && dkh.Algorithm != "rsa-sha256" {
+			if !isValidAlgo(dkh.Algorithm) {
 				return nil, ErrSignBadAlgo
 			}
 			mandatoryFlags["a"] = true
 		case "b":
-			//dkh.SignatureData = removeFWS(data)
-			// remove all space
-			dkh.SignatureData = strings.Replace(removeFWS(data), " ", "", -1)
+			dkh.SignatureData = data
 			if len(dkh.SignatureData) != 0 {
 				mandatoryFlags["b"] = true
 			}
 		case "bh":
-			dkh.BodyHash = removeFWS(data)
+			dkh.BodyHash = data
 			if len(dkh.BodyHash) != 0 {
 				mandatoryFlags["bh"] = true
 			}
@@ -394,11 +414,9 @@ func parseDkHeader(header string) (dkh *DKIMHeader, err error) {
 				return nil, err
 			}
 		case "i":
-			if data != "" {
-				if !strings.HasSuffix(data, dkh.Domain) {
-					return nil, ErrDkimHeaderDomainMismatch
-				}
-				dkh.Auid = data
+			dkh.Auid, err = DecodeQuotedPrintable(data)
+			if err != nil {
+				return nil, err
 			}
 		case "l":
 			ui, err := strconv.ParseUint(data, 10, 32)
@@ -424,8 +442,20 @@ func parseDkHeader(header string) (dkh *DKIMHeader, err error) {
 				return nil, err
 			}
 			dkh.SignatureExpiration = time.Unix(ts, 0)
+		case "r":
+			dkh.ReportRequested = strings.ToLower(data) == "y"
+		case "atps":
+			dkh.ATPSDomain = strings.ToLower(data)
+		case "atpsh":
+			dkh.ATPSHash = strings.ToLower(data)
 		case "z":
 			dkh.CopiedHeaderFields = strings.Split(data, "|")
+			for i, f := range dkh.CopiedHeaderFields {
+				dkh.CopiedHeaderFields[i], err = DecodeQuotedPrintable(f)
+				if err != nil {
+					return nil, err
+				}
+			}
 		}
 	}
 
@@ -436,6 +466,14 @@ func parseDkHeader(header string) (dkh *DKIMHeader, err error) {
 		}
 	}
 
+	// i domain must be the same as, or a subdomain of, d
+	if dkh.Auid != "" {
+		auidDomain := strings.ToLower(dkh.Auid[strings.LastIndex(dkh.Auid, "@")+1:])
+		if auidDomain != dkh.Domain && !strings.HasSuffix(auidDomain, "."+dkh.Domain) {
+			return nil, ErrDkimHeaderDomainMismatch
+		}
+	}
+
 	// default for i/Auid
 	if dkh.Auid == "" {
 		dkh.Auid = "@" + dkh.Domain
@@ -450,6 +488,26 @@ func parseDkHeader(header string) (dkh *DKIMHeader, err error) {
 
 }
 
+// removeBTagValue returns the header with the value of the b tag removed
+// and true if the b tag was found
+func removeBTagValue(header string) (string, bool) {
+	start := strings.IndexByte(header, ':') + 1
+	for start > 0 && start <= len(header) {
+		end := strings.IndexByte(header[start:], ';')
+		if end == -1 {
+			end = len(header)
+		} else {
+			end += start
+		}
+		spec := header[start:end]
+		if eq := strings.IndexByte(spec, '='); eq != -1 && strings.Trim(spec[:eq], " \t\r\n") == "b" {
+			return header[:start+eq+1] + header[end:], true
+		}
+		start = end + 1
+	}
+	return header, false
+}
+
 // GetHeaderBase return base header for signers
 // Todo: some refactoring needed...
 func (d *DKIMHeader) getHeaderBaseForSigning(bodyHash string) string {
@@ -463,11 +521,12 @@ func (d *DKIMHeader) getHeaderBaseForSigning(bodyHash string) string {
 
 	// Auid
 	if len(d.Auid) != 0 {
-		if len(subh)+len(d.Auid)+4 > MaxHeaderLineLength {
+		auid := EncodeQuotedPrintable(d.Auid)
+		if len(subh)+len(auid)+4 > MaxHeaderLineLength {
 			h += subh + FWS
 			subh = ""
 		}
-		subh += " i=" + d.Auid + ";"
+		subh += " i=" + auid + ";"
 	}
 
 	/*h := "DKIM-Signature: v=1; a=rsa-sha256; c=relaxed/simple; d=tmail.io; i=@tmail.io;" + FWS
@@ -497,6 +556,26 @@ func (d *DKIMHeader) getHeaderBaseForSigning(bodyHash string) string {
 		subh += " x=" + fmt.Sprintf("%d", ts) + ";"
 	}
 
+	// Reports requested
+	if d.ReportRequested {
+		if len(subh)+5 > MaxHeaderLineLength {
+			h += subh + FWS
+			subh = ""
+		}
+		subh += " r=y;"
+	}
+
+	// ATPS
+	if d.ATPSDomain != "" {
+		for _, tag := range []string{" atps=" + d.ATPSDomain + ";", " atpsh=" + d.ATPSHash + ";"} {
+			if len(subh)+len(tag) > MaxHeaderLineLength {
+				h += subh + FWS
+				subh = ""
+			}
+			subh += tag
+		}
+	}
+
 	// body length
 	if d.BodyLength != 0 {
 		bodyLengthStr := fmt.Sprintf("%d", d.BodyLength)
@@ -522,6 +601,35 @@ func (d *DKIMHeader) getHeaderBaseForSigning(bodyHash string) string {
 	}
 	subh = subh[:len(subh)-1] + ";"
 
+	// Copied header fields
+	if len(d.CopiedHeaderFields) != 0 {
+		if len(subh)+4 > MaxHeaderLineLength {
+			h += subh + FWS
+			subh = ""
+		}
+		subh += " z="
+		for i, field := range d.CopiedHeaderFields {
+			if i != 0 {
+				subh += "|"
+			}
+			// "|" must be encoded, FWS may be inserted between encoded chars
+			encoded := encodeQuotedPrintable(field, "|")
+			for len(encoded) > 0 {
+				n := 1
+				if encoded[0] == '=' {
+					n = 3
+				}
+				if len(subh)+n > MaxHeaderLineLength {
+					h += subh + FWS
+					subh = ""
+				}
+				subh += encoded[:n]
+				encoded = encoded[n:]
+			}
+		}
+		subh += ";"
+	}
+
 	// BodyHash
 	if len(subh)+5+len(bodyHash) > MaxHeaderLineLength {
 		h += subh + FWS
@@ -530,15 +638,18 @@ func (d *DKIMHeader) getHeaderBaseForSigning(bodyHash string) string {
 		subh += " "
 	}
 	subh += "bh="
-	l := len(subh)
-	for _, c := range bodyHash {
-		subh += string(c)
-		l++
-		if l >= MaxHeaderLineLength {
-			h += subh + FWS
-			subh = ""
-			l = 0
+	for len(bodyHash) > 0 {
+		n := MaxHeaderLineLength - len(subh)
+		if n < 1 {
+			n = 1
 		}
+		if n > len(bodyHash) {
+			subh += bodyHash
+			break
+		}
+		h += subh + bodyHash[:n] + FWS
+		subh = ""
+		bodyHash = bodyHash[n:]
 	}
 	h += subh + ";" + FWS + "b="
 	return h
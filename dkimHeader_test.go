@@ -52,3 +52,86 @@ func Test_GetHeader(t *testing.T) {
 		})
 	}
 }
+
+func Test_parseDkHeader(t *testing.T) {
+	base := "DKIM-Signature: v=1; a=rsa-sha256; s=test; h=from; bh=GF+NsyJx/iX1Yab8k4suJkMG7DBO2lGAB9F2SCY4GWk=; "
+	tests := []struct {
+		name       string
+		input      string
+		rawForSign string
+		auid       string
+		report     bool
+		err        error
+	}{
+		{
+			name:       "b before d",
+			input:      base + "b=ab\r\n cb=; d=tmail.io",
+			rawForSign: base + "b=; d=tmail.io",
+			auid:       "@tmail.io",
+		},
+		{
+			name:       "i subdomain",
+			input:      base + "i=joe@sub.tmail.io; d=tmail.io; b=abc",
+			rawForSign: base + "i=joe@sub.tmail.io; d=tmail.io; b=",
+			auid:       "joe@sub.tmail.io",
+		},
+		{
+			name:       "reports requested",
+			input:      base + "r=Y; d=tmail.io; b=abc",
+			rawForSign: base + "r=Y; d=tmail.io; b=",
+			auid:       "@tmail.io",
+			report:     true,
+		},
+		{
+			name:  "i other domain",
+			input: base + "i=joe@eviltmail.io; d=tmail.io; b=abc",
+			err:   ErrDkimHeaderDomainMismatch,
+		},
+		{
+			name:  "duplicate tag",
+			input: base + "d=tmail.io; d=tmail.io; b=abc",
+			err:   ErrTagListDuplicateTag,
+		},
+		{
+			name:  "no b tag",
+			input: base + "d=tmail.io",
+			err:   ErrDkimHeaderBTagNotFound,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := parseDkHeader(tt.input)
+			if err != tt.err {
+				t.Fatalf("parseDkHeader() error = %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if got.rawForSign != tt.rawForSign {
+				t.Errorf("rawForSign = %q, want %q", got.rawForSign, tt.rawForSign)
+			}
+			if got.Auid != tt.auid {
+				t.Errorf("Auid = %q, want %q", got.Auid, tt.auid)
+			}
+			if got.ReportRequested != tt.report {
+				t.Errorf("ReportRequested = %v, want %v", got.ReportRequested, tt.report)
+			}
+		})
+	}
+}
+
+func FuzzParseDkHeader(f *testing.F) {
+	f.Add("DKIM-Signature: v=1; a=rsa-sha256; s=test; h=from; bh=GF+NsyJx/iX1Yab8k4suJkMG7DBO2lGAB9F2SCY4GWk=; d=tmail.io; b=abc")
+	f.Add("DKIM-Signature: v=1; a=ed25519-sha256; c=relaxed/relaxed;\r\n d=football.example.com; i=@football.example.com;\r\n q=dns/txt; s=brisbane; t=1528637909; h=from : to :\r\n subject : date : message-id : from : subject : date;\r\n bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=;\r\n b=/gCrinpcQOoIfuHNQIbq4pgh9kyIK3AQUdt9OdqQehSwhEIug4D11BusFa3bT3FY5OsU7ZbnKELq+eXdp1Q1Dw==")
+	f.Add("DKIM-Signature: v=1; a=rsa-sha1; s=s; d=d; h=from; bh=; b=; l=4294967295; x=1; t=2; z=From:a=7Cb|To:c")
+	f.Add("DKIM-Signature: v=1; a=rsa-sha256; s=test; h=from; bh=; i=joe@eviltmail.io; d=tmail.io; b=abc")
+	f.Fuzz(func(t *testing.T, header string) {
+		dkh, err := parseDkHeader(header)
+		if err != nil {
+			return
+		}
+		if dkh.Domain == "" || dkh.Selector == "" || !isValidAlgo(dkh.Algorithm) || len(dkh.Headers) == 0 {
+			t.Fatalf("invalid header accepted: %+v", dkh)
+		}
+	})
+}
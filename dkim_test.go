@@ -2,11 +2,15 @@ package dkim
 
 import (
 	//"fmt"
+	"bytes"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"errors"
 	"net"
+	"strings"
 	"testing"
+	"testing/iotest"
 	"time"
 
 	"github.com/stretchr/testify/assert"
@@ -181,6 +185,9 @@ var signedDouble = "DKIM-Signature: v=1; a=rsa-sha256; q=dns/txt; c=simple/simpl
 	" pIghLwl/EshDBmNy65O6qO8pSSGgZmM3T7SRLMloex8bnrBJ4KSYcHV46639gVEWcBOKW0" + CRLF +
 	" h1djZu2jaTuxGeJzlFVtw3Arf2B93cc=" + CRLF + emailBase
 
+// gmailPubKey is the key of selector 20120113 of gmail.com
+const gmailPubKey = "MIIBIjANBgkqhkiG9w0BAQEFAAOCAQ8AMIIBCgKCAQEA1Kd87/UeJjenpabgbFwh+eBCsSTrqmwIYYvywlbhbqoo2DymndFkbjOVIPIldNs/m40KF+yzMn1skyoxcTUGCQs8g3FgD2Ap3ZB5DekAo5wMmk4wimDO+U8QzI3SD07y2+07wlNWwIt8svnxgdxGkVbbhzY8i+RQ9DpSVpPbF7ykQxtKXkv/ahW3KjViiAH+ghvvIhkx4xYSIc9oSwVmAl5OctMEeWUwg8Istjqz8BZeTWbf41fbNhte7Y+YqZOwq1Sd0DbvYAD9NOZK9vlfuac0598HY+vtSBczUiKERHv1yRbcaQtZFh5wtiRrN04BLUTD21MycBX5jYchHjPY/wIDAQAB"
+
 var fromGmail = "Return-Path: toorop@gmail.com" + CRLF +
 	"Delivered-To: toorop@tmail.io" + CRLF +
 	"Received: tmail deliverd local d9ae3ac7c238a50a6e007d207337752eb04038ff; 21 May 2015 19:47:54 +0200" + CRLF +
@@ -351,13 +358,176 @@ func Test_Sign(t *testing.T) {
 	assert.Equal(t, []byte(signedSimpleSimpleLength), emailSimple)
 }
 
+func Test_SignMulti(t *testing.T) {
+	email := []byte(emailBase)
+	edKey, err := GenerateKey("ed25519-sha256")
+	require.NoError(t, err)
+
+	rsaOptions := NewSigOptions()
+	rsaOptions.PrivateKey = []byte(privKey)
+	rsaOptions.Domain = domain
+	rsaOptions.Selector = selector
+	rsaOptions.Headers = []string{"from", "date", "mime-version"}
+	rsaOptions.Canonicalization = "relaxed/relaxed"
+	rsaOptions.AddSignatureTimestamp = false
+
+	edOptions := rsaOptions
+	edOptions.PrivateKey = edKey
+	edOptions.Algo = "ed25519-sha256"
+	edOptions.Selector = "ed"
+
+	espOptions := rsaOptions
+	espOptions.Domain = "esp.example"
+	espOptions.Canonicalization = "simple/simple"
+	espOptions.BodyLength = 5
+
+	options := []SigOptions{rsaOptions, edOptions, espOptions}
+	signed := append([]byte(nil), email...)
+	require.NoError(t, SignMulti(&signed, options...))
+
+	// same signatures as Sign, which don't cover each other, in order
+	expected := []byte{}
+	for _, o := range options {
+		one := append([]byte(nil), email...)
+		require.NoError(t, Sign(&one, o))
+		expected = append(expected, one[:len(one)-len(email)]...)
+	}
+	assert.Equal(t, string(append(expected, email...)), string(signed))
+
+	status, err := Verify(&signed, DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, SUCCESS, status)
+
+	// options are not modified
+	assert.Equal(t, "ed25519-sha256", options[1].Algo)
+	options[1].Algo = "ED25519-SHA256"
+	unsigned := append([]byte(nil), email...)
+	require.NoError(t, SignMulti(&unsigned, options...))
+	assert.Equal(t, "ED25519-SHA256", options[1].Algo)
+
+	// email is untouched on error
+	options[2].Selector = ""
+	unsigned = append([]byte(nil), email...)
+	assert.Equal(t, ErrSignSelectorRequired, SignMulti(&unsigned, options...))
+	assert.Equal(t, email, unsigned)
+}
+
+func Test_SignHeader(t *testing.T) {
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	options.Headers = []string{"from", "date", "mime-version", "received", "received"}
+	options.AddSignatureTimestamp = false
+	options.Canonicalization = "relaxed/relaxed"
+
+	email := []byte(emailBase)
+	header, err := SignHeader(email, options)
+	require.NoError(t, err)
+	assert.Equal(t, emailBase, string(email))
+	assert.Equal(t, signedRelaxedRelaxed, header+emailBase)
+
+	_, err = SignHeader([]byte("From: joe"), options)
+	assert.Equal(t, ErrBadMailFormat, err)
+}
+
+func Test_SignReader(t *testing.T) {
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	options.Headers = []string{"from", "date", "mime-version", "received", "received"}
+	options.AddSignatureTimestamp = false
+
+	split := func(email string) ([]string, string) {
+		rawHeader, body, _ := strings.Cut(email, CRLF+CRLF)
+		var fields []string
+		for _, line := range strings.SplitAfter(rawHeader, CRLF) {
+			if line[0] == ' ' || line[0] == '\t' {
+				fields[len(fields)-1] += line
+			} else {
+				fields = append(fields, line)
+			}
+		}
+		return fields, body
+	}
+
+	for _, cano := range []string{"simple/simple", "relaxed/relaxed"} {
+		for _, email := range []string{emailBase, "From: joe@example.com\r\n\r\n"} {
+			options.Canonicalization = cano
+			expected, err := SignHeader([]byte(email), options)
+			require.NoError(t, err)
+
+			fields, body := split(email)
+			header, err := SignReader(fields, strings.NewReader(body), options)
+			assert.NoError(t, err)
+			assert.Equal(t, expected, header, cano)
+		}
+	}
+
+	readErr := errors.New("read error")
+	_, err := SignReader([]string{"From: joe"}, iotest.ErrReader(readErr), options)
+	assert.Equal(t, readErr, err)
+
+	options.Domain = ""
+	_, err = SignReader([]string{"From: joe"}, strings.NewReader(""), options)
+	assert.Equal(t, ErrSignDomainRequired, err)
+}
+
+func Test_VerifyAll(t *testing.T) {
+	edKey, err := GenerateKey("ed25519-sha256")
+	require.NoError(t, err)
+	edRecord, err := PublicKeyRecord(edKey)
+	require.NoError(t, err)
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		if name == "ed._domainkey."+domain {
+			return []string{edRecord}, nil
+		}
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+
+	rsaOptions := NewSigOptions()
+	rsaOptions.PrivateKey = []byte(privKey)
+	rsaOptions.Domain = domain
+	rsaOptions.Selector = selector
+	edOptions := rsaOptions
+	edOptions.PrivateKey = edKey
+	edOptions.Algo = "ed25519-sha256"
+	edOptions.Selector = "ed"
+	badOptions := rsaOptions
+	badOptions.Selector = "ed"
+
+	email := []byte(emailBase)
+	require.NoError(t, SignMulti(&email, rsaOptions, badOptions, edOptions))
+	email = append([]byte("DKIM-Signature: v=1; d="+CRLF), email...)
+
+	results := VerifyAll(&email, resolveTXT)
+	require.Len(t, results, 4)
+	assert.Equal(t, PERMFAIL, results[0].Status)
+	assert.Nil(t, results[0].Header)
+	assert.Equal(t, SUCCESS, results[1].Status)
+	assert.Equal(t, selector, results[1].Header.Selector)
+	assert.Equal(t, PERMFAIL, results[2].Status)
+	assert.Equal(t, ErrVerifyBadKeyType, results[2].Err)
+	assert.Equal(t, SUCCESS, results[3].Status)
+	assert.Equal(t, "ed", results[3].Header.Selector)
+
+	email = []byte(emailBase)
+	results = VerifyAll(&email, resolveTXT)
+	require.Len(t, results, 1)
+	assert.Equal(t, NOTSIGNED, results[0].Status)
+}
+
 func Test_Verify(t *testing.T) {
 	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
 		switch name {
 		case selector + "._domainkey." + domain:
 			return []string{"v=DKIM1; t=y; p=" + pubKey}, nil
-		// case "TODO._domainkey.gmail.com":
-		// 	return []string{"v=DKIM1; p="}, nil
+		case "20120113._domainkey.gmail.com":
+			// removed by Google, as published when fromGmail was signed
+			return []string{"k=rsa; p=" + gmailPubKey}, nil
 		default:
 			return net.LookupTXT(name)
 		}
@@ -407,15 +577,10 @@ func Test_Verify(t *testing.T) {
 	assert.Equal(t, SUCCESS, status)
 
 	// gmail
-	// TODO:
-	// Google removed this DNS record some time ago. Someone will have to send an email they're
-	// OK with being publicly available, replace the value of the fromGmail var with that, then grab
-	// the DNS record indicated in the DKIM signature and update the resolveTXT function to return
-	// it when asked. Then this should work.
-	// email = []byte(fromGmail)
-	// status, err = Verify(&email, resolveTXT)
-	// assert.NoError(t, err)
-	// assert.Equal(t, SUCCESS, status)
+	email = []byte(fromGmail)
+	status, err = Verify(&email, resolveTXT)
+	assert.NoError(t, err)
+	assert.Equal(t, SUCCESS, status)
 }
 
 func Test_SignatureExpiration(t *testing.T) {
@@ -449,3 +614,294 @@ func Test_SignatureExpiration(t *testing.T) {
 	assert.Equal(t, TESTINGPERMFAIL, status)
 	assert.Equal(t, ErrVerifySignatureHasExpired, err)
 }
+
+var multipartEmail = "From: Joe <joe@tmail.io>" + CRLF +
+	"Subject: multipart" + CRLF +
+	"MIME-Version: 1.0" + CRLF +
+	"Content-Type: multipart/mixed; boundary=\"frontier\"" + CRLF + CRLF +
+	"--frontier" + CRLF +
+	"Content-Type: text/plain" + CRLF + CRLF +
+	"Hello world" + CRLF +
+	"--frontier--" + CRLF
+
+func Test_VerifyBodyLength(t *testing.T) {
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+
+	// unsigned content is reported
+	email := []byte(signedRelaxedRelaxedLength)
+	res := VerifyWithResult(&email, resolveTXT)
+	assert.NoError(t, res.Err)
+	assert.Equal(t, SUCCESS, res.Status)
+	assert.Equal(t, int64(len(bodyRelaxed)), res.BodyLength)
+	assert.Equal(t, int64(len(bodyRelaxed)-5), res.UnsignedBodyBytes)
+	assert.False(t, res.UnsignedMIMEPart)
+
+	// and rejected by policy
+	res = VerifyWithResult(&email, resolveTXT, VerifyOptBodyLengthPolicy(BodyLengthPolicy{MaxUnsignedBytes: 10}))
+	assert.Equal(t, PERMFAIL, res.Status)
+	assert.Equal(t, ErrVerifyUnsignedBodyContent, res.Err)
+	res = VerifyWithResult(&email, resolveTXT, VerifyOptBodyLengthPolicy(BodyLengthPolicy{RejectUnsignedContent: true}))
+	assert.Equal(t, PERMFAIL, res.Status)
+	assert.Equal(t, ErrVerifyUnsignedBodyContent, res.Err)
+
+	// the zero MaxUnsignedBytes is no limit
+	res = VerifyWithResult(&email, resolveTXT, VerifyOptBodyLengthPolicy(BodyLengthPolicy{RejectMIMEParts: true}))
+	assert.Equal(t, SUCCESS, res.Status)
+
+	// a new MIME part appended after signing
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	options.Canonicalization = "relaxed/relaxed"
+	options.BodyLength = uint(len(multipartEmail) - strings.Index(multipartEmail, CRLF+CRLF) - 4)
+	email = []byte(multipartEmail)
+	err := Sign(&email, options)
+	require.NoError(t, err)
+
+	res = VerifyWithResult(&email, resolveTXT)
+	assert.Equal(t, SUCCESS, res.Status)
+	assert.Equal(t, int64(0), res.UnsignedBodyBytes)
+
+	email = append(email, []byte("--frontier"+CRLF+"Content-Type: text/html"+CRLF+CRLF+"<a href=\"http://evil\">click</a>"+CRLF+"--frontier--"+CRLF)...)
+	res = VerifyWithResult(&email, resolveTXT)
+	assert.Equal(t, SUCCESS, res.Status)
+	assert.True(t, res.UnsignedMIMEPart)
+
+	res = VerifyWithResult(&email, resolveTXT, VerifyOptBodyLengthPolicy(BodyLengthPolicy{MaxUnsignedBytes: -1, RejectMIMEParts: true}))
+	assert.Equal(t, PERMFAIL, res.Status)
+	assert.Equal(t, ErrVerifyUnsignedMIMEPart, res.Err)
+	res = VerifyWithResult(&email, resolveTXT, VerifyOptBodyLengthPolicy(BodyLengthPolicy{RejectMIMEParts: true}))
+	assert.Equal(t, ErrVerifyUnsignedMIMEPart, res.Err)
+}
+
+func Test_hasMIMEBoundary(t *testing.T) {
+	nested := "From: Joe <joe@tmail.io>" + CRLF +
+		"Content-Type: multipart/mixed; boundary=outer" + CRLF + CRLF +
+		"--outer" + CRLF +
+		"Content-Type: multipart/alternative;" + CRLF +
+		"\tboundary=\"inner\"" + CRLF + CRLF +
+		"--inner" + CRLF +
+		"Content-Type: text/plain" + CRLF + CRLF +
+		"Hello world" + CRLF
+	email := []byte(nested)
+	assert.Equal(t, []string{"outer", "inner"}, mimeBoundaries(email))
+	assert.True(t, hasMIMEBoundary(&email, []byte("--inner"+CRLF+"Content-Type: text/html"+CRLF)))
+	assert.True(t, hasMIMEBoundary(&email, []byte("--outer--"+CRLF)))
+	assert.False(t, hasMIMEBoundary(&email, []byte("--other"+CRLF)))
+
+	email = []byte(emailBase)
+	assert.False(t, hasMIMEBoundary(&email, []byte("--frontier"+CRLF)))
+}
+
+func Test_VerifyKeyFlags(t *testing.T) {
+	sign := func(auid string) []byte {
+		options := NewSigOptions()
+		options.PrivateKey = []byte(privKey)
+		options.Domain = domain
+		options.Selector = selector
+		options.Auid = auid
+		email := []byte(emailBase)
+		require.NoError(t, Sign(&email, options))
+		return email
+	}
+	resolveTXT := func(record string) DNSOpt {
+		return DNSOptLookupTXT(func(name string) ([]string, error) {
+			return []string{record + "; p=" + pubKey}, nil
+		})
+	}
+
+	tests := []struct {
+		name   string
+		auid   string
+		record string
+		status verifyOutput
+		err    error
+	}{
+		{"t=s same domain", "joe@tmail.io", "v=DKIM1; t=s", SUCCESS, nil},
+		{"t=s default i", "", "v=DKIM1; t=s", SUCCESS, nil},
+		{"t=s subdomain", "joe@sub.tmail.io", "v=DKIM1; t=s", PERMFAIL, ErrVerifyIMustBeD},
+		{"subdomain without t=s", "joe@sub.tmail.io", "v=DKIM1", SUCCESS, nil},
+		{"t=y:s subdomain", "joe@sub.tmail.io", "v=DKIM1; t=y:s", TESTINGPERMFAIL, ErrVerifyIMustBeD},
+		{"s=email", "", "v=DKIM1; s=email", SUCCESS, nil},
+		{"s=*", "", "v=DKIM1; s=*", SUCCESS, nil},
+		{"s=unknown", "", "v=DKIM1; s=unknown", PERMFAIL, ErrVerifyKeyServiceType},
+		{"s=unknown:email", "", "v=DKIM1; s=unknown:email", SUCCESS, nil},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			email := sign(tt.auid)
+			status, err := Verify(&email, resolveTXT(tt.record))
+			assert.Equal(t, tt.err, err)
+			assert.Equal(t, tt.status, status)
+		})
+	}
+}
+
+// benchEmail returns an email of about size octets
+func benchEmail(size int) []byte {
+	email := []byte(emailBase)
+	line := []byte("Lorem ipsum dolor sit amet,   consectetur adipiscing elit, sed do eiusmod  " + CRLF)
+	for len(email) < size {
+		email = append(email, line...)
+	}
+	return email
+}
+
+var benchSizes = []struct {
+	name string
+	size int
+}{
+	{"10KB", 10 << 10},
+	{"1MB", 1 << 20},
+	{"20MB", 20 << 20},
+}
+
+func BenchmarkSign(b *testing.B) {
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	options.Headers = []string{"from", "date", "mime-version", "received", "received"}
+	for _, cano := range []string{"simple/simple", "relaxed/relaxed"} {
+		options.Canonicalization = cano
+		for _, bs := range benchSizes {
+			email := benchEmail(bs.size)
+			b.Run(cano+"/"+bs.name, func(b *testing.B) {
+				b.SetBytes(int64(len(email)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					toSign := email
+					if err := Sign(&toSign, options); err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	options.Headers = []string{"from", "date", "mime-version", "received", "received"}
+	for _, cano := range []string{"simple/simple", "relaxed/relaxed"} {
+		options.Canonicalization = cano
+		for _, bs := range benchSizes {
+			email := benchEmail(bs.size)
+			if err := Sign(&email, options); err != nil {
+				b.Fatal(err)
+			}
+			b.Run(cano+"/"+bs.name, func(b *testing.B) {
+				b.SetBytes(int64(len(email)))
+				b.ReportAllocs()
+				for i := 0; i < b.N; i++ {
+					status, err := Verify(&email, resolveTXT)
+					if status != SUCCESS {
+						b.Fatal(status, err)
+					}
+				}
+			})
+		}
+	}
+}
+
+func FuzzGetHeadersList(f *testing.F) {
+	f.Add(headerSimple)
+	f.Add("Received: a\r\n\tb\r\n c\r\nFrom: d\r\n")
+	f.Add(" continuation\r\nFrom: a")
+	f.Add("\r\n\n\rFrom: a\n\n")
+	f.Fuzz(func(t *testing.T, headers string) {
+		raw := []byte(headers)
+		list, err := getHeadersList(&raw)
+		if err != nil {
+			return
+		}
+		for _, h := range list {
+			if h == "" || h[0] == ' ' || h[0] == '\t' {
+				t.Fatalf("%q: invalid header %q", headers, h)
+			}
+		}
+	})
+}
+
+func FuzzGetHeadersBody(f *testing.F) {
+	f.Add(emailBase)
+	f.Add("From: a\n\nbody\n")
+	f.Add("From: a\r\n\r\n")
+	f.Add("From: a\r\rbody\r\n\r\n")
+	f.Fuzz(func(t *testing.T, email string) {
+		for _, le := range []LineEndings{LineEndingsAuto, LineEndingsStrict, LineEndingsFixCRLF} {
+			raw := []byte(email)
+			headers, body, err := getHeadersBody(&raw, le)
+			if err != nil {
+				continue
+			}
+			if len(body) == 0 || bytes.Contains(headers, []byte(CRLF+CRLF)) {
+				t.Fatalf("%q (%d): bad split %q %q", email, le, headers, body)
+			}
+		}
+	})
+}
+
+func FuzzVerify(f *testing.F) {
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+	f.Add(signedRelaxedRelaxed)
+	f.Add(signedRelaxedRelaxedLength)
+	f.Add(signedSimpleSimpleLength)
+	f.Add(signedDouble)
+	f.Add(emailBase)
+	f.Fuzz(func(t *testing.T, email string) {
+		raw := []byte(email)
+		res := VerifyWithResult(&raw, resolveTXT)
+		if res.Status == 0 || (res.Status == SUCCESS) != (res.Err == nil) {
+			t.Fatalf("inconsistent result: %v %v", res.Status, res.Err)
+		}
+	})
+}
+
+// FuzzSignVerify checks that every email signed by Sign verifies
+func FuzzSignVerify(f *testing.F) {
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+	f.Add("Test DKIM", bodySimple, false, uint(0))
+	f.Add("  Test \t DKIM  ", bodySimple, true, uint(5))
+	f.Add("", "", true, uint(0))
+	f.Add("a", "\r\n\r\n \t\r\n", false, uint(2))
+	f.Add("a", "line\nbare LF\rbare CR", true, uint(0))
+	f.Fuzz(func(t *testing.T, subject, body string, relaxed bool, bodyLength uint) {
+		if strings.ContainsAny(subject, "\r\n") {
+			t.Skip()
+		}
+		options := NewSigOptions()
+		options.PrivateKey = []byte(privKey)
+		options.Domain = domain
+		options.Selector = selector
+		options.Headers = []string{"from", "subject"}
+		options.BodyLength = bodyLength
+		if relaxed {
+			options.Canonicalization = "relaxed/relaxed"
+		}
+		email := []byte("From: Joe <joe@tmail.io>" + CRLF + "Subject:" + subject + CRLF + CRLF + body)
+		if err := Sign(&email, options); err != nil {
+			if err == ErrBadDKimTagLBodyTooShort {
+				return
+			}
+			t.Fatal(err)
+		}
+		status, err := Verify(&email, resolveTXT)
+		if status != SUCCESS {
+			t.Fatalf("%q: %v %v", email, status, err)
+		}
+	})
+}
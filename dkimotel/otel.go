@@ -0,0 +1,71 @@
+// Package dkimotel reports the signatures, verifications and key lookups of
+// go-dkim as OpenTelemetry spans.
+package dkimotel
+
+import (
+	"context"
+	"time"
+
+	dkim "github.com/toorop/go-dkim"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// observer creates a span per event
+type observer struct {
+	tracer trace.Tracer
+}
+
+// NewObserver returns a dkim.Observer recording a span per event with
+// tracer: "dkim.sign", "dkim.verify" and "dkim.key_lookup". As go-dkim
+// functions don't take a context, the spans have no parent.
+func NewObserver(tracer trace.Tracer) dkim.Observer {
+	return observer{tracer}
+}
+
+// span records a span of name
+func (o observer) span(name string, start time.Time, duration time.Duration, err error, attrs ...attribute.KeyValue) {
+	_, span := o.tracer.Start(context.Background(), name, trace.WithTimestamp(start), trace.WithAttributes(attrs...))
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+	span.End(trace.WithTimestamp(start.Add(duration)))
+}
+
+func (o observer) OnSign(e dkim.SignEvent) {
+	o.span("dkim.sign", e.Start, e.Duration, e.Err,
+		attribute.String("dkim.domain", e.Domain),
+		attribute.String("dkim.selector", e.Selector),
+		attribute.String("dkim.algorithm", e.Algo),
+	)
+}
+
+func (o observer) OnVerifyResult(e dkim.VerifyEvent) {
+	attrs := []attribute.KeyValue{attribute.String("dkim.status", e.Result.Status.String())}
+	if h := e.Result.Header; h != nil {
+		attrs = append(attrs,
+			attribute.String("dkim.domain", h.Domain),
+			attribute.String("dkim.selector", h.Selector),
+			attribute.String("dkim.algorithm", h.Algorithm),
+		)
+	}
+	if e.Result.KeySource != dkim.KeySourceNone {
+		attrs = append(attrs, attribute.String("dkim.key_source", e.Result.KeySource.String()))
+	}
+	var err error
+	switch e.Result.Status {
+	case dkim.SUCCESS, dkim.TESTINGSUCCESS, dkim.NOTSIGNED:
+	default:
+		err = e.Result.Err
+	}
+	o.span("dkim.verify", e.Start, e.Duration, err, attrs...)
+}
+
+func (o observer) OnKeyLookup(e dkim.KeyLookupEvent) {
+	o.span("dkim.key_lookup", e.Start, e.Duration, e.Err,
+		attribute.String("dkim.key_name", e.Name),
+		attribute.Bool("dkim.key_cached", e.Cached),
+	)
+}
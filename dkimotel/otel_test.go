@@ -0,0 +1,59 @@
+package dkimotel
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	dkim "github.com/toorop/go-dkim"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+)
+
+func TestObserver(t *testing.T) {
+	rec := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(rec))
+	o := NewObserver(provider.Tracer("test"))
+
+	start := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	o.OnSign(dkim.SignEvent{Domain: "example.com", Selector: "s1", Algo: "ed25519-sha256", Start: start, Duration: time.Millisecond})
+	o.OnVerifyResult(dkim.VerifyEvent{
+		Result: &dkim.VerifyResult{Status: dkim.PERMFAIL, Err: dkim.ErrVerifyBodyHash,
+			Header: &dkim.DKIMHeader{Domain: "example.com", Selector: "s1", Algorithm: "rsa-sha256"}, KeySource: dkim.KeySourceDNS},
+		Start: start,
+	})
+	o.OnKeyLookup(dkim.KeyLookupEvent{Name: "s1._domainkey.example.com", Cached: true, Start: start, Duration: time.Second})
+
+	spans := rec.Ended()
+	require.Len(t, spans, 3)
+
+	assert.Equal(t, "dkim.sign", spans[0].Name())
+	assert.Equal(t, start, spans[0].StartTime())
+	assert.Equal(t, start.Add(time.Millisecond), spans[0].EndTime())
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("dkim.domain", "example.com"),
+		attribute.String("dkim.selector", "s1"),
+		attribute.String("dkim.algorithm", "ed25519-sha256"),
+	}, spans[0].Attributes())
+	assert.Equal(t, codes.Unset, spans[0].Status().Code)
+
+	assert.Equal(t, "dkim.verify", spans[1].Name())
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("dkim.status", "PERMFAIL"),
+		attribute.String("dkim.domain", "example.com"),
+		attribute.String("dkim.selector", "s1"),
+		attribute.String("dkim.algorithm", "rsa-sha256"),
+		attribute.String("dkim.key_source", "dns"),
+	}, spans[1].Attributes())
+	assert.Equal(t, codes.Error, spans[1].Status().Code)
+	assert.Equal(t, dkim.ErrVerifyBodyHash.Error(), spans[1].Status().Description)
+
+	assert.Equal(t, "dkim.key_lookup", spans[2].Name())
+	assert.Equal(t, []attribute.KeyValue{
+		attribute.String("dkim.key_name", "s1._domainkey.example.com"),
+		attribute.Bool("dkim.key_cached", true),
+	}, spans[2].Attributes())
+}
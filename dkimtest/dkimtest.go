@@ -0,0 +1,113 @@
+// Package dkimtest provides utilities for DKIM testing: an in-process DNS
+// server serving key records, key pairs for every supported algorithm and
+// helpers to sign fixture messages.
+package dkimtest
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"strings"
+
+	dkim "github.com/toorop/go-dkim"
+)
+
+// Algorithms is the list of signing algorithms supported by the dkim package
+var Algorithms = []string{"rsa-sha1", "rsa-sha256", "ed25519-sha256"}
+
+// RSAKeyBits is the size of the RSA keys generated by NewKeyPair
+var RSAKeyBits = 2048
+
+// KeyPair is a signing key and its DNS key record
+type KeyPair struct {
+	// Algo is the signing algorithm (eg "rsa-sha256")
+	Algo string
+
+	// Signer is the private key
+	Signer crypto.Signer
+
+	// PrivateKey is the PEM encoded private key, as expected by dkim.SigOptions
+	PrivateKey []byte
+}
+
+// NewKeyPair generates a key pair for algo
+func NewKeyPair(algo string) (*KeyPair, error) {
+	k := &KeyPair{Algo: algo}
+	switch algo {
+	case "rsa-sha1", "rsa-sha256":
+		key, err := rsa.GenerateKey(rand.Reader, RSAKeyBits)
+		if err != nil {
+			return nil, err
+		}
+		k.Signer = key
+		k.PrivateKey = pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
+	case "ed25519-sha256":
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		k.Signer = key
+		k.PrivateKey = pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	default:
+		return nil, dkim.ErrSignBadAlgo
+	}
+	return k, nil
+}
+
+// Record returns the DKIM key record (DNS TXT) of the public key
+func (k *KeyPair) Record() (string, error) {
+	return dkim.PublicKeyRecord(k.PrivateKey)
+}
+
+// SigOptions returns signing options using the key pair for domain and
+// selector, with relaxed/relaxed canonicalization and the usual headers
+func (k *KeyPair) SigOptions(domain, selector string) dkim.SigOptions {
+	options := dkim.NewSigOptions()
+	options.PrivateKey = k.PrivateKey
+	options.Algo = k.Algo
+	options.Domain = domain
+	options.Selector = selector
+	options.Canonicalization = "relaxed/relaxed"
+	options.Headers = []string{"from", "to", "subject", "date", "message-id"}
+	return options
+}
+
+// Sign returns a signed copy of email
+func (k *KeyPair) Sign(email []byte, domain, selector string) ([]byte, error) {
+	return SignWith(email, k.SigOptions(domain, selector))
+}
+
+// SignWith returns a copy of email signed with options
+func SignWith(email []byte, options dkim.SigOptions) ([]byte, error) {
+	signed := append([]byte(nil), email...)
+	if err := dkim.Sign(&signed, options); err != nil {
+		return nil, err
+	}
+	return signed, nil
+}
+
+// NewMessage returns a message from "from" with subject and body.
+// Lines of body can be terminated by LF, they are converted to CRLF.
+func NewMessage(from, subject, body string) []byte {
+	body = strings.ReplaceAll(strings.ReplaceAll(body, "\r\n", "\n"), "\n", "\r\n")
+	return []byte("From: " + from + "\r\n" +
+		"To: Bob <bob@example.net>\r\n" +
+		"Subject: " + subject + "\r\n" +
+		"Date: Mon, 19 Oct 2026 10:11:58 +0200\r\n" +
+		"Message-ID: <dkimtest@example.com>\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: text/plain; charset=UTF-8\r\n" +
+		"\r\n" + body)
+}
+
+// Message returns a fixture message from joe@example.com
+func Message() []byte {
+	return NewMessage("Joe <joe@example.com>", "Fixture message", "Hello Bob,\n\nA fixture message.\n\n-- \nJoe\n")
+}
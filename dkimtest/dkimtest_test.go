@@ -0,0 +1,128 @@
+package dkimtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	dkim "github.com/toorop/go-dkim"
+)
+
+func TestSignVerify(t *testing.T) {
+	s, err := NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.Timeout = 500 * time.Millisecond
+
+	for _, algo := range Algorithms {
+		t.Run(algo, func(t *testing.T) {
+			k, err := NewKeyPair(algo)
+			require.NoError(t, err)
+			require.NoError(t, s.SetKey(algo, "example.com", k))
+
+			email, err := k.Sign(Message(), "example.com", algo)
+			require.NoError(t, err)
+			status, err := dkim.Verify(&email, s.DNSOpt())
+			assert.NoError(t, err)
+			assert.Equal(t, dkim.SUCCESS, status)
+		})
+	}
+}
+
+func TestKeyPairRecord(t *testing.T) {
+	k, err := NewKeyPair("ed25519-sha256")
+	require.NoError(t, err)
+	record, err := k.Record()
+	require.NoError(t, err)
+	assert.Contains(t, record, "k=ed25519; p=")
+
+	_, err = (&KeyPair{Algo: "rsa-sha256", PrivateKey: []byte("not a key")}).Record()
+	assert.Error(t, err)
+}
+
+func TestVerifyDNSFailures(t *testing.T) {
+	s, err := NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.Timeout = 500 * time.Millisecond
+
+	k, err := NewKeyPair("ed25519-sha256")
+	require.NoError(t, err)
+	email, err := k.Sign(Message(), "example.com", "sel")
+	require.NoError(t, err)
+	name := "sel._domainkey.example.com"
+	record, err := k.Record()
+	require.NoError(t, err)
+
+	tests := []struct {
+		name    string
+		setup   func()
+		status  interface{}
+		err     error
+		reason  dkim.KeyLookupReason
+		reasonS dkim.KeyLookupReason // with DNSOptStrings
+	}{
+		{
+			name:    "NXDOMAIN",
+			setup:   func() { s.Remove(name) },
+			status:  dkim.PERMFAIL,
+			err:     dkim.ErrVerifyNoKeyForSignature,
+			reason:  dkim.KeyLookupNXDomain,
+			reasonS: dkim.KeyLookupNXDomain,
+		},
+		{
+			name:   "NODATA",
+			setup:  func() { s.SetRcode(name, RcodeSuccess) },
+			status: dkim.PERMFAIL,
+			err:    dkim.ErrVerifyNoKeyForSignature,
+			// the Go resolver doesn't tell NODATA from NXDOMAIN
+			reason:  dkim.KeyLookupNXDomain,
+			reasonS: dkim.KeyLookupNoData,
+		},
+		{
+			name:    "SERVFAIL",
+			setup:   func() { s.SetRcode(name, RcodeServerFailure) },
+			status:  dkim.TEMPFAIL,
+			err:     dkim.ErrVerifyKeyUnavailable,
+			reason:  dkim.KeyLookupServFail,
+			reasonS: dkim.KeyLookupServFail,
+		},
+		{
+			name:    "REFUSED",
+			setup:   func() { s.SetRcode(name, RcodeRefused) },
+			status:  dkim.TEMPFAIL,
+			err:     dkim.ErrVerifyKeyUnavailable,
+			reason:  dkim.KeyLookupOther,
+			reasonS: dkim.KeyLookupRefused,
+		},
+		{
+			name: "timeout",
+			setup: func() {
+				s.SetTXT(name, record)
+				s.SetDelay(name, time.Second)
+			},
+			status:  dkim.TEMPFAIL,
+			err:     dkim.ErrVerifyKeyUnavailable,
+			reason:  dkim.KeyLookupTimeout,
+			reasonS: dkim.KeyLookupTimeout,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.setup()
+			for _, opt := range []struct {
+				dnsOpt dkim.DNSOpt
+				reason dkim.KeyLookupReason
+			}{{s.DNSOpt(), tt.reason}, {s.DNSOptStrings(), tt.reasonS}} {
+				status, err := dkim.Verify(&email, opt.dnsOpt)
+				assert.Equal(t, tt.status, status)
+				assert.ErrorIs(t, err, tt.err)
+				var lookupErr *dkim.KeyLookupError
+				if assert.ErrorAs(t, err, &lookupErr) {
+					assert.Equal(t, opt.reason, lookupErr.Reason, lookupErr.Error())
+				}
+			}
+		})
+	}
+}
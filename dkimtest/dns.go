@@ -0,0 +1,524 @@
+package dkimtest
+
+import (
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	dkim "github.com/toorop/go-dkim"
+)
+
+// Rcode is a DNS response code
+type Rcode int
+
+const (
+	// RcodeSuccess is NOERROR. A name without TXT record is answered
+	// with NOERROR and no data.
+	RcodeSuccess Rcode = 0
+	// RcodeServerFailure is SERVFAIL
+	RcodeServerFailure Rcode = 2
+	// RcodeNameError is NXDOMAIN, the answer for unknown names
+	RcodeNameError Rcode = 3
+	// RcodeRefused is REFUSED
+	RcodeRefused Rcode = 5
+)
+
+const (
+	typeTXT       = 16
+	typeOPT       = 41
+	classIN       = 1
+	ttl           = 300
+	udpSize       = 512
+	maxCharString = 255
+)
+
+var (
+	errBadQuery    = errors.New("dkimtest: bad DNS query")
+	errBadResponse = errors.New("dkimtest: bad DNS response")
+)
+
+// zoneEntry holds what is served for a name
+type zoneEntry struct {
+	txt   [][]string
+	rcode Rcode
+	delay time.Duration
+}
+
+// Server is an in-process authoritative DNS server answering TXT queries
+// over UDP and TCP on the loopback interface.
+//
+// Names are answered with NXDOMAIN until they are configured with SetTXT,
+// SetKey or SetRcode.
+type Server struct {
+	// Timeout of lookups made by LookupTXT and LookupTXTStrings (default 2s)
+	Timeout time.Duration
+
+	udp net.PacketConn
+	tcp net.Listener
+
+	mu      sync.Mutex
+	zone    map[string]*zoneEntry
+	queries map[string]int
+
+	wg     sync.WaitGroup
+	closed chan struct{}
+}
+
+// NewServer starts a DNS server listening on a random port of 127.0.0.1
+// (same port for UDP and TCP). Close must be called to stop it.
+func NewServer() (*Server, error) {
+	s := &Server{
+		Timeout: 2 * time.Second,
+		zone:    map[string]*zoneEntry{},
+		queries: map[string]int{},
+		closed:  make(chan struct{}),
+	}
+	var err error
+	for i := 0; i < 10; i++ {
+		s.udp, err = net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		s.tcp, err = net.Listen("tcp", s.udp.LocalAddr().String())
+		if err == nil {
+			break
+		}
+		s.udp.Close()
+	}
+	if err != nil {
+		return nil, err
+	}
+	s.wg.Add(2)
+	go s.serveUDP()
+	go s.serveTCP()
+	return s, nil
+}
+
+// Addr returns the address the server listens on (host:port)
+func (s *Server) Addr() string {
+	return s.udp.LocalAddr().String()
+}
+
+// Close stops the server
+func (s *Server) Close() error {
+	close(s.closed)
+	err := s.udp.Close()
+	if e := s.tcp.Close(); err == nil {
+		err = e
+	}
+	s.wg.Wait()
+	return err
+}
+
+// entry returns the zone entry of name, creating it if needed. s.mu must be held.
+func (s *Server) entry(name string) *zoneEntry {
+	name = canonicalName(name)
+	e, ok := s.zone[name]
+	if !ok {
+		e = &zoneEntry{}
+		s.zone[name] = e
+	}
+	return e
+}
+
+// SetTXT sets the TXT records of name. Records longer than 255 octets are
+// split into several character-strings.
+func (s *Server) SetTXT(name string, records ...string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	e := s.entry(name)
+	e.txt = nil
+	for _, r := range records {
+		var strs []string
+		for len(r) > maxCharString {
+			strs = append(strs, r[:maxCharString])
+			r = r[maxCharString:]
+		}
+		e.txt = append(e.txt, append(strs, r))
+	}
+	e.rcode = RcodeSuccess
+}
+
+// SetKey publishes the key record of k for selector and domain
+func (s *Server) SetKey(selector, domain string, k *KeyPair) error {
+	record, err := k.Record()
+	if err != nil {
+		return err
+	}
+	s.SetTXT(selector+"._domainkey."+domain, record)
+	return nil
+}
+
+// SetRcode sets the response code returned for name. With RcodeSuccess and
+// no TXT record, name exists but has no data.
+func (s *Server) SetRcode(name string, rcode Rcode) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entry(name).rcode = rcode
+}
+
+// SetDelay delays the answers for name
+func (s *Server) SetDelay(name string, delay time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.entry(name).delay = delay
+}
+
+// Remove removes name, which is then answered with NXDOMAIN
+func (s *Server) Remove(name string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.zone, canonicalName(name))
+}
+
+// Queries returns the number of queries received for name
+func (s *Server) Queries(name string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.queries[canonicalName(name)]
+}
+
+// Resolver returns a resolver sending all its queries to the server
+func (s *Server) Resolver() *net.Resolver {
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, s.Addr())
+		},
+	}
+}
+
+// LookupTXT looks up the TXT records of name on the server.
+// Errors are the *net.DNSError returned by the Go resolver.
+func (s *Server) LookupTXT(name string) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+	if !strings.HasSuffix(name, ".") {
+		// rooted name, so that search domains are not tried
+		name += "."
+	}
+	return s.Resolver().LookupTXT(ctx, name)
+}
+
+// DNSOpt returns a dkim.DNSOpt looking up key records on the server
+func (s *Server) DNSOpt() dkim.DNSOpt {
+	return dkim.DNSOptLookupTXT(s.LookupTXT)
+}
+
+func (s *Server) serveUDP() {
+	defer s.wg.Done()
+	buf := make([]byte, 65535)
+	for {
+		n, addr, err := s.udp.ReadFrom(buf)
+		if err != nil {
+			return
+		}
+		query := append([]byte(nil), buf[:n]...)
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			resp, maxSize, err := s.answer(query)
+			if err != nil {
+				return
+			}
+			if len(resp) > maxSize {
+				resp = truncate(resp, query)
+			}
+			s.udp.WriteTo(resp, addr)
+		}()
+	}
+}
+
+func (s *Server) serveTCP() {
+	defer s.wg.Done()
+	for {
+		conn, err := s.tcp.Accept()
+		if err != nil {
+			return
+		}
+		s.wg.Add(1)
+		go func() {
+			defer s.wg.Done()
+			defer conn.Close()
+			go func() {
+				<-s.closed
+				conn.Close()
+			}()
+			for {
+				var l [2]byte
+				if _, err := io.ReadFull(conn, l[:]); err != nil {
+					return
+				}
+				query := make([]byte, binary.BigEndian.Uint16(l[:]))
+				if _, err := io.ReadFull(conn, query); err != nil {
+					return
+				}
+				resp, _, err := s.answer(query)
+				if err != nil {
+					return
+				}
+				binary.BigEndian.PutUint16(l[:], uint16(len(resp)))
+				if _, err := conn.Write(append(l[:], resp...)); err != nil {
+					return
+				}
+			}
+		}()
+	}
+}
+
+// answer builds the response to query and returns it with the maximum
+// size of a UDP response
+func (s *Server) answer(query []byte) ([]byte, int, error) {
+	name, qtype, qend, maxSize, err := parseQuery(query)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	s.mu.Lock()
+	s.queries[name]++
+	rcode := RcodeNameError
+	var txt [][]string
+	var delay time.Duration
+	if e, ok := s.zone[name]; ok {
+		rcode, delay = e.rcode, e.delay
+		if qtype == typeTXT {
+			txt = e.txt
+		}
+	}
+	s.mu.Unlock()
+
+	if delay > 0 {
+		select {
+		case <-time.After(delay):
+		case <-s.closed:
+			return nil, 0, errBadQuery
+		}
+	}
+	if rcode != RcodeSuccess {
+		txt = nil
+	}
+
+	// header: ID, QR AA (RD) RA rcode, 1 question, answers
+	resp := make([]byte, 12, 512)
+	copy(resp, query[:2])
+	flags := uint16(0x8000|0x0400|0x0080) | binary.BigEndian.Uint16(query[2:4])&0x0100 | uint16(rcode)
+	binary.BigEndian.PutUint16(resp[2:], flags)
+	binary.BigEndian.PutUint16(resp[4:], 1)
+	binary.BigEndian.PutUint16(resp[6:], uint16(len(txt)))
+	resp = append(resp, query[12:qend]...)
+	for _, strs := range txt {
+		// name is a pointer to the question
+		resp = append(resp, 0xc0, 12)
+		resp = binary.BigEndian.AppendUint16(resp, typeTXT)
+		resp = binary.BigEndian.AppendUint16(resp, classIN)
+		resp = binary.BigEndian.AppendUint32(resp, ttl)
+		rdlength := 0
+		for _, str := range strs {
+			rdlength += 1 + len(str)
+		}
+		resp = binary.BigEndian.AppendUint16(resp, uint16(rdlength))
+		for _, str := range strs {
+			resp = append(resp, byte(len(str)))
+			resp = append(resp, str...)
+		}
+	}
+	return resp, maxSize, nil
+}
+
+// parseQuery returns the name and type of the question of query, the
+// offset of the end of the question and the maximum UDP response size
+func parseQuery(query []byte) (name string, qtype uint16, qend int, maxSize int, err error) {
+	if len(query) < 12 || query[2]&0x80 != 0 || binary.BigEndian.Uint16(query[4:]) != 1 {
+		return "", 0, 0, 0, errBadQuery
+	}
+	var labels []string
+	pos := 12
+	for {
+		if pos >= len(query) {
+			return "", 0, 0, 0, errBadQuery
+		}
+		l := int(query[pos])
+		pos++
+		if l == 0 {
+			break
+		}
+		if l > 63 || pos+l > len(query) {
+			return "", 0, 0, 0, errBadQuery
+		}
+		labels = append(labels, string(query[pos:pos+l]))
+		pos += l
+	}
+	if pos+4 > len(query) {
+		return "", 0, 0, 0, errBadQuery
+	}
+	qtype = binary.BigEndian.Uint16(query[pos:])
+	qend = pos + 4
+
+	// EDNS0 OPT record: root name, type OPT, class is the UDP payload size
+	maxSize = udpSize
+	if binary.BigEndian.Uint16(query[10:]) > 0 && qend+5 <= len(query) &&
+		query[qend] == 0 && binary.BigEndian.Uint16(query[qend+1:]) == typeOPT {
+		if size := int(binary.BigEndian.Uint16(query[qend+3:])); size > maxSize {
+			maxSize = size
+		}
+	}
+	return canonicalName(strings.Join(labels, ".")), qtype, qend, maxSize, nil
+}
+
+// truncate returns the header and question of resp with the TC flag set
+func truncate(resp, query []byte) []byte {
+	_, _, qend, _, _ := parseQuery(query)
+	resp = resp[:qend]
+	resp[2] |= 0x02
+	binary.BigEndian.PutUint16(resp[6:], 0)
+	return resp
+}
+
+// canonicalName returns name lowercased without trailing dot
+func canonicalName(name string) string {
+	return strings.ToLower(strings.TrimSuffix(name, "."))
+}
+
+// LookupTXTStrings looks up the TXT records of name on the server with a
+// minimal DNS client which, unlike the Go resolver, reports the response
+// code: a failed query returns a *dkim.RcodeError, with dkim.RcodeNoError
+// when name exists but has no TXT record.
+func (s *Server) LookupTXTStrings(name string) ([][]string, error) {
+	query := make([]byte, 12, 512)
+	binary.BigEndian.PutUint16(query, uint16(time.Now().UnixNano()))
+	binary.BigEndian.PutUint16(query[2:], 0x0100) // RD
+	binary.BigEndian.PutUint16(query[4:], 1)
+	for _, label := range strings.Split(canonicalName(name), ".") {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	query = append(query, 0)
+	query = binary.BigEndian.AppendUint16(query, typeTXT)
+	query = binary.BigEndian.AppendUint16(query, classIN)
+
+	resp, err := s.exchange("udp", query)
+	if err == nil && resp[2]&0x02 != 0 {
+		// truncated
+		resp, err = s.exchange("tcp", query)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	txt, err := parseTXTResponse(resp, query)
+	if err != nil {
+		return nil, err
+	}
+	if rcode := int(resp[3] & 0x0f); rcode != dkim.RcodeNoError || len(txt) == 0 {
+		return nil, &dkim.RcodeError{Name: name, Rcode: rcode}
+	}
+	return txt, nil
+}
+
+// DNSOptStrings returns a dkim.DNSOpt looking up key records on the server
+// with LookupTXTStrings
+func (s *Server) DNSOptStrings() dkim.DNSOpt {
+	return dkim.DNSOptLookupTXTStrings(s.LookupTXTStrings)
+}
+
+// exchange sends query to the server over network and returns the response
+func (s *Server) exchange(network string, query []byte) ([]byte, error) {
+	conn, err := net.DialTimeout(network, s.Addr(), s.Timeout)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(s.Timeout))
+
+	if network == "udp" {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		buf := make([]byte, 65535)
+		for {
+			n, err := conn.Read(buf)
+			if err != nil {
+				return nil, err
+			}
+			// ignore answers to other queries
+			if n >= 12 && buf[0] == query[0] && buf[1] == query[1] {
+				return buf[:n], nil
+			}
+		}
+	}
+
+	msg := binary.BigEndian.AppendUint16(nil, uint16(len(query)))
+	if _, err := conn.Write(append(msg, query...)); err != nil {
+		return nil, err
+	}
+	var l [2]byte
+	if _, err := io.ReadFull(conn, l[:]); err != nil {
+		return nil, err
+	}
+	resp := make([]byte, binary.BigEndian.Uint16(l[:]))
+	if _, err := io.ReadFull(conn, resp); err != nil {
+		return nil, err
+	}
+	if len(resp) < 12 {
+		return nil, errBadResponse
+	}
+	return resp, nil
+}
+
+// parseTXTResponse returns the TXT records of a response to query
+func parseTXTResponse(resp, query []byte) ([][]string, error) {
+	_, _, qend, _, err := parseQuery(query)
+	if err != nil || len(resp) < qend || string(resp[12:qend]) != string(query[12:qend]) {
+		return nil, errBadResponse
+	}
+	pos := qend
+	var txt [][]string
+	for i := 0; i < int(binary.BigEndian.Uint16(resp[6:])); i++ {
+		// owner name: labels and/or a compression pointer
+		for {
+			if pos >= len(resp) {
+				return nil, errBadResponse
+			}
+			l := int(resp[pos])
+			if l&0xc0 == 0xc0 {
+				pos += 2
+				break
+			}
+			pos += 1 + l
+			if l == 0 {
+				break
+			}
+		}
+		if pos+10 > len(resp) {
+			return nil, errBadResponse
+		}
+		rtype := binary.BigEndian.Uint16(resp[pos:])
+		rdlength := int(binary.BigEndian.Uint16(resp[pos+8:]))
+		pos += 10
+		if pos+rdlength > len(resp) {
+			return nil, errBadResponse
+		}
+		rdata := resp[pos : pos+rdlength]
+		pos += rdlength
+		if rtype != typeTXT {
+			continue
+		}
+		var strs []string
+		for len(rdata) > 0 {
+			l := int(rdata[0])
+			if 1+l > len(rdata) {
+				return nil, errBadResponse
+			}
+			strs = append(strs, string(rdata[1:1+l]))
+			rdata = rdata[1+l:]
+		}
+		txt = append(txt, strs)
+	}
+	return txt, nil
+}
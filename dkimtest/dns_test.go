@@ -0,0 +1,83 @@
+package dkimtest
+
+import (
+	"errors"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	dkim "github.com/toorop/go-dkim"
+)
+
+func TestServer(t *testing.T) {
+	s, err := NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.Timeout = 500 * time.Millisecond
+
+	long := strings.Repeat("a", 1000)
+	s.SetTXT("txt.example.com", "v=DKIM1; p=abc", "second record")
+	s.SetTXT("long.example.com", long)
+	s.SetRcode("servfail.example.com", RcodeServerFailure)
+	s.SetRcode("nodata.example.com", RcodeSuccess)
+	s.SetTXT("slow.example.com", "slow")
+	s.SetDelay("slow.example.com", time.Second)
+
+	txt, err := s.LookupTXT("TXT.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v=DKIM1; p=abc", "second record"}, txt)
+	assert.Equal(t, 1, s.Queries("txt.example.com."))
+
+	// truncated over UDP, answered over TCP
+	txt, err = s.LookupTXT("long.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, []string{long}, txt)
+
+	var dnsErr *net.DNSError
+
+	_, err = s.LookupTXT("unknown.example.com")
+	require.True(t, errors.As(err, &dnsErr), err)
+	assert.True(t, dnsErr.IsNotFound)
+
+	_, err = s.LookupTXT("nodata.example.com")
+	require.True(t, errors.As(err, &dnsErr), err)
+	assert.True(t, dnsErr.IsNotFound)
+
+	_, err = s.LookupTXT("servfail.example.com")
+	require.True(t, errors.As(err, &dnsErr), err)
+	assert.False(t, dnsErr.IsNotFound)
+	assert.True(t, dnsErr.IsTemporary)
+
+	_, err = s.LookupTXT("slow.example.com")
+	require.True(t, errors.As(err, &dnsErr), err)
+	assert.True(t, dnsErr.IsTimeout)
+
+	s.Remove("txt.example.com")
+	_, err = s.LookupTXT("txt.example.com")
+	require.True(t, errors.As(err, &dnsErr), err)
+	assert.True(t, dnsErr.IsNotFound)
+}
+
+func TestServerLookupTXTStrings(t *testing.T) {
+	s, err := NewServer()
+	require.NoError(t, err)
+	defer s.Close()
+	s.Timeout = 500 * time.Millisecond
+
+	long := strings.Repeat("b", 1000)
+	s.SetTXT("txt.example.com", "v=DKIM1; p=abc", long)
+	s.SetRcode("nodata.example.com", RcodeSuccess)
+
+	txt, err := s.LookupTXTStrings("txt.example.com")
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{{"v=DKIM1; p=abc"}, {long[:255], long[255:510], long[510:765], long[765:]}}, txt)
+
+	_, err = s.LookupTXTStrings("nodata.example.com")
+	assert.Equal(t, &dkim.RcodeError{Name: "nodata.example.com", Rcode: dkim.RcodeNoError}, err)
+
+	_, err = s.LookupTXTStrings("unknown.example.com")
+	assert.Equal(t, &dkim.RcodeError{Name: "unknown.example.com", Rcode: dkim.RcodeNXDomain}, err)
+}
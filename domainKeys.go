@@ -0,0 +1,391 @@
+package dkim
+
+import (
+	"bytes"
+	"errors"
+	"net/mail"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DomainKeysStatus is the result of a DomainKeys verification, as in the
+// DomainKey-Status header field (RFC 4870 section 3.5)
+type DomainKeysStatus int
+
+const (
+	// DomainKeysGood the signature verified
+	DomainKeysGood DomainKeysStatus = iota + 1
+	// DomainKeysBad the signature didn't verify
+	DomainKeysBad
+	// DomainKeysNoKey the key couldn't be retrieved or parsed
+	DomainKeysNoKey
+	// DomainKeysRevoked the key is revoked (p= empty)
+	DomainKeysRevoked
+	// DomainKeysNoSignature the message has no DomainKey-Signature
+	DomainKeysNoSignature
+	// DomainKeysBadFormat the DomainKey-Signature or the sender is invalid
+	DomainKeysBadFormat
+)
+
+// String returns the status as in a DomainKey-Status header field
+func (s DomainKeysStatus) String() string {
+	switch s {
+	case DomainKeysGood:
+		return "good"
+	case DomainKeysBad:
+		return "bad"
+	case DomainKeysNoKey:
+		return "no key"
+	case DomainKeysRevoked:
+		return "revoked"
+	case DomainKeysNoSignature:
+		return "no signature"
+	case DomainKeysBadFormat:
+		return "bad format"
+	}
+	return "DomainKeysStatus(" + strconv.Itoa(int(s)) + ")"
+}
+
+// DomainKeysHeader is a parsed DomainKey-Signature header (RFC 4870)
+type DomainKeysHeader struct {
+	// Algorithm, only "rsa-sha1" is defined
+	// tag a
+	Algorithm string
+
+	// The signature data (base64)
+	// tag b
+	SignatureData string
+
+	// Canonicalization, "simple" (default) or "nofws"
+	// tag c
+	Canonicalization string
+
+	// Domain of the signing entity
+	// tag d
+	Domain string
+
+	// Headers lists the signed header fields. If empty, all the fields
+	// following the DomainKey-Signature are signed.
+	// tag h
+	Headers []string
+
+	// QueryMethod used to retrieve the key, only "dns" is defined
+	// tag q
+	QueryMethod string
+
+	// Selector of the key
+	// tag s
+	Selector string
+}
+
+// ParseDomainKeysHeader parses a DomainKey-Signature header field
+func ParseDomainKeysHeader(header string) (*DomainKeysHeader, error) {
+	keyVal := strings.SplitN(header, ":", 2)
+	if len(keyVal) != 2 || !strings.EqualFold(strings.TrimSpace(keyVal[0]), "domainkey-signature") {
+		return nil, ErrDomainKeysBadFormat
+	}
+	tags, err := ParseTagList(keyVal[1])
+	if err != nil {
+		return nil, ErrDomainKeysBadFormat
+	}
+
+	dkh := &DomainKeysHeader{Algorithm: "rsa-sha1", Canonicalization: "simple", QueryMethod: "dns"}
+	for _, tag := range tags {
+		data := removeWS(tag.Value)
+		switch strings.ToLower(tag.Name) {
+		case "a":
+			dkh.Algorithm = strings.ToLower(data)
+		case "b":
+			dkh.SignatureData = data
+		case "c":
+			dkh.Canonicalization = strings.ToLower(data)
+		case "d":
+			dkh.Domain = strings.ToLower(data)
+		case "h":
+			for _, h := range strings.Split(strings.ToLower(data), ":") {
+				if h != "" {
+					dkh.Headers = append(dkh.Headers, h)
+				}
+			}
+		case "q":
+			dkh.QueryMethod = strings.ToLower(data)
+		case "s":
+			dkh.Selector = strings.ToLower(data)
+		}
+	}
+	if dkh.SignatureData == "" || dkh.Domain == "" || dkh.Selector == "" {
+		return nil, ErrDomainKeysBadFormat
+	}
+	if dkh.Algorithm != "rsa-sha1" || dkh.QueryMethod != "dns" {
+		return nil, ErrDomainKeysBadFormat
+	}
+	if dkh.Canonicalization != "simple" && dkh.Canonicalization != "nofws" {
+		return nil, ErrDomainKeysBadFormat
+	}
+	return dkh, nil
+}
+
+// DomainKeysPolicy is the sending domain policy record, published at
+// _domainkey.<domain>
+type DomainKeysPolicy struct {
+	// SignsAll is true if all the mail of the domain is signed (o=-),
+	// false if only some is (o=~, default)
+	SignsAll bool
+
+	// Testing is true if the domain is testing DomainKeys (t=y)
+	Testing bool
+
+	// ReportAddress (r=) and Note (n=)
+	ReportAddress string
+	Note          string
+}
+
+// LookupDomainKeysPolicy retrieves the DomainKeys policy of domain. It
+// returns a *KeyLookupError if the record can't be retrieved.
+func LookupDomainKeysPolicy(domain string, opts ...DNSOpt) (*DomainKeysPolicy, error) {
+	return lookupDomainKeysPolicy(domain, newDNSOptions(opts))
+}
+
+func lookupDomainKeysPolicy(domain string, dnsOpts *DNSOptions) (*DomainKeysPolicy, error) {
+	name := "_domainkey." + domain
+	txt, err := dnsOpts.lookupTXT(name)
+	if err != nil {
+		return nil, newKeyLookupError(name, err)
+	}
+	for _, record := range txt {
+		tags, err := ParseTagList(record)
+		if err != nil {
+			continue
+		}
+		policy := &DomainKeysPolicy{}
+		o, _ := tags.Get("o")
+		policy.SignsAll = removeWS(o) == "-"
+		t, _ := tags.Get("t")
+		policy.Testing = strings.ToLower(removeWS(t)) == "y"
+		policy.ReportAddress, _ = tags.Get("r")
+		policy.Note, _ = tags.Get("n")
+		return policy, nil
+	}
+	return nil, &KeyLookupError{Name: name, Reason: KeyLookupNoData}
+}
+
+// DomainKeysResult is the result of VerifyDomainKeys
+type DomainKeysResult struct {
+	Status DomainKeysStatus
+
+	// Err is the reason of a status other than DomainKeysGood
+	Err error
+
+	// Header is the parsed DomainKey-Signature, nil if there is none or it
+	// is invalid
+	Header *DomainKeysHeader
+
+	// Sender is the sending address (Sender, or From if there is no Sender)
+	Sender string
+
+	// Testing is true if the key has the testing flag (t=y)
+	Testing bool
+
+	// Policy of the sending domain, retrieved if the signature isn't good
+	// (nil if the domain has no policy)
+	Policy *DomainKeysPolicy
+}
+
+// Suspicious returns true if the signature isn't good and the sending domain
+// says it signs all its mail, outside testing
+func (r *DomainKeysResult) Suspicious() bool {
+	return r.Status != DomainKeysGood && r.Policy != nil && r.Policy.SignsAll && !r.Policy.Testing && !r.Testing
+}
+
+// VerifyDomainKeys verifies the first DomainKey-Signature of email (legacy
+// DomainKeys, RFC 4870). Its result is distinct from the DKIM result:
+// signing new mail with DomainKeys is not supported.
+//
+// The key is retrieved from DNS with the same options as DKIM keys. If the
+// signature isn't good, the policy of the sending domain is retrieved too.
+func VerifyDomainKeys(email []byte, opts ...DNSOpt) *DomainKeysResult {
+	dnsOpts := newDNSOptions(opts)
+	res := &DomainKeysResult{}
+	res.Status, res.Err = verifyDomainKeys(email, dnsOpts, res)
+	if res.Status != DomainKeysGood && res.Sender != "" {
+		res.Policy, _ = lookupDomainKeysPolicy(res.Sender[strings.LastIndex(res.Sender, "@")+1:], dnsOpts)
+	}
+	return res
+}
+
+func verifyDomainKeys(email []byte, dnsOpts *DNSOptions, res *DomainKeysResult) (DomainKeysStatus, error) {
+	rawHeaders, body, err := getHeadersBody(&email, LineEndingsAuto)
+	if err != nil {
+		return DomainKeysBadFormat, err
+	}
+	headers, err := getHeadersList(&rawHeaders)
+	if err != nil {
+		return DomainKeysBadFormat, err
+	}
+
+	// the sender
+	var sender, from string
+	for _, h := range headers {
+		name, value, _ := strings.Cut(h, ":")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "sender":
+			if sender == "" {
+				sender = value
+			}
+		case "from":
+			if from == "" {
+				from = value
+			}
+		}
+	}
+	if sender == "" {
+		sender = from
+	}
+	if sender != "" {
+		if addr, err := mail.ParseAddress(strings.TrimSpace(sender)); err == nil {
+			res.Sender = strings.ToLower(addr.Address)
+		}
+	}
+
+	// the signature and the fields which follow it
+	sig := -1
+	for i, h := range headers {
+		if len(h) >= 19 && strings.EqualFold(h[:19], "domainkey-signature") {
+			sig = i
+			break
+		}
+	}
+	if sig == -1 {
+		return DomainKeysNoSignature, nil
+	}
+	res.Header, err = ParseDomainKeysHeader(headers[sig])
+	if err != nil {
+		return DomainKeysBadFormat, err
+	}
+	if res.Sender == "" {
+		return DomainKeysBadFormat, ErrDomainKeysNoSender
+	}
+
+	// d= must be the sending domain or a parent
+	senderLocal, senderDomain, _ := strings.Cut(res.Sender, "@")
+	if senderDomain != res.Header.Domain && !strings.HasSuffix(senderDomain, "."+res.Header.Domain) {
+		return DomainKeysBad, ErrDomainKeysDomainMismatch
+	}
+
+	// key
+	name := res.Header.Selector + "._domainkey." + res.Header.Domain
+	start := time.Now()
+	pubKey, granularity, err := lookupDomainKeysKey(name, dnsOpts)
+	dnsOpts.observeKeyLookup(name, false, start, err)
+	if err != nil {
+		if errors.Is(err, ErrVerifyRevokedKey) {
+			return DomainKeysRevoked, err
+		}
+		return DomainKeysNoKey, err
+	}
+	res.Testing = pubKey.FlagTesting
+	if pubKey.KeyType != "rsa" {
+		return DomainKeysNoKey, ErrVerifyBadKeyType
+	}
+	if granularity != nil {
+		if ok, _ := path.Match(*granularity, senderLocal); !ok {
+			return DomainKeysBad, ErrDomainKeysGranularity
+		}
+	}
+
+	signed := headers[sig+1:]
+	if len(res.Header.Headers) != 0 {
+		signed = nil
+		for _, h := range headers[sig+1:] {
+			name, _, _ := strings.Cut(h, ":")
+			name = strings.ToLower(strings.TrimRight(name, " \t"))
+			for _, keep := range res.Header.Headers {
+				if name == keep {
+					signed = append(signed, h)
+					break
+				}
+			}
+		}
+	}
+	toSign := canonicalizeDomainKeys(signed, body, res.Header.Canonicalization)
+	if err := verifySignature(toSign, res.Header.SignatureData, pubKey, "sha1"); err != nil {
+		return DomainKeysBad, err
+	}
+	return DomainKeysGood, nil
+}
+
+// lookupDomainKeysKey retrieves the key record name and returns the key and
+// its granularity (g=), nil if the record has none
+func lookupDomainKeysKey(name string, dnsOpts *DNSOptions) (*PubKeyRep, *string, error) {
+	txt, err := dnsOpts.lookupTXT(name)
+	if err != nil {
+		return nil, nil, newKeyLookupError(name, err)
+	}
+	pubKey, _, err := newPubKeyRespFromRecords(name, txt, dnsOpts.multipleRecords)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, record := range txt {
+		if tags, err := ParseTagList(record); err == nil {
+			if g, ok := tags.Get("g"); ok {
+				g = removeWS(g)
+				return pubKey, &g, nil
+			}
+		}
+	}
+	return pubKey, nil, nil
+}
+
+// canonicalizeDomainKeys returns the data signed by a DomainKeys signature:
+// the header fields, the empty line and the body (RFC 4870 section 3.4).
+// Trailing empty lines of the body are ignored, "nofws" also removes all
+// whitespace and unfolds the header fields.
+func canonicalizeDomainKeys(headers []string, body []byte, cano string) []byte {
+	var out bytes.Buffer
+	for _, h := range headers {
+		if cano == "nofws" {
+			h = removeNoFWS(h)
+		}
+		out.WriteString(h + CRLF)
+	}
+	out.WriteString(CRLF)
+
+	lines := strings.SplitAfter(string(body), CRLF)
+	if cano == "nofws" {
+		for i, line := range lines {
+			lines[i] = removeNoFWS(line)
+			if strings.HasSuffix(line, CRLF) {
+				lines[i] += CRLF
+			}
+		}
+	}
+	// trailing empty lines
+	n := len(lines)
+	for n > 0 && (lines[n-1] == CRLF || lines[n-1] == "") {
+		n--
+	}
+	for _, line := range lines[:n] {
+		out.WriteString(line)
+	}
+	if n > 0 && !strings.HasSuffix(lines[n-1], CRLF) {
+		out.WriteString(CRLF)
+	}
+	return out.Bytes()
+}
+
+// removeNoFWS removes the whitespace removed by "nofws": SP, HTAB, CR and
+// LF. Other octets, including non-ASCII spaces, are signed.
+func removeNoFWS(in string) string {
+	var b strings.Builder
+	b.Grow(len(in))
+	for i := 0; i < len(in); i++ {
+		switch c := in[i]; c {
+		case ' ', '\t', '\r', '\n':
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return b.String()
+}
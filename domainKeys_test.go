@@ -0,0 +1,238 @@
+package dkim
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"encoding/base64"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseDomainKeysHeader(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name   string
+		Header string
+		Expect *DomainKeysHeader
+		Err    error
+	}{
+		{
+			Name:   "defaults",
+			Header: "DomainKey-Signature: s=test; d=tmail.io; b=YWJj",
+			Expect: &DomainKeysHeader{Algorithm: "rsa-sha1", SignatureData: "YWJj", Canonicalization: "simple",
+				Domain: "tmail.io", QueryMethod: "dns", Selector: "test"},
+		},
+		{
+			Name:   "all tags",
+			Header: "DomainKey-Signature: a=rsa-sha1; q=dns; c=nofws;\r\n s=Test; d=TMAIL.io; h=From:To:\r\n Subject; b=YW\r\n Jj",
+			Expect: &DomainKeysHeader{Algorithm: "rsa-sha1", SignatureData: "YWJj", Canonicalization: "nofws",
+				Domain: "tmail.io", Headers: []string{"from", "to", "subject"}, QueryMethod: "dns", Selector: "test"},
+		},
+		{Name: "no b", Header: "DomainKey-Signature: s=test; d=tmail.io", Err: ErrDomainKeysBadFormat},
+		{Name: "no d", Header: "DomainKey-Signature: s=test; b=YWJj", Err: ErrDomainKeysBadFormat},
+		{Name: "bad algo", Header: "DomainKey-Signature: a=rsa-sha256; s=test; d=tmail.io; b=YWJj", Err: ErrDomainKeysBadFormat},
+		{Name: "bad canonicalization", Header: "DomainKey-Signature: c=relaxed; s=test; d=tmail.io; b=YWJj", Err: ErrDomainKeysBadFormat},
+		{Name: "bad query method", Header: "DomainKey-Signature: q=dns/txt; s=test; d=tmail.io; b=YWJj", Err: ErrDomainKeysBadFormat},
+		{Name: "DKIM-Signature", Header: "DKIM-Signature: s=test; d=tmail.io; b=YWJj", Err: ErrDomainKeysBadFormat},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			h, err := ParseDomainKeysHeader(tc.Header)
+			assert.Equal(t, tc.Err, err)
+			assert.Equal(t, tc.Expect, h)
+		})
+	}
+}
+
+func Test_canonicalizeDomainKeys(t *testing.T) {
+	headers := []string{"From: Joe <joe@tmail.io>", "Subject:  hello\r\n\tworld "}
+	body := []byte("Hello  world " + CRLF + " " + CRLF + "bye" + CRLF + CRLF + " " + CRLF)
+
+	assert.Equal(t, "From: Joe <joe@tmail.io>"+CRLF+"Subject:  hello\r\n\tworld "+CRLF+CRLF+
+		"Hello  world "+CRLF+" "+CRLF+"bye"+CRLF+CRLF+" "+CRLF,
+		string(canonicalizeDomainKeys(headers, body, "simple")))
+	assert.Equal(t, "From:Joe<joe@tmail.io>"+CRLF+"Subject:helloworld"+CRLF+CRLF+
+		"Helloworld"+CRLF+CRLF+"bye"+CRLF,
+		string(canonicalizeDomainKeys(headers, body, "nofws")))
+
+	// only SP, HTAB, CR and LF are removed
+	nbsp := "a\u00a0b\v\fc\u2003"
+	assert.Equal(t, "Subject:"+nbsp+CRLF+CRLF+nbsp+CRLF,
+		string(canonicalizeDomainKeys([]string{"Subject: " + nbsp + " \r\n "}, []byte(nbsp+" \t"+CRLF), "nofws")))
+
+	// trailing empty lines are ignored, a missing final CRLF is added
+	assert.Equal(t, "From: Joe"+CRLF+CRLF+"Hello"+CRLF,
+		string(canonicalizeDomainKeys([]string{"From: Joe"}, []byte("Hello"+CRLF+CRLF+CRLF), "simple")))
+	assert.Equal(t, "From: Joe"+CRLF+CRLF+"Hello"+CRLF,
+		string(canonicalizeDomainKeys([]string{"From: Joe"}, []byte("Hello"), "simple")))
+	assert.Equal(t, "From: Joe"+CRLF+CRLF,
+		string(canonicalizeDomainKeys([]string{"From: Joe"}, []byte(CRLF), "simple")))
+}
+
+// signDomainKeys returns email with a DomainKey-Signature of the tmail.io
+// test key
+func signDomainKeys(t *testing.T, email, cano string, headers []string) string {
+	t.Helper()
+	raw := []byte(email)
+	rawHeaders, body, err := getHeadersBody(&raw, LineEndingsAuto)
+	require.NoError(t, err)
+	fields, err := getHeadersList(&rawHeaders)
+	require.NoError(t, err)
+	if len(headers) != 0 {
+		var signed []string
+		for _, f := range fields {
+			name, _, _ := strings.Cut(f, ":")
+			for _, h := range headers {
+				if strings.EqualFold(name, h) {
+					signed = append(signed, f)
+				}
+			}
+		}
+		fields = signed
+	}
+	digest := sha1.Sum(canonicalizeDomainKeys(fields, body, cano))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, privKeyRSA(t), crypto.SHA1, digest[:])
+	require.NoError(t, err)
+
+	header := "DomainKey-Signature: a=rsa-sha1; q=dns; c=" + cano + ";" + CRLF + " s=" + selector + "; d=" + domain + ";"
+	if len(headers) != 0 {
+		header += " h=" + strings.Join(headers, ":") + ";"
+	}
+	return header + CRLF + " b=" + base64.StdEncoding.EncodeToString(sig) + CRLF + email
+}
+
+func TestVerifyDomainKeys(t *testing.T) {
+	records := map[string]string{
+		selector + "._domainkey." + domain: "k=rsa; p=" + pubKey,
+		"_domainkey." + domain:             "o=-; r=postmaster@tmail.io",
+		"revoked._domainkey." + domain:     "k=rsa; p=",
+		"testing._domainkey." + domain:     "t=y; p=" + pubKey,
+		"joe._domainkey." + domain:         "g=joe*; p=" + pubKey,
+	}
+	lookup := DNSOptLookupTXT(func(name string) ([]string, error) {
+		if record, ok := records[name]; ok {
+			return []string{record}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	})
+
+	simple := signDomainKeys(t, emailBase, "simple", nil)
+	nofws := signDomainKeys(t, emailBase, "nofws", []string{"from", "to", "subject"})
+
+	testCases := []struct {
+		Name       string
+		Email      string
+		Status     DomainKeysStatus
+		Err        error
+		Testing    bool
+		Suspicious bool
+	}{
+		{Name: "simple", Email: simple, Status: DomainKeysGood},
+		{Name: "nofws", Email: nofws, Status: DomainKeysGood},
+		{
+			Name:   "trailing empty lines",
+			Email:  simple + CRLF + CRLF,
+			Status: DomainKeysGood,
+		},
+		{
+			Name:   "nofws whitespace changed",
+			Email:  strings.Replace(nofws, "Subject: Test DKIM", "Subject:   Test\r\n DKIM", 1),
+			Status: DomainKeysGood,
+		},
+		{
+			Name:   "nofws unsigned header changed",
+			Email:  strings.Replace(nofws, "Date: Fri", "Date: Sat", 1),
+			Status: DomainKeysGood,
+		},
+		{
+			Name:       "nofws non-ASCII space",
+			Email:      strings.Replace(nofws, "Hello world", "Hello\u00a0world", 1),
+			Status:     DomainKeysBad,
+			Err:        rsa.ErrVerification,
+			Suspicious: true,
+		},
+		{
+			Name:       "simple body changed",
+			Email:      strings.Replace(simple, "Hello world", "Hello  world", 1),
+			Status:     DomainKeysBad,
+			Err:        rsa.ErrVerification,
+			Suspicious: true,
+		},
+		{
+			Name:   "field before the signature not signed",
+			Email:  "X-Spam: yes" + CRLF + simple,
+			Status: DomainKeysGood,
+		},
+		{
+			Name:       "unsigned",
+			Email:      emailBase,
+			Status:     DomainKeysNoSignature,
+			Suspicious: true,
+		},
+		{
+			Name:       "revoked",
+			Email:      strings.Replace(simple, "s="+selector, "s=revoked", 1),
+			Status:     DomainKeysRevoked,
+			Err:        ErrVerifyRevokedKey,
+			Suspicious: true,
+		},
+		{
+			Name:    "testing",
+			Email:   strings.Replace(strings.Replace(simple, "s="+selector, "s=testing", 1), "Hello world", "Bye", 1),
+			Status:  DomainKeysBad,
+			Err:     rsa.ErrVerification,
+			Testing: true,
+		},
+		{
+			Name:       "granularity",
+			Email:      strings.Replace(simple, "s="+selector, "s=joe", 1),
+			Status:     DomainKeysBad,
+			Err:        ErrDomainKeysGranularity,
+			Suspicious: true,
+		},
+		{
+			Name:       "other domain",
+			Email:      strings.Replace(simple, "d="+domain, "d=toorop.fr", 1),
+			Status:     DomainKeysBad,
+			Err:        ErrDomainKeysDomainMismatch,
+			Suspicious: true,
+		},
+		{
+			Name:       "bad format",
+			Email:      strings.Replace(simple, "a=rsa-sha1", "a=rsa-md5", 1),
+			Status:     DomainKeysBadFormat,
+			Err:        ErrDomainKeysBadFormat,
+			Suspicious: true,
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			res := VerifyDomainKeys([]byte(tc.Email), lookup)
+			assert.Equal(t, tc.Status, res.Status, res.Status.String())
+			assert.Equal(t, tc.Err, res.Err)
+			assert.Equal(t, tc.Testing, res.Testing)
+			assert.Equal(t, tc.Suspicious, res.Suspicious())
+			assert.Equal(t, "toorop@tmail.io", res.Sender)
+		})
+	}
+
+	t.Run("no key", func(t *testing.T) {
+		res := VerifyDomainKeys([]byte(strings.Replace(simple, "s="+selector, "s=none", 1)), lookup)
+		assert.Equal(t, DomainKeysNoKey, res.Status)
+		var lookupErr *KeyLookupError
+		assert.ErrorAs(t, res.Err, &lookupErr)
+		assert.Equal(t, "no key", res.Status.String())
+	})
+	t.Run("policy", func(t *testing.T) {
+		res := VerifyDomainKeys([]byte(emailBase), lookup)
+		assert.Equal(t, &DomainKeysPolicy{SignsAll: true, ReportAddress: "postmaster@tmail.io"}, res.Policy)
+	})
+}
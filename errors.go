@@ -24,7 +24,50 @@ var (
 	ErrCandNotParsePrivateKey = errors.New("can not parse private key, check format (pem) and validity")
 
 	// ErrSignBadAlgo Bad algorithm
-	ErrSignBadAlgo = errors.New("bad algorithm. Only rsa-sha1 or rsa-sha256 are permitted")
+	ErrSignBadAlgo = errors.New("bad algorithm. Only rsa-sha1, rsa-sha256 or ed25519-sha256 are permitted")
+
+	// ErrSignKeyTypeMismatch when the private key type doesn't match the algorithm
+	ErrSignKeyTypeMismatch = errors.New("private key type doesn't match algorithm")
+
+	// ErrSignKeyMismatch when the published key record doesn't match the signing options
+	ErrSignKeyMismatch = errors.New("published key record doesn't match signing key")
+
+	// ErrRotationNoKey when no key of a Rotation signs at the requested time
+	ErrRotationNoKey = errors.New("no signing key for this time in rotation")
+
+	// ErrRotationSelectorExists when a key is added to a Rotation with a selector already in use
+	ErrRotationSelectorExists = errors.New("selector already used in rotation")
+
+	// ErrLossyHeader when a header type that loses the case and folding of
+	// fields (textproto.MIMEHeader, mail.Header) is used with simple header canonicalization
+	ErrLossyHeader = errors.New("header type loses field case and folding, simple header canonicalization can't be used, use RawHeader")
+
+	// ErrReportRecordSyntax when a reporting record (RFC 6651) is not valid
+	ErrReportRecordSyntax = errors.New("report record syntax error")
+
+	// ErrReportRecordNoAddress when a reporting record has no ra= tag
+	ErrReportRecordNoAddress = errors.New("report record has no ra= tag")
+
+	// ErrReportNotFailed when a failure report is requested for a signature which didn't fail
+	ErrReportNotFailed = errors.New("signature didn't fail, nothing to report")
+
+	// ErrReportAddressRequired when the From or To address of a failure report is missing
+	ErrReportAddressRequired = errors.New("report From and To addresses are required")
+
+	// ErrDomainKeysBadFormat when a DomainKey-Signature header is not valid
+	ErrDomainKeysBadFormat = errors.New("DomainKey-Signature header bad format")
+
+	// ErrDomainKeysNoSender when a DomainKeys signed message has no valid Sender or From
+	ErrDomainKeysNoSender = errors.New("no valid Sender or From header field")
+
+	// ErrDomainKeysDomainMismatch when the d= domain of a DomainKey-Signature is not the sending domain or a parent
+	ErrDomainKeysDomainMismatch = errors.New("d= domain doesn't match the sending domain")
+
+	// ErrDomainKeysGranularity when the g= tag of a DomainKeys key doesn't match the sender
+	ErrDomainKeysGranularity = errors.New("key granularity (g=) doesn't match the sender")
+
+	// ErrATPSBadHash when the ATPS hash (atpsh=) is not "none", "sha1" or "sha256"
+	ErrATPSBadHash = errors.New("ATPS hash must be none, sha1 or sha256")
 
 	// ErrBadMailFormat unable to parse mail
 	ErrBadMailFormat = errors.New("bad mail format")
@@ -35,6 +78,15 @@ var (
 	// ErrBadDKimTagLBodyTooShort bad l tag
 	ErrBadDKimTagLBodyTooShort = errors.New("bad tag l or bodyLength option. Body length < l value")
 
+	// ErrTagListSyntax when a tag list is malformed
+	ErrTagListSyntax = errors.New("tag list syntax error")
+
+	// ErrTagListDuplicateTag when a tag occurs more than once in a tag list
+	ErrTagListDuplicateTag = errors.New("tag list syntax error: duplicate tag")
+
+	// ErrQuotedPrintable when a dkim-quoted-printable value can't be decoded
+	ErrQuotedPrintable = errors.New("bad dkim-quoted-printable encoding")
+
 	// ErrDkimHeaderBadFormat when errors found in DKIM header
 	ErrDkimHeaderBadFormat = errors.New("bad DKIM header format")
 
@@ -62,10 +114,11 @@ var (
 	// ErrVerifyBodyHash when body hash doesn't verify
 	ErrVerifyBodyHash = errors.New("body hash did not verify")
 
-	// ErrVerifyNoKeyForSignature no key
+	// ErrVerifyNoKeyForSignature no key, wrapped by *KeyLookupError: use errors.Is
 	ErrVerifyNoKeyForSignature = errors.New("no key for verify")
 
-	// ErrVerifyKeyUnavailable when service (dns) is anavailable
+	// ErrVerifyKeyUnavailable when service (dns) is anavailable, wrapped by
+	// *KeyLookupError: use errors.Is
 	ErrVerifyKeyUnavailable = errors.New("key unavailable")
 
 	// ErrVerifyTagVMustBeTheFirst if present the v tag must be the firts in the record
@@ -74,9 +127,22 @@ var (
 	// ErrVerifyVersionMusBeDkim1 if présent flag v (version) must be DKIM1
 	ErrVerifyVersionMusBeDkim1 = errors.New("flag v must be set to DKIM1")
 
-	// ErrVerifyBadKeyType bad type for pub key (only rsa is accepted)
+	// ErrVerifyBadKeyType bad type for pub key (only rsa and ed25519 are accepted)
+	// or key type doesn't match the signature algorithm
 	ErrVerifyBadKeyType = errors.New("bad type for key type")
 
+	// ErrVerifyEd25519Signature when an ed25519 signature doesn't verify
+	ErrVerifyEd25519Signature = errors.New("ed25519: verification error")
+
+	// ErrVerifyKeySyntax when the pub key record is not a valid tag list
+	ErrVerifyKeySyntax = errors.New("pub key syntax error")
+
+	// ErrVerifyDuplicateTag when a tag occurs more than once in the pub key record
+	ErrVerifyDuplicateTag = errors.New("pub key syntax error: duplicate tag")
+
+	// ErrVerifyMultipleKeyRecords when several distinct key records are published for a selector
+	ErrVerifyMultipleKeyRecords = errors.New("multiple key records found in DNS TXT")
+
 	// ErrVerifyRevokedKey key(s) for this selector is revoked (p is empty)
 	ErrVerifyRevokedKey = errors.New("revoked key")
 
@@ -89,6 +155,18 @@ var (
 	// ErrVerifySignatureHasExpired when signature has expired
 	ErrVerifySignatureHasExpired = errors.New("signature has expired")
 
+	// ErrVerifyKeyServiceType when the s tag of the pub key doesn't include email or *
+	ErrVerifyKeyServiceType = errors.New("key is not usable for email (s tag)")
+
+	// ErrVerifyIMustBeD when the pub key has the s flag (t=s) and the domain of the i tag differs from d tag
+	ErrVerifyIMustBeD = errors.New("i tag domain must be the same as d tag (key flag t=s)")
+
+	// ErrVerifyUnsignedBodyContent when too much content follows the part of the body covered by the l tag
+	ErrVerifyUnsignedBodyContent = errors.New("unsigned content appended to body (l tag)")
+
+	// ErrVerifyUnsignedMIMEPart when a MIME part follows the part of the body covered by the l tag
+	ErrVerifyUnsignedMIMEPart = errors.New("unsigned MIME part appended to body (l tag)")
+
 	// ErrVerifyInappropriateHashAlgo when h tag in pub key doesn't contain hash algo from a tag of DKIM header
 	ErrVerifyInappropriateHashAlgo = errors.New("inappropriate has algorithm")
 )
@@ -0,0 +1,146 @@
+package dkim
+
+import (
+	"errors"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// KeyCache caches key records retrieved from DNS, so that messages signed
+// with the same key only trigger one lookup (see VerifyOptKeyCache).
+//
+// Temporary lookup failures are not cached. Concurrent lookups of the same
+// record wait for the first one. Expired records are removed as new ones
+// are added. A KeyCache is safe for concurrent use.
+type KeyCache struct {
+	// TTL is the time records (and permanent failures) are cached
+	TTL time.Duration
+
+	// MaxEntries is the maximum number of cached records (0: no limit).
+	// When it is reached, arbitrary records are removed.
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]*keyCacheEntry
+	sweepAt int
+	hits    atomic.Uint64
+	misses  atomic.Uint64
+}
+
+// minKeyCacheSweep is the number of entries from which expired entries are
+// removed
+const minKeyCacheSweep = 64
+
+type keyCacheEntry struct {
+	ready   chan struct{}
+	expires time.Time
+	pubKey  *PubKeyRep
+	status  verifyOutput
+	err     error
+}
+
+// NewKeyCache returns an empty KeyCache keeping records for ttl
+func NewKeyCache(ttl time.Duration) *KeyCache {
+	return &KeyCache{TTL: ttl, entries: map[string]*keyCacheEntry{}}
+}
+
+// Stats returns the number of lookups answered from the cache (hits) and
+// from DNS (misses)
+func (c *KeyCache) Stats() (hits, misses uint64) {
+	return c.hits.Load(), c.misses.Load()
+}
+
+// lookup returns the cached key record of name, calling fetch if it's not
+// cached or expired. cached is false if fetch was called.
+func (c *KeyCache) lookup(name string, fetch func() (*PubKeyRep, verifyOutput, error)) (pubKey *PubKeyRep, status verifyOutput, cached bool, err error) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	now := time.Now()
+
+	c.mu.Lock()
+	e, ok := c.entries[name]
+	if ok {
+		select {
+		case <-e.ready:
+			ok = now.Before(e.expires)
+		default:
+			// lookup in progress
+		}
+	}
+	if ok {
+		c.mu.Unlock()
+		c.hits.Add(1)
+		<-e.ready
+		return e.pubKey, e.status, true, e.err
+	}
+	c.evict(now)
+	e = &keyCacheEntry{ready: make(chan struct{})}
+	c.entries[name] = e
+	c.mu.Unlock()
+
+	c.misses.Add(1)
+	fetched := false
+	defer func() {
+		if !fetched {
+			// fetch panicked, the lookups waiting get a temporary failure
+			e.status, e.err = TEMPFAIL, ErrVerifyKeyUnavailable
+		}
+		close(e.ready)
+	}()
+	e.pubKey, e.status, e.err = fetch()
+	fetched = true
+	e.expires = time.Now().Add(c.TTL)
+	var lookupErr *KeyLookupError
+	if errors.As(e.err, &lookupErr) && lookupErr.Reason.Status() == TEMPFAIL {
+		// only this lookup and the ones waiting get the failure
+		e.expires = time.Time{}
+	}
+	return e.pubKey, e.status, false, e.err
+}
+
+// evict removes expired entries, once the number of entries doubled since
+// the last time, and arbitrary entries to make room for a new one if
+// MaxEntries is reached. c.mu must be held.
+func (c *KeyCache) evict(now time.Time) {
+	if len(c.entries) >= c.sweepAt {
+		for name, e := range c.entries {
+			if isReady(e) && !now.Before(e.expires) {
+				delete(c.entries, name)
+			}
+		}
+		c.sweepAt = 2 * len(c.entries)
+		if c.sweepAt < minKeyCacheSweep {
+			c.sweepAt = minKeyCacheSweep
+		}
+	}
+	if c.MaxEntries <= 0 {
+		return
+	}
+	for name, e := range c.entries {
+		if len(c.entries) < c.MaxEntries {
+			return
+		}
+		if isReady(e) {
+			delete(c.entries, name)
+		}
+	}
+}
+
+// isReady returns true if the lookup of e is done
+func isReady(e *keyCacheEntry) bool {
+	select {
+	case <-e.ready:
+		return true
+	default:
+		return false
+	}
+}
+
+// VerifyOptKeyCache makes Verify look up keys in cache before DNS. Keys
+// found in a KeyStore (see VerifyOptKeyStore) are not cached.
+func VerifyOptKeyCache(cache *KeyCache) VerifyOpt {
+	return verifyOpt(func(opts *VerifyOptions) {
+		opts.keyCache = cache
+	})
+}
@@ -0,0 +1,136 @@
+package dkim
+
+import (
+	"net"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyCache(t *testing.T) {
+	var lookups atomic.Int32
+	fail := atomic.Bool{}
+	release := make(chan struct{})
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		lookups.Add(1)
+		<-release
+		if fail.Load() {
+			return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+		}
+		if name == "missing._domainkey."+domain {
+			return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+		}
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+	email := []byte(signedRelaxedRelaxed)
+	cache := NewKeyCache(time.Hour)
+
+	// concurrent verifications wait for the same lookup
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := VerifyWithResult(&email, resolveTXT, VerifyOptKeyCache(cache))
+			assert.Equal(t, SUCCESS, res.Status)
+			assert.Equal(t, KeySourceDNS, res.KeySource)
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	assert.Equal(t, int32(1), lookups.Load())
+	hits, misses := cache.Stats()
+	assert.Equal(t, uint64(4), hits)
+	assert.Equal(t, uint64(1), misses)
+
+	// permanent failures are cached, not temporary ones
+	lookup := func(selector string) error {
+		_, _, _, err := cache.lookup(selector+"._domainkey."+domain, func() (*PubKeyRep, verifyOutput, error) {
+			return NewPubKeyRespFromDNS(selector, domain, resolveTXT)
+		})
+		return err
+	}
+	lookups.Store(0)
+	assert.ErrorIs(t, lookup("missing"), ErrVerifyNoKeyForSignature)
+	assert.ErrorIs(t, lookup("missing"), ErrVerifyNoKeyForSignature)
+	assert.Equal(t, int32(1), lookups.Load())
+
+	fail.Store(true)
+	assert.ErrorIs(t, lookup("other"), ErrVerifyKeyUnavailable)
+	fail.Store(false)
+	assert.NoError(t, lookup("other"))
+	assert.Equal(t, int32(3), lookups.Load())
+
+	// expired
+	cache = NewKeyCache(0)
+	require.NoError(t, lookup("other"))
+	lookups.Store(0)
+	require.NoError(t, lookup("other"))
+	assert.Equal(t, int32(1), lookups.Load())
+}
+
+func TestKeyCache_Evict(t *testing.T) {
+	fetch := func() (*PubKeyRep, verifyOutput, error) {
+		return &PubKeyRep{}, SUCCESS, nil
+	}
+	name := func(i int) string { return "s" + strconv.Itoa(i) + "._domainkey." + domain }
+
+	// expired records are removed
+	cache := NewKeyCache(0)
+	for i := 0; i < 10*minKeyCacheSweep; i++ {
+		cache.lookup(name(i), fetch)
+	}
+	assert.LessOrEqual(t, len(cache.entries), minKeyCacheSweep)
+
+	// records are kept until they expire
+	cache = NewKeyCache(time.Hour)
+	for i := 0; i < 2*minKeyCacheSweep; i++ {
+		cache.lookup(name(i), fetch)
+	}
+	assert.Len(t, cache.entries, 2*minKeyCacheSweep)
+
+	cache.MaxEntries = 10
+	cache.lookup(name(-1), fetch)
+	assert.Len(t, cache.entries, 10)
+	_, _, cached, _ := cache.lookup(name(-1), fetch)
+	assert.True(t, cached)
+}
+
+func TestKeyCache_FetchPanic(t *testing.T) {
+	cache := NewKeyCache(time.Hour)
+	release := make(chan struct{})
+	assert.Panics(t, func() {
+		cache.lookup("test._domainkey."+domain, func() (*PubKeyRep, verifyOutput, error) {
+			// a concurrent lookup waits for this one
+			go func() {
+				defer close(release)
+				_, status, cached, err := cache.lookup("test._domainkey."+domain, nil)
+				assert.True(t, cached)
+				assert.Equal(t, TEMPFAIL, status)
+				assert.Equal(t, ErrVerifyKeyUnavailable, err)
+			}()
+			for {
+				if hits, _ := cache.Stats(); hits == 1 {
+					break
+				}
+				time.Sleep(time.Millisecond)
+			}
+			panic("fetch")
+		})
+	})
+	<-release
+
+	// the failure isn't cached
+	_, status, cached, err := cache.lookup("test._domainkey."+domain, func() (*PubKeyRep, verifyOutput, error) {
+		return &PubKeyRep{}, SUCCESS, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, SUCCESS, status)
+	assert.False(t, cached)
+}
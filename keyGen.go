@@ -0,0 +1,63 @@
+package dkim
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"strings"
+)
+
+// RSAKeyBits is the size of RSA keys generated by GenerateKey
+// (RFC 8301 requires at least 1024 bits, 2048 is recommended)
+const RSAKeyBits = 2048
+
+// GenerateKey generates a private key for algo ("rsa-sha1", "rsa-sha256" or
+// "ed25519-sha256") and returns it PEM encoded, as expected by SigOptions.
+// RSA keys are PKCS1 encoded, Ed25519 keys PKCS8 encoded.
+func GenerateKey(algo string) ([]byte, error) {
+	if !isValidAlgo(algo) {
+		return nil, ErrSignBadAlgo
+	}
+	if strings.HasPrefix(algo, "ed25519") {
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, err
+		}
+		return pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), nil
+	}
+	key, err := rsa.GenerateKey(rand.Reader, RSAKeyBits)
+	if err != nil {
+		return nil, err
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), nil
+}
+
+// PublicKeyRecord returns the key record (DNS TXT) to publish for a PEM
+// encoded private key
+func PublicKeyRecord(privateKey []byte) (string, error) {
+	key, err := parsePrivateKey(privateKey)
+	if err != nil {
+		return "", err
+	}
+	var p []byte
+	switch pub := key.Public().(type) {
+	case ed25519.PublicKey:
+		// RFC 8463: the raw key
+		p = pub
+	default:
+		if p, err = x509.MarshalPKIXPublicKey(pub); err != nil {
+			return "", err
+		}
+	}
+	return "v=DKIM1; k=" + keyType(key.Public()) + "; p=" + base64.StdEncoding.EncodeToString(p), nil
+}
+
+// RevokedKeyRecord is the key record of a revoked key (RFC 6376 section 3.6.1)
+const RevokedKeyRecord = "v=DKIM1; p="
@@ -0,0 +1,47 @@
+package dkim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGenerateKey(t *testing.T) {
+	t.Parallel()
+
+	for _, algo := range []string{"rsa-sha256", "ed25519-sha256"} {
+		algo := algo
+		t.Run(algo, func(t *testing.T) {
+			key, err := GenerateKey(algo)
+			require.NoError(t, err)
+			record, err := PublicKeyRecord(key)
+			require.NoError(t, err)
+
+			pkr, vo, err := NewPubKeyResp(record)
+			require.NoError(t, err)
+			assert.Equal(t, SUCCESS, vo)
+			assert.Equal(t, algo[:len(pkr.KeyType)], pkr.KeyType)
+
+			options := NewSigOptions()
+			options.PrivateKey = key
+			options.Algo = algo
+			options.Domain = domain
+			options.Selector = selector
+			email := []byte(emailBase)
+			require.NoError(t, Sign(&email, options))
+			status, err := Verify(&email, DNSOptLookupTXT(func(string) ([]string, error) {
+				return []string{record}, nil
+			}))
+			assert.NoError(t, err)
+			assert.Equal(t, SUCCESS, status)
+		})
+	}
+
+	_, err := GenerateKey("dsa-sha256")
+	assert.Equal(t, ErrSignBadAlgo, err)
+
+	record, err := PublicKeyRecord([]byte(privKey))
+	require.NoError(t, err)
+	assert.Equal(t, "v=DKIM1; k=rsa; p="+removeWS(pubKey), record)
+}
@@ -0,0 +1,181 @@
+package dkim
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+)
+
+// KeyLookupReason tells why a key record couldn't be retrieved from DNS
+type KeyLookupReason int
+
+const (
+	// KeyLookupNXDomain means the name doesn't exist (NXDOMAIN). PERMFAIL.
+	//
+	// The resolver of the net package also reports a name without any TXT
+	// record (NOERROR, no data) as not found: both cases are
+	// KeyLookupNXDomain unless the lookup function returns a *RcodeError.
+	KeyLookupNXDomain KeyLookupReason = iota + 1
+
+	// KeyLookupNoData means the name exists but has no TXT record. PERMFAIL.
+	KeyLookupNoData
+
+	// KeyLookupTimeout means the query timed out. TEMPFAIL.
+	KeyLookupTimeout
+
+	// KeyLookupServFail means the server answered SERVFAIL. TEMPFAIL.
+	KeyLookupServFail
+
+	// KeyLookupRefused means the server answered REFUSED. TEMPFAIL.
+	KeyLookupRefused
+
+	// KeyLookupTemporary is any other temporary error (network errors, ...). TEMPFAIL.
+	KeyLookupTemporary
+
+	// KeyLookupOther is any other error (unexpected rcode, malformed
+	// answer, ...). TEMPFAIL.
+	KeyLookupOther
+)
+
+// String returns the reason name
+func (r KeyLookupReason) String() string {
+	switch r {
+	case KeyLookupNXDomain:
+		return "nxdomain"
+	case KeyLookupNoData:
+		return "nodata"
+	case KeyLookupTimeout:
+		return "timeout"
+	case KeyLookupServFail:
+		return "servfail"
+	case KeyLookupRefused:
+		return "refused"
+	case KeyLookupTemporary:
+		return "temporary"
+	case KeyLookupOther:
+		return "other"
+	}
+	return "KeyLookupReason(" + strconv.Itoa(int(r)) + ")"
+}
+
+// Status returns the verification status for the reason (PERMFAIL or TEMPFAIL)
+func (r KeyLookupReason) Status() verifyOutput {
+	if r == KeyLookupNXDomain || r == KeyLookupNoData {
+		return PERMFAIL
+	}
+	return TEMPFAIL
+}
+
+// KeyLookupError is returned when the key record couldn't be retrieved from DNS.
+//
+// errors.Is(err, ErrVerifyNoKeyForSignature) is true for PERMFAIL reasons and
+// errors.Is(err, ErrVerifyKeyUnavailable) is true for TEMPFAIL reasons. It is
+// returned instead of these errors, comparing it with == doesn't match them.
+type KeyLookupError struct {
+	// Name is the queried name (selector._domainkey.domain)
+	Name string
+
+	Reason KeyLookupReason
+
+	// Err is the error returned by the lookup function (nil for
+	// KeyLookupNoData when no record was returned)
+	Err error
+}
+
+// Error implements error
+func (e *KeyLookupError) Error() string {
+	s := e.sentinel().Error() + ": " + e.Reason.String() + ": " + e.Name
+	if e.Err != nil {
+		s += ": " + e.Err.Error()
+	}
+	return s
+}
+
+// Unwrap returns the error of the lookup function
+func (e *KeyLookupError) Unwrap() error {
+	return e.Err
+}
+
+// Is matches ErrVerifyNoKeyForSignature or ErrVerifyKeyUnavailable according to the reason
+func (e *KeyLookupError) Is(target error) bool {
+	return target == e.sentinel()
+}
+
+// sentinel returns the legacy error of the reason
+func (e *KeyLookupError) sentinel() error {
+	if e.Reason.Status() == PERMFAIL {
+		return ErrVerifyNoKeyForSignature
+	}
+	return ErrVerifyKeyUnavailable
+}
+
+// DNS response codes (RFC 1035, RFC 6895)
+const (
+	RcodeNoError  = 0
+	RcodeFormErr  = 1
+	RcodeServFail = 2
+	RcodeNXDomain = 3
+	RcodeNotImp   = 4
+	RcodeRefused  = 5
+)
+
+// RcodeError can be returned by a lookup function (DNSOptLookupTXT,
+// DNSOptLookupTXTStrings) using its own resolver, to report the DNS response
+// code of a failed query. RcodeNoError means the name exists but has no TXT
+// record.
+type RcodeError struct {
+	Name  string
+	Rcode int
+}
+
+// Error implements error
+func (e *RcodeError) Error() string {
+	return "lookup " + e.Name + ": rcode " + strconv.Itoa(e.Rcode)
+}
+
+// newKeyLookupError classifies the error returned by a lookup function
+func newKeyLookupError(name string, err error) *KeyLookupError {
+	e := &KeyLookupError{Name: name, Err: err, Reason: KeyLookupOther}
+
+	var rcodeErr *RcodeError
+	var dnsErr *net.DNSError
+	var netErr net.Error
+	switch {
+	case errors.As(err, &rcodeErr):
+		switch rcodeErr.Rcode {
+		case RcodeNoError:
+			e.Reason = KeyLookupNoData
+		case RcodeNXDomain:
+			e.Reason = KeyLookupNXDomain
+		case RcodeServFail:
+			e.Reason = KeyLookupServFail
+		case RcodeRefused:
+			e.Reason = KeyLookupRefused
+		}
+	case errors.As(err, &dnsErr):
+		switch {
+		case dnsErr.IsNotFound:
+			e.Reason = KeyLookupNXDomain
+		case dnsErr.IsTimeout:
+			e.Reason = KeyLookupTimeout
+		case isServFail(dnsErr):
+			e.Reason = KeyLookupServFail
+		case dnsErr.IsTemporary:
+			e.Reason = KeyLookupTemporary
+		}
+	case errors.Is(err, context.DeadlineExceeded):
+		e.Reason = KeyLookupTimeout
+	case errors.As(err, &netErr) && netErr.Timeout():
+		e.Reason = KeyLookupTimeout
+	}
+	return e
+}
+
+// isServFail reports whether dnsErr is a SERVFAIL answer. The net package
+// doesn't expose the rcode: it reports SERVFAIL (and a few malformed answers)
+// as a temporary error with the message "server misbehaving". Lookup
+// functions returning a *RcodeError don't depend on this.
+func isServFail(dnsErr *net.DNSError) bool {
+	return dnsErr.IsTemporary && dnsErr.Err == "server misbehaving"
+}
@@ -0,0 +1,128 @@
+package dkim
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestKeyLookupError(t *testing.T) {
+	t.Parallel()
+
+	name := selector + "._domainkey." + domain
+
+	testCases := []struct {
+		Name         string
+		Err          error
+		Reason       KeyLookupReason
+		VerifyOutput verifyOutput
+	}{
+		{
+			Name:         "net NXDOMAIN",
+			Err:          &net.DNSError{Err: "no such host", Name: name, IsNotFound: true},
+			Reason:       KeyLookupNXDomain,
+			VerifyOutput: PERMFAIL,
+		},
+		{
+			Name:         "net timeout",
+			Err:          &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true, IsTemporary: true},
+			Reason:       KeyLookupTimeout,
+			VerifyOutput: TEMPFAIL,
+		},
+		{
+			Name:         "net SERVFAIL",
+			Err:          &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true},
+			Reason:       KeyLookupServFail,
+			VerifyOutput: TEMPFAIL,
+		},
+		{
+			Name:         "net temporary",
+			Err:          &net.DNSError{Err: "connection refused", Name: name, IsTemporary: true},
+			Reason:       KeyLookupTemporary,
+			VerifyOutput: TEMPFAIL,
+		},
+		{
+			Name:         "net other",
+			Err:          &net.DNSError{Err: "cannot unmarshal DNS message", Name: name},
+			Reason:       KeyLookupOther,
+			VerifyOutput: TEMPFAIL,
+		},
+		{
+			Name:         "rcode NOERROR",
+			Err:          &RcodeError{Name: name, Rcode: RcodeNoError},
+			Reason:       KeyLookupNoData,
+			VerifyOutput: PERMFAIL,
+		},
+		{
+			Name:         "rcode NXDOMAIN",
+			Err:          &RcodeError{Name: name, Rcode: RcodeNXDomain},
+			Reason:       KeyLookupNXDomain,
+			VerifyOutput: PERMFAIL,
+		},
+		{
+			Name:         "rcode SERVFAIL (wrapped)",
+			Err:          fmt.Errorf("resolver: %w", &RcodeError{Name: name, Rcode: RcodeServFail}),
+			Reason:       KeyLookupServFail,
+			VerifyOutput: TEMPFAIL,
+		},
+		{
+			Name:         "rcode REFUSED",
+			Err:          &RcodeError{Name: name, Rcode: RcodeRefused},
+			Reason:       KeyLookupRefused,
+			VerifyOutput: TEMPFAIL,
+		},
+		{
+			Name:         "rcode NOTIMP",
+			Err:          &RcodeError{Name: name, Rcode: RcodeNotImp},
+			Reason:       KeyLookupOther,
+			VerifyOutput: TEMPFAIL,
+		},
+		{
+			Name:         "context deadline",
+			Err:          context.DeadlineExceeded,
+			Reason:       KeyLookupTimeout,
+			VerifyOutput: TEMPFAIL,
+		},
+		{
+			Name:         "net timeout error",
+			Err:          &net.OpError{Op: "read", Net: "udp", Err: os.ErrDeadlineExceeded},
+			Reason:       KeyLookupTimeout,
+			VerifyOutput: TEMPFAIL,
+		},
+		{
+			Name:         "error ending with no such host",
+			Err:          errors.New("lookup test._domainkey.tmail.io: no such host"),
+			Reason:       KeyLookupOther,
+			VerifyOutput: TEMPFAIL,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			_, vo, err := NewPubKeyRespFromDNS(selector, domain, DNSOptLookupTXT(func(string) ([]string, error) {
+				return nil, tc.Err
+			}))
+			assert.Equal(t, tc.VerifyOutput, vo)
+
+			var lookupErr *KeyLookupError
+			if assert.True(t, errors.As(err, &lookupErr)) {
+				assert.Equal(t, name, lookupErr.Name)
+				assert.Equal(t, tc.Reason, lookupErr.Reason)
+			}
+			assert.ErrorIs(t, err, tc.Err)
+			if vo == PERMFAIL {
+				assert.ErrorIs(t, err, ErrVerifyNoKeyForSignature)
+				assert.NotErrorIs(t, err, ErrVerifyKeyUnavailable)
+			} else {
+				assert.ErrorIs(t, err, ErrVerifyKeyUnavailable)
+				assert.NotErrorIs(t, err, ErrVerifyNoKeyForSignature)
+			}
+		})
+	}
+}
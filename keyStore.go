@@ -0,0 +1,179 @@
+package dkim
+
+import (
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeySource tells where the key used for verification comes from
+type KeySource int
+
+const (
+	// KeySourceNone means no key was retrieved
+	KeySourceNone KeySource = iota
+	// KeySourceDNS means the key record was retrieved from DNS
+	KeySourceDNS
+	// KeySourceLocal means the key record was found in a KeyStore
+	KeySourceLocal
+)
+
+// String returns the key source name
+func (s KeySource) String() string {
+	switch s {
+	case KeySourceDNS:
+		return "dns"
+	case KeySourceLocal:
+		return "local"
+	}
+	return "none"
+}
+
+// StoredKey is a key record of a KeyStore
+type StoredKey struct {
+	// Record is the key record, as published in DNS
+	Record string `json:"record"`
+
+	// NotBefore and NotAfter bound the period the key was in use.
+	// Zero values mean no bound.
+	NotBefore time.Time `json:"not_before,omitempty"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
+}
+
+// validAt returns true if the key was in use at t
+func (k StoredKey) validAt(t time.Time) bool {
+	return (k.NotBefore.IsZero() || !t.Before(k.NotBefore)) && (k.NotAfter.IsZero() || !t.After(k.NotAfter))
+}
+
+// KeyStore is a local store of key records, used to verify messages whose
+// keys are not published in DNS anymore (see VerifyOptKeyStore).
+//
+// Records are stored by DNS name (selector._domainkey.domain).
+// A KeyStore is safe for concurrent use.
+type KeyStore struct {
+	mu   sync.RWMutex
+	keys map[string][]StoredKey
+}
+
+// NewKeyStore returns an empty KeyStore
+func NewKeyStore() *KeyStore {
+	return &KeyStore{keys: map[string][]StoredKey{}}
+}
+
+// Add adds a key record for name (selector._domainkey.domain)
+func (s *KeyStore) Add(name string, key StoredKey) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	s.keys[name] = append(s.keys[name], key)
+}
+
+// Lookup returns the records of name which were in use at t
+func (s *KeyStore) Lookup(name string, t time.Time) []string {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	var records []string
+	for _, k := range s.keys[strings.ToLower(strings.TrimSuffix(name, "."))] {
+		if k.validAt(t) {
+			records = append(records, k.Record)
+		}
+	}
+	return records
+}
+
+// LoadKeyStoreJSON reads a KeyStore from JSON:
+//
+//	{
+//	  "selector._domainkey.example.com": [
+//	    {"record": "v=DKIM1; p=...", "not_before": "2020-01-01T00:00:00Z", "not_after": "2022-01-01T00:00:00Z"}
+//	  ]
+//	}
+func LoadKeyStoreJSON(r io.Reader) (*KeyStore, error) {
+	keys := map[string][]StoredKey{}
+	if err := json.NewDecoder(r).Decode(&keys); err != nil {
+		return nil, err
+	}
+	s := NewKeyStore()
+	for name, list := range keys {
+		for _, k := range list {
+			s.Add(name, k)
+		}
+	}
+	return s, nil
+}
+
+// LoadKeyStoreDir reads a KeyStore from a directory containing a file per
+// key record, named after the DNS name (selector._domainkey.domain), with an
+// optional ".txt" extension. Lines starting with ";" are comments, except
+// "; not-before: <RFC 3339 time>" and "; not-after: <RFC 3339 time>" which
+// set the validity period. Other lines are concatenated to form the record.
+func LoadKeyStoreDir(dir string) (*KeyStore, error) {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	s := NewKeyStore()
+	for _, e := range entries {
+		if e.IsDir() || strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		data, err := os.ReadFile(filepath.Join(dir, e.Name()))
+		if err != nil {
+			return nil, err
+		}
+		key, err := parseStoredKey(string(data))
+		if err != nil {
+			return nil, &os.PathError{Op: "parse", Path: filepath.Join(dir, e.Name()), Err: err}
+		}
+		s.Add(strings.TrimSuffix(e.Name(), ".txt"), key)
+	}
+	return s, nil
+}
+
+// parseStoredKey parses a key file of a KeyStore directory
+func parseStoredKey(data string) (StoredKey, error) {
+	var key StoredKey
+	var record strings.Builder
+	for _, line := range strings.Split(data, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, ";") {
+			record.WriteString(line)
+			continue
+		}
+		name, value, found := strings.Cut(strings.TrimPrefix(line, ";"), ":")
+		if !found {
+			continue
+		}
+		var t *time.Time
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "not-before":
+			t = &key.NotBefore
+		case "not-after":
+			t = &key.NotAfter
+		default:
+			continue
+		}
+		var err error
+		if *t, err = time.Parse(time.RFC3339, strings.TrimSpace(value)); err != nil {
+			return key, err
+		}
+	}
+	key.Record = record.String()
+	return key, nil
+}
+
+// VerifyOptKeyStore makes Verify look up keys in store before DNS.
+//
+// A stored key is used if it was in use at the signature timestamp (t= tag),
+// or at the time of verification if the signature has no timestamp.
+// If the store has no such key, the key is retrieved from DNS.
+// VerifyResult.KeySource tells where the key comes from.
+func VerifyOptKeyStore(store *KeyStore) VerifyOpt {
+	return verifyOpt(func(opts *VerifyOptions) {
+		opts.keyStore = store
+	})
+}
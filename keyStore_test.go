@@ -0,0 +1,112 @@
+package dkim
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestKeyStore(t *testing.T) {
+	t.Parallel()
+
+	name := selector + "._domainkey." + domain
+	store := NewKeyStore()
+	store.Add(name, StoredKey{Record: "old", NotAfter: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)})
+	store.Add(strings.ToUpper(name)+".", StoredKey{Record: "new", NotBefore: time.Date(2019, 1, 1, 0, 0, 0, 0, time.UTC)})
+	store.Add(name, StoredKey{Record: "always"})
+
+	assert.Equal(t, []string{"old", "always"}, store.Lookup(name, time.Date(2018, 6, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, []string{"old", "new", "always"}, store.Lookup(name, time.Date(2019, 6, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, []string{"new", "always"}, store.Lookup(name, time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Nil(t, store.Lookup("other._domainkey."+domain, time.Now()))
+}
+
+func TestLoadKeyStoreJSON(t *testing.T) {
+	t.Parallel()
+
+	store, err := LoadKeyStoreJSON(strings.NewReader(`{
+		"test._domainkey.tmail.io": [
+			{"record": "v=DKIM1; p=abc", "not_before": "2015-01-01T00:00:00Z", "not_after": "2016-01-01T00:00:00Z"}
+		]
+	}`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v=DKIM1; p=abc"}, store.Lookup("test._domainkey.tmail.io", time.Date(2015, 5, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Nil(t, store.Lookup("test._domainkey.tmail.io", time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)))
+
+	_, err = LoadKeyStoreJSON(strings.NewReader(`{"test._domainkey.tmail.io": "v=DKIM1; p=abc"}`))
+	assert.Error(t, err)
+}
+
+func TestLoadKeyStoreDir(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "test._domainkey.tmail.io.txt"), []byte(
+		"; rotated out on 2016-01-01\n"+
+			"; not-before: 2015-01-01T00:00:00Z\n"+
+			"; not-after: 2016-01-01T00:00:00Z\n"+
+			"v=DKIM1;\n"+
+			"p=abc\n"), 0o644))
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "other._domainkey.tmail.io"), []byte("v=DKIM1; p=def"), 0o644))
+
+	store, err := LoadKeyStoreDir(dir)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"v=DKIM1;p=abc"}, store.Lookup("test._domainkey.tmail.io", time.Date(2015, 5, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Nil(t, store.Lookup("test._domainkey.tmail.io", time.Date(2016, 5, 1, 0, 0, 0, 0, time.UTC)))
+	assert.Equal(t, []string{"v=DKIM1; p=def"}, store.Lookup("other._domainkey.tmail.io", time.Now()))
+
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "bad._domainkey.tmail.io"), []byte("; not-after: yesterday\np=abc"), 0o644))
+	_, err = LoadKeyStoreDir(dir)
+	assert.Error(t, err)
+}
+
+func Test_VerifyKeyStore(t *testing.T) {
+	t.Parallel()
+
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	email := []byte(emailBase)
+	require.NoError(t, Sign(&email, options))
+
+	name := selector + "._domainkey." + domain
+	dnsLookups := 0
+	noDNS := DNSOptLookupTXT(func(name string) ([]string, error) {
+		dnsLookups++
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	})
+	withDNS := DNSOptLookupTXT(func(name string) ([]string, error) {
+		dnsLookups++
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+
+	// key in store
+	store := NewKeyStore()
+	store.Add(name, StoredKey{Record: "v=DKIM1; p=" + pubKey, NotBefore: time.Now().Add(-time.Hour)})
+	res := VerifyWithResult(&email, noDNS, VerifyOptKeyStore(store))
+	assert.Equal(t, SUCCESS, res.Status)
+	assert.NoError(t, res.Err)
+	assert.Equal(t, KeySourceLocal, res.KeySource)
+	assert.Equal(t, 0, dnsLookups)
+
+	// key not valid at signature time, DNS fallback
+	store = NewKeyStore()
+	store.Add(name, StoredKey{Record: "v=DKIM1; p=" + pubKey, NotAfter: time.Now().Add(-time.Hour)})
+	res = VerifyWithResult(&email, withDNS, VerifyOptKeyStore(store))
+	assert.Equal(t, SUCCESS, res.Status)
+	assert.Equal(t, KeySourceDNS, res.KeySource)
+	assert.Equal(t, 1, dnsLookups)
+
+	// no key anywhere
+	res = VerifyWithResult(&email, noDNS, VerifyOptKeyStore(store))
+	assert.Equal(t, PERMFAIL, res.Status)
+	assert.ErrorIs(t, res.Err, ErrVerifyNoKeyForSignature)
+	assert.Equal(t, KeySourceNone, res.KeySource)
+}
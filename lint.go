@@ -0,0 +1,283 @@
+package dkim
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/base64"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// LintSeverity is the severity of a LintFinding
+type LintSeverity int
+
+const (
+	// LintWarning is a bad practice, the key record is usable
+	LintWarning LintSeverity = iota + 1
+	// LintError makes the key record unusable or signatures fail
+	LintError
+)
+
+// String returns the severity name
+func (s LintSeverity) String() string {
+	switch s {
+	case LintWarning:
+		return "warning"
+	case LintError:
+		return "error"
+	}
+	return "LintSeverity(" + strconv.Itoa(int(s)) + ")"
+}
+
+// LintFinding is a problem found in a key record
+type LintFinding struct {
+	Severity LintSeverity
+
+	// Code identifies the rule (eg "key-too-short")
+	Code string
+
+	Message string
+}
+
+// String returns the finding as "severity: code: message"
+func (f LintFinding) String() string {
+	return f.Severity.String() + ": " + f.Code + ": " + f.Message
+}
+
+// LintOpt represents an optional setting for linting key records
+type LintOpt func(*lintOptions)
+
+type lintOptions struct {
+	privateKey []byte
+	dnsOpts    []DNSOpt
+}
+
+// LintOptPrivateKey checks that the key record matches the PEM encoded
+// private key
+func LintOptPrivateKey(privateKey []byte) LintOpt {
+	return func(opts *lintOptions) {
+		opts.privateKey = privateKey
+	}
+}
+
+// LintOptDNS sets the DNS options used by LintDNSKeyRecord. The length of
+// TXT strings is only checked with DNSOptLookupTXTStrings.
+func LintOptDNS(opts ...DNSOpt) LintOpt {
+	return func(lintOpts *lintOptions) {
+		lintOpts.dnsOpts = append(lintOpts.dnsOpts, opts...)
+	}
+}
+
+// maxTXTStringLength is the maximum length of a DNS character-string
+const maxTXTStringLength = 255
+
+// LintDNSKeyRecord fetches the key record of selector and domain and lints it.
+// An error is only returned if the record couldn't be retrieved.
+func LintDNSKeyRecord(selector, domain string, opts ...LintOpt) ([]LintFinding, error) {
+	lintOpts := lintOptions{}
+	for _, opt := range opts {
+		opt(&lintOpts)
+	}
+	dnsOpts := DNSOptions{netLookupTXT: net.LookupTXT}
+	for _, opt := range lintOpts.dnsOpts {
+		opt.apply(&dnsOpts)
+	}
+
+	name := selector + "._domainkey." + domain
+	records, err := dnsOpts.lookupTXTStrings(name)
+	if err != nil {
+		return nil, newKeyLookupError(name, err)
+	}
+	if len(records) == 0 {
+		return nil, &KeyLookupError{Name: name, Reason: KeyLookupNoData}
+	}
+
+	var findings []LintFinding
+	if len(records) > 1 {
+		findings = append(findings, LintFinding{LintError, "multiple-records",
+			strconv.Itoa(len(records)) + " TXT records published for " + name + ", verifiers may pick any of them"})
+	}
+	for _, strs := range records {
+		findings = append(findings, LintKeyRecordStrings(strs, opts...)...)
+	}
+	return findings, nil
+}
+
+// LintKeyRecordStrings lints a key record given as the character-strings
+// of its TXT record
+func LintKeyRecordStrings(strs []string, opts ...LintOpt) []LintFinding {
+	var findings []LintFinding
+	for i, s := range strs {
+		if len(s) > maxTXTStringLength {
+			findings = append(findings, LintFinding{LintError, "txt-string-too-long",
+				"TXT string " + strconv.Itoa(i+1) + " is " + strconv.Itoa(len(s)) + " octets long, the maximum is 255"})
+		}
+	}
+	return append(findings, LintKeyRecord(strings.Join(strs, ""), opts...)...)
+}
+
+// LintKeyRecord checks a key record (RFC 6376 section 3.6.1) and returns
+// all the problems found. Unlike NewPubKeyResp it doesn't stop on the
+// first error.
+func LintKeyRecord(record string, opts ...LintOpt) []LintFinding {
+	lintOpts := lintOptions{}
+	for _, opt := range opts {
+		opt(&lintOpts)
+	}
+
+	var findings []LintFinding
+	add := func(severity LintSeverity, code, message string) {
+		findings = append(findings, LintFinding{severity, code, message})
+	}
+
+	tags, err := ParseTagList(record)
+	if err != nil {
+		if err == ErrTagListDuplicateTag {
+			add(LintError, "duplicate-tag", "a tag appears more than once")
+		} else {
+			add(LintError, "syntax", "record is not a valid tag list")
+		}
+		return findings
+	}
+
+	if _, ok := tags.Get("v"); !ok {
+		add(LintWarning, "version-missing", "v=DKIM1 is recommended as first tag")
+	}
+	report := false
+	for i, tag := range tags {
+		val := removeFWS(tag.Value)
+		switch strings.ToLower(tag.Name) {
+		case "v":
+			if i != 0 {
+				add(LintError, "version-not-first", "v= must be the first tag")
+			}
+			if val != "DKIM1" {
+				add(LintError, "version-invalid", "v= must be DKIM1, not "+strconv.Quote(val))
+			}
+		case "h":
+			var supported, sha256 bool
+			for _, h := range strings.Split(strings.ToLower(val), ":") {
+				switch strings.TrimSpace(h) {
+				case "sha256":
+					sha256 = true
+					supported = true
+				case "sha1":
+					supported = true
+				}
+			}
+			switch {
+			case !supported:
+				add(LintWarning, "hash-unknown", "h= has no supported hash algorithm, it is ignored")
+			case !sha256:
+				add(LintWarning, "hash-sha1-only", "h= only allows sha1 which is deprecated (RFC 8301)")
+			}
+		case "k":
+			if k := strings.ToLower(val); k != "rsa" && k != "ed25519" {
+				add(LintError, "key-type-unknown", "k= "+strconv.Quote(val)+" is not supported")
+			}
+		case "p":
+			p := strings.Join(strings.Fields(val), "")
+			if p == "" {
+				add(LintError, "key-revoked", "p= is empty, the key is revoked")
+			} else if _, err := base64.StdEncoding.DecodeString(p); err != nil {
+				add(LintError, "key-invalid", "p= is not valid base64")
+			}
+		case "s":
+			email := false
+			for _, s := range strings.Split(strings.ToLower(val), ":") {
+				if s = strings.TrimSpace(s); s == "*" || s == "email" {
+					email = true
+				}
+			}
+			if !email {
+				add(LintError, "service-not-email", "s= doesn't allow email, the key can't be used for DKIM")
+			}
+		case "t":
+			for _, flag := range strings.Split(strings.ToLower(val), ":") {
+				if strings.TrimSpace(flag) == "y" {
+					add(LintWarning, "testing", "t=y: the domain is testing DKIM, verifiers may ignore failures")
+				}
+			}
+		case "n":
+		case "ra", "rp", "rr", "rs":
+			report = true
+		default:
+			add(LintWarning, "unknown-tag", "tag "+strconv.Quote(tag.Name)+" is unknown")
+		}
+	}
+	// reporting tags (RFC 6651), checked as verifiers parse them
+	if report {
+		if _, err := parseReportTags(tags); err == ErrReportRecordNoAddress {
+			add(LintWarning, "report-no-address", "rp=, rr= or rs= without ra=, no failure reports are requested")
+		} else if err != nil {
+			add(LintError, "report-invalid", "ra=, rp= or rs= is invalid (RFC 6651), verifiers won't send failure reports")
+		}
+	}
+	if _, ok := tags.Get("p"); !ok {
+		add(LintError, "key-missing", "p= tag is missing")
+	}
+
+	// the key is decoded from p= and k= alone, so that it is checked whatever
+	// the other problems of the record
+	p, ok := tags.Get("p")
+	p = strings.Join(strings.Fields(p), "")
+	keyType := pkrKeyType(tags)
+	if !ok || p == "" || hasFinding(findings, "key-invalid") || (keyType != "rsa" && keyType != "ed25519") {
+		return findings
+	}
+	data, _ := base64.StdEncoding.DecodeString(p)
+	pkr := &PubKeyRep{KeyType: keyType}
+	if err := pkr.setKey(data); err != nil {
+		add(LintError, "key-invalid", "p= is not a valid "+keyType+" public key")
+		return findings
+	}
+
+	if pkr.KeyType == "rsa" {
+		switch bits := pkr.PubKey.N.BitLen(); {
+		case bits < 1024:
+			add(LintError, "key-too-short", "RSA key is "+strconv.Itoa(bits)+" bits, verifiers reject keys under 1024 bits (RFC 8301)")
+		case bits < 2048:
+			add(LintWarning, "key-short", "RSA key is "+strconv.Itoa(bits)+" bits, 2048 bits are recommended")
+		}
+	}
+
+	if lintOpts.privateKey != nil {
+		signer, err := parsePrivateKey(lintOpts.privateKey)
+		if err != nil {
+			add(LintError, "private-key-invalid", err.Error())
+		} else if !pkr.matches(signer.Public()) {
+			add(LintError, "private-key-mismatch", "p= doesn't match the private key")
+		}
+	}
+	return findings
+}
+
+// hasFinding returns true if findings contain code
+func hasFinding(findings []LintFinding, code string) bool {
+	for _, f := range findings {
+		if f.Code == code {
+			return true
+		}
+	}
+	return false
+}
+
+// pkrKeyType returns the key type of a key record
+func pkrKeyType(tags TagList) string {
+	if k, ok := tags.Get("k"); ok {
+		return strings.ToLower(removeFWS(k))
+	}
+	return "rsa"
+}
+
+// matches returns true if the key of the record is pub
+func (p *PubKeyRep) matches(pub interface{}) bool {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return p.KeyType == "rsa" && pub.Equal(&p.PubKey)
+	case ed25519.PublicKey:
+		return p.KeyType == "ed25519" && pub.Equal(p.Ed25519PubKey)
+	}
+	return false
+}
@@ -0,0 +1,220 @@
+package dkim
+
+import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// lintCodes returns the codes of findings
+func lintCodes(findings []LintFinding) []string {
+	codes := []string{}
+	for _, f := range findings {
+		codes = append(codes, f.Code)
+	}
+	return codes
+}
+
+func TestLintKeyRecord(t *testing.T) {
+	t.Parallel()
+
+	// 512 bits keys can't be generated anymore
+	n, _ := new(big.Int).SetString("c2bb8bcd4fe52a4be0b5a35e9b1a9ae82b53fd69c4ed5c5d0b1cf3d3c7d5a0de"+
+		"3a9a31cd5ba4a8a1c5c57fdc1e7fbd1a3c3a77c6f2d2b3c4a5b6c7d8e9fa0b1c3", 16)
+	der, err := x509.MarshalPKIXPublicKey(&rsa.PublicKey{N: n, E: 65537})
+	require.NoError(t, err)
+	shortKey := base64.StdEncoding.EncodeToString(der)
+
+	edKey := "11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo="
+	otherKey, err := GenerateKey("ed25519-sha256")
+	require.NoError(t, err)
+
+	testCases := []struct {
+		Name   string
+		Record string
+		Opts   []LintOpt
+		Codes  []string
+	}{
+		{
+			Name:   "ed25519",
+			Record: "v=DKIM1; k=ed25519; p=" + edKey,
+			Codes:  []string{},
+		},
+		{
+			Name:   "1024 bits",
+			Record: "v=DKIM1; k=rsa; p=" + pubKey,
+			Codes:  []string{"key-short"},
+		},
+		{
+			Name:   "512 bits",
+			Record: "v=DKIM1; p=" + shortKey,
+			Codes:  []string{"key-too-short"},
+		},
+		{
+			Name:   "1024 bits, version not first",
+			Record: "p=" + pubKey + "; v=DKIM1",
+			Codes:  []string{"version-not-first", "key-short"},
+		},
+		{
+			Name:   "512 bits, not for email",
+			Record: "s=tlsrpt; p=" + shortKey,
+			Codes:  []string{"version-missing", "service-not-email", "key-too-short"},
+		},
+		{
+			Name:   "private key mismatch, version not first",
+			Record: "p=" + pubKey + "; v=DKIM1",
+			Opts:   []LintOpt{LintOptPrivateKey(otherKey)},
+			Codes:  []string{"version-not-first", "key-short", "private-key-mismatch"},
+		},
+		{
+			Name:   "everything wrong",
+			Record: "k=ed25519; t=y:s; h=sha1; s=tlsrpt; x=1; v=DKIM2; p=" + edKey,
+			Codes:  []string{"testing", "hash-sha1-only", "service-not-email", "unknown-tag", "version-not-first", "version-invalid"},
+		},
+		{
+			Name:   "no version",
+			Record: "p=" + edKey + "; k=ed25519",
+			Codes:  []string{"version-missing"},
+		},
+		{
+			Name:   "unknown hash and key type",
+			Record: "v=DKIM1; h=sha512; k=dsa; p=" + edKey,
+			Codes:  []string{"hash-unknown", "key-type-unknown"},
+		},
+		{
+			Name:   "revoked",
+			Record: "v=DKIM1; p=",
+			Codes:  []string{"key-revoked"},
+		},
+		{
+			Name:   "no key",
+			Record: "v=DKIM1; t=y",
+			Codes:  []string{"testing", "key-missing"},
+		},
+		{
+			Name:   "bad base64",
+			Record: "v=DKIM1; p=abc",
+			Codes:  []string{"key-invalid"},
+		},
+		{
+			Name:   "ed25519 key without k tag",
+			Record: "v=DKIM1; p=" + edKey,
+			Codes:  []string{"key-invalid"},
+		},
+		{
+			Name:   "syntax",
+			Record: "v=DKIM1; p",
+			Codes:  []string{"syntax"},
+		},
+		{
+			Name:   "duplicate",
+			Record: "v=DKIM1; p=a; p=b",
+			Codes:  []string{"duplicate-tag"},
+		},
+		{
+			Name:   "matching private key",
+			Record: "v=DKIM1; p=" + pubKey,
+			Opts:   []LintOpt{LintOptPrivateKey([]byte(privKey))},
+			Codes:  []string{"key-short"},
+		},
+		{
+			Name:   "private key mismatch",
+			Record: "v=DKIM1; k=ed25519; p=" + edKey,
+			Opts:   []LintOpt{LintOptPrivateKey(otherKey)},
+			Codes:  []string{"private-key-mismatch"},
+		},
+		{
+			Name:   "private key of other type",
+			Record: "v=DKIM1; k=ed25519; p=" + edKey,
+			Opts:   []LintOpt{LintOptPrivateKey([]byte(privKey))},
+			Codes:  []string{"private-key-mismatch"},
+		},
+		{
+			Name:   "reporting tags",
+			Record: "v=DKIM1; k=ed25519; ra=dkim-reports; rp=10; rr=v:x; rs=Sorry=2C=20try=20later; p=" + edKey,
+			Codes:  []string{},
+		},
+		{
+			Name:   "report percentage out of range",
+			Record: "v=DKIM1; k=ed25519; ra=dkim-reports; rp=200; p=" + edKey,
+			Codes:  []string{"report-invalid"},
+		},
+		{
+			Name:   "report address with control characters",
+			Record: "v=DKIM1; k=ed25519; ra=dkim=0D=0Areports; p=" + edKey,
+			Codes:  []string{"report-invalid"},
+		},
+		{
+			Name:   "report string with control characters",
+			Record: "v=DKIM1; k=ed25519; ra=dkim-reports; rs=a=0Ab; p=" + edKey,
+			Codes:  []string{"report-invalid"},
+		},
+		{
+			Name:   "report tags without address",
+			Record: "v=DKIM1; k=ed25519; rp=100; p=" + edKey,
+			Codes:  []string{"report-no-address"},
+		},
+		{
+			Name:   "invalid private key",
+			Record: "v=DKIM1; k=ed25519; p=" + edKey,
+			Opts:   []LintOpt{LintOptPrivateKey([]byte("not a key"))},
+			Codes:  []string{"private-key-invalid"},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Codes, lintCodes(LintKeyRecord(tc.Record, tc.Opts...)))
+		})
+	}
+}
+
+func TestLintDNSKeyRecord(t *testing.T) {
+	t.Parallel()
+
+	record := "v=DKIM1; p=" + pubKey
+	strs := [][]string{{record}}
+	lookup := LintOptDNS(DNSOptLookupTXTStrings(func(name string) ([][]string, error) {
+		assert.Equal(t, selector+"._domainkey."+domain, name)
+		return strs, nil
+	}))
+
+	findings, err := LintDNSKeyRecord(selector, domain, lookup)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"key-short"}, lintCodes(findings))
+	assert.Equal(t, "warning: key-short: RSA key is 1024 bits, 2048 bits are recommended", findings[0].String())
+
+	strs = [][]string{{record + strings.Repeat(" ", 300)}, {"v=DKIM1; p="}}
+	findings, err = LintDNSKeyRecord(selector, domain, lookup)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"multiple-records", "txt-string-too-long", "key-short", "key-revoked"}, lintCodes(findings))
+
+	strs = nil
+	_, err = LintDNSKeyRecord(selector, domain, lookup)
+	assert.ErrorIs(t, err, ErrVerifyNoKeyForSignature)
+
+	// the lookup timeout applies to both lookup functions
+	timeout := LintOptDNS(DNSOptLookupTimeout(50 * time.Millisecond))
+	slowStrings := LintOptDNS(DNSOptLookupTXTStrings(func(name string) ([][]string, error) {
+		time.Sleep(500 * time.Millisecond)
+		return [][]string{{record}}, nil
+	}))
+	slowTXT := LintOptDNS(DNSOptLookupTXT(func(name string) ([]string, error) {
+		time.Sleep(500 * time.Millisecond)
+		return []string{record}, nil
+	}))
+	for _, slow := range []LintOpt{slowStrings, slowTXT} {
+		_, err = LintDNSKeyRecord(selector, domain, slow, timeout)
+		var lookupErr *KeyLookupError
+		require.ErrorAs(t, err, &lookupErr)
+		assert.Equal(t, KeyLookupTimeout, lookupErr.Reason)
+	}
+}
@@ -0,0 +1,179 @@
+package dkim
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/rsa"
+	"errors"
+	"fmt"
+	"io"
+	"net/mail"
+	"net/textproto"
+	"sort"
+	"strings"
+)
+
+// RawHeader is the header of a message as a list of raw header fields, in
+// message order. Each field is kept as written (name case, whitespace and
+// folding), without its final line terminator. Unlike textproto.MIMEHeader
+// and mail.Header, it can be used with simple header canonicalization.
+//
+// RawHeader can be passed to SignReader and VerifyReader.
+type RawHeader []string
+
+// ReadRawHeader reads header fields from r up to and including the empty
+// line separating them from the body. Lines may be terminated by CRLF or LF.
+func ReadRawHeader(r *bufio.Reader) (RawHeader, error) {
+	var header RawHeader
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return nil, err
+		}
+		switch {
+		case strings.TrimRight(line, "\r\n") == "":
+			// end of header, or of a message without body
+			return header.trimTerminators(), nil
+		case line[0] == ' ' || line[0] == '\t':
+			if len(header) == 0 {
+				return nil, ErrBadMailFormatHeaders
+			}
+			header[len(header)-1] += line
+		case strings.IndexByte(line, ':') == -1:
+			return nil, ErrBadMailFormatHeaders
+		default:
+			header = append(header, line)
+		}
+		if err == io.EOF {
+			return header.trimTerminators(), nil
+		}
+	}
+}
+
+// trimTerminators removes the final line terminator of the fields
+func (h RawHeader) trimTerminators() RawHeader {
+	for i, field := range h {
+		field = strings.TrimSuffix(field, "\n")
+		h[i] = strings.TrimSuffix(field, "\r")
+	}
+	return h
+}
+
+// ReadRawMessage reads the header of the message in r and returns it with
+// a reader of the body
+func ReadRawMessage(r io.Reader) (RawHeader, io.Reader, error) {
+	br := bufio.NewReader(r)
+	header, err := ReadRawHeader(br)
+	if err != nil {
+		return nil, nil, err
+	}
+	return header, br, nil
+}
+
+// Get returns the unfolded value of the first field named name (case
+// insensitive), without leading and trailing whitespace
+func (h RawHeader) Get(name string) string {
+	for _, field := range h {
+		n, v, _ := strings.Cut(field, ":")
+		if strings.EqualFold(strings.TrimRight(n, " \t"), name) {
+			return strings.TrimSpace(strings.NewReplacer("\r\n", "", "\n", "").Replace(v))
+		}
+	}
+	return ""
+}
+
+// Bytes returns the header fields CRLF terminated, without the empty line
+// ending the header
+func (h RawHeader) Bytes() []byte {
+	var b bytes.Buffer
+	for _, field := range h {
+		b.WriteString(field)
+		b.WriteString(CRLF)
+	}
+	return b.Bytes()
+}
+
+// VerifyReader verifies a message given as its header fields (in message
+// order, folded lines included) and its body, like VerifyWithResult
+func VerifyReader(header []string, body io.Reader, opts ...VerifyOpt) *VerifyResult {
+	var email bytes.Buffer
+	email.Write(RawHeader(header).Bytes())
+	email.WriteString(CRLF)
+	if _, err := io.Copy(&email, body); err != nil {
+		return &VerifyResult{Status: TEMPFAIL, Err: err}
+	}
+	b := email.Bytes()
+	return VerifyWithResult(&b, opts...)
+}
+
+// mimeHeaderFields returns the fields of header, ordered by name. Order
+// between fields of different names doesn't matter to DKIM, as signed
+// fields are selected by the h= tag.
+func mimeHeaderFields(header textproto.MIMEHeader) []string {
+	names := make([]string, 0, len(header))
+	for name := range header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var fields []string
+	for _, name := range names {
+		for _, value := range header[name] {
+			fields = append(fields, name+": "+value)
+		}
+	}
+	return fields
+}
+
+// SignMIMEHeader signs a message given as a textproto.MIMEHeader and its
+// body, and returns the DKIM-Signature header field, CRLF terminated.
+//
+// textproto.MIMEHeader loses the case of field names and the folding of
+// values, so only relaxed header canonicalization can be used: it returns
+// ErrLossyHeader with simple header canonicalization.
+func SignMIMEHeader(header textproto.MIMEHeader, body io.Reader, options SigOptions) (string, error) {
+	cano, err := validateCanonicalization(strings.ToLower(options.Canonicalization))
+	if err != nil {
+		return "", err
+	}
+	if strings.HasPrefix(cano, SimpleCanonicalization) {
+		return "", ErrLossyHeader
+	}
+	return SignReader(mimeHeaderFields(header), body, options)
+}
+
+// VerifyMIMEHeader verifies a message given as a textproto.MIMEHeader and
+// its body.
+//
+// textproto.MIMEHeader loses the case of field names and the folding of
+// values: when the signature check of a signature using simple header
+// canonicalization fails, the error wraps ErrLossyHeader as the fields may
+// have been modified. Key lookup and key record errors are not wrapped.
+func VerifyMIMEHeader(header textproto.MIMEHeader, body io.Reader, opts ...VerifyOpt) *VerifyResult {
+	res := VerifyReader(mimeHeaderFields(header), body, opts...)
+	if res.Header != nil && isSignatureMismatch(res) &&
+		strings.HasPrefix(res.Header.MessageCanonicalization, SimpleCanonicalization) {
+		res.Err = fmt.Errorf("%w: %w", ErrLossyHeader, res.Err)
+	}
+	return res
+}
+
+// isSignatureMismatch returns whether res failed because the signature
+// doesn't match the signed header fields
+func isSignatureMismatch(res *VerifyResult) bool {
+	if res.Status != PERMFAIL && res.Status != TESTINGPERMFAIL {
+		return false
+	}
+	return errors.Is(res.Err, rsa.ErrVerification) || errors.Is(res.Err, ErrVerifyEd25519Signature)
+}
+
+// SignMailMessage signs m and returns the DKIM-Signature header field, CRLF
+// terminated. m.Body is consumed. As with SignMIMEHeader, only relaxed
+// header canonicalization can be used.
+func SignMailMessage(m *mail.Message, options SigOptions) (string, error) {
+	return SignMIMEHeader(textproto.MIMEHeader(m.Header), m.Body, options)
+}
+
+// VerifyMailMessage verifies m like VerifyMIMEHeader. m.Body is consumed.
+func VerifyMailMessage(m *mail.Message, opts ...VerifyOpt) *VerifyResult {
+	return VerifyMIMEHeader(textproto.MIMEHeader(m.Header), m.Body, opts...)
+}
@@ -0,0 +1,159 @@
+package dkim
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadRawHeader(t *testing.T) {
+	testCases := []struct {
+		Name   string
+		Input  string
+		Header RawHeader
+		Body   string
+		Err    error
+	}{
+		{
+			Name:   "crlf",
+			Input:  "FROM: joe\r\nSubject:  a\r\n\tb  \r\n\r\nbody\r\n",
+			Header: RawHeader{"FROM: joe", "Subject:  a\r\n\tb  "},
+			Body:   "body\r\n",
+		},
+		{
+			Name:   "lf",
+			Input:  "From: joe\nSubject: a\n b\n\nbody\n",
+			Header: RawHeader{"From: joe", "Subject: a\n b"},
+			Body:   "body\n",
+		},
+		{
+			Name:   "no body",
+			Input:  "From: joe\r\n",
+			Header: RawHeader{"From: joe"},
+		},
+		{
+			Name:  "continuation first",
+			Input: " From: joe\r\n\r\n",
+			Err:   ErrBadMailFormatHeaders,
+		},
+		{
+			Name:  "no colon",
+			Input: "From joe\r\n\r\n",
+			Err:   ErrBadMailFormatHeaders,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			header, body, err := ReadRawMessage(strings.NewReader(tc.Input))
+			if tc.Err != nil {
+				assert.Equal(t, tc.Err, err)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.Header, header)
+			b, err := io.ReadAll(body)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Body, string(b))
+		})
+	}
+}
+
+func TestRawHeader(t *testing.T) {
+	header, err := ReadRawHeader(bufio.NewReader(strings.NewReader(emailBase)))
+	require.NoError(t, err)
+	assert.Len(t, header, 10)
+	assert.Equal(t, "from mail483.ha.ovh.net (b6.ovh.net [213.186.33.56]) by mo51.mail-out.ovh.net (Postfix) with SMTP id A6E22FF8934 for <toorop@toorop.fr>; Mon,  4 May 2015 14:00:47 +0200 (CEST)",
+		RawHeader{header[2]}.Get("received"))
+	assert.Equal(t, "1.0", header.Get("Mime-Version"))
+	assert.Equal(t, "", header.Get("cc"))
+	assert.Equal(t, emailBase[:strings.Index(emailBase, CRLF+CRLF)+2], string(header.Bytes()))
+}
+
+func TestSignVerifyReader(t *testing.T) {
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	options.Headers = []string{"from", "date", "mime-version", "received", "received"}
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+
+	for _, cano := range []string{"simple/simple", "relaxed/relaxed"} {
+		options.Canonicalization = cano
+		header, body, err := ReadRawMessage(strings.NewReader(emailBase))
+		require.NoError(t, err)
+		dHeader, err := SignReader(header, body, options)
+		require.NoError(t, err)
+
+		header, body, err = ReadRawMessage(strings.NewReader(dHeader + emailBase))
+		require.NoError(t, err)
+		res := VerifyReader(header, body, resolveTXT)
+		assert.NoError(t, res.Err, cano)
+		assert.Equal(t, SUCCESS, res.Status, cano)
+	}
+}
+
+func TestMailMessage(t *testing.T) {
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	options.Headers = []string{"from", "date", "mime-version", "received", "received"}
+
+	// relaxed
+	m, err := mail.ReadMessage(strings.NewReader(signedRelaxedRelaxed))
+	require.NoError(t, err)
+	res := VerifyMailMessage(m, resolveTXT)
+	assert.NoError(t, res.Err)
+	assert.Equal(t, SUCCESS, res.Status)
+
+	options.Canonicalization = "relaxed/simple"
+	m, err = mail.ReadMessage(strings.NewReader(emailBase))
+	require.NoError(t, err)
+	dHeader, err := SignMailMessage(m, options)
+	require.NoError(t, err)
+	email := []byte(dHeader + emailBase)
+	status, err := Verify(&email, resolveTXT)
+	assert.NoError(t, err)
+	assert.Equal(t, SUCCESS, status)
+
+	// simple: case and folding are lost
+	m, err = mail.ReadMessage(strings.NewReader(signedSimpleSimple))
+	require.NoError(t, err)
+	res = VerifyMailMessage(m, resolveTXT)
+	assert.Equal(t, PERMFAIL, res.Status)
+	assert.ErrorIs(t, res.Err, ErrLossyHeader)
+
+	// simple: key lookup errors are not caused by the header
+	for _, lookupErr := range []error{
+		&net.DNSError{Err: "no such host", Name: "test._domainkey.tmail.io", IsNotFound: true},
+		&net.DNSError{Err: "i/o timeout", Name: "test._domainkey.tmail.io", IsTimeout: true},
+	} {
+		m, err = mail.ReadMessage(strings.NewReader(signedSimpleSimple))
+		require.NoError(t, err)
+		lookupErr := lookupErr
+		res = VerifyMailMessage(m, DNSOptLookupTXT(func(name string) ([]string, error) {
+			return nil, lookupErr
+		}))
+		assert.Error(t, res.Err)
+		assert.NotErrorIs(t, res.Err, ErrLossyHeader)
+	}
+
+	options.Canonicalization = "simple/relaxed"
+	m, err = mail.ReadMessage(strings.NewReader(emailBase))
+	require.NoError(t, err)
+	_, err = SignMailMessage(m, options)
+	assert.Equal(t, ErrLossyHeader, err)
+}
@@ -0,0 +1,130 @@
+package dkim
+
+import (
+	"time"
+)
+
+// Observer receives events of signing, verification and key lookups, to
+// collect metrics, logs or traces. Its methods may be called concurrently.
+//
+// Set it with SigOptions.Observer for signing, and DNSOptObserver for
+// verification and key lookups.
+type Observer interface {
+	// OnSign is called after each signature, successful or not
+	OnSign(SignEvent)
+
+	// OnVerifyResult is called with the result of each signature verified,
+	// and for unsigned or unparsable messages
+	OnVerifyResult(VerifyEvent)
+
+	// OnKeyLookup is called after each key lookup, including the ones
+	// answered by a KeyCache
+	OnKeyLookup(KeyLookupEvent)
+}
+
+// SignEvent describes a signature
+type SignEvent struct {
+	Domain   string
+	Selector string
+	Algo     string
+
+	Start    time.Time
+	Duration time.Duration
+
+	// Err is the error of Sign, if any
+	Err error
+}
+
+// VerifyEvent describes the verification of a signature
+type VerifyEvent struct {
+	Result *VerifyResult
+
+	Start    time.Time
+	Duration time.Duration
+}
+
+// Domain returns the d= domain of the signature, or "" if the signature
+// couldn't be parsed
+func (e VerifyEvent) Domain() string {
+	if e.Result.Header == nil {
+		return ""
+	}
+	return e.Result.Header.Domain
+}
+
+// KeyLookupEvent describes a key lookup
+type KeyLookupEvent struct {
+	// Name is the key record name (selector._domainkey.domain)
+	Name string
+
+	// Cached is true if the key was found in a KeyCache
+	Cached bool
+
+	Start    time.Time
+	Duration time.Duration
+
+	// Err is the lookup or key record error, if any
+	Err error
+}
+
+// DNSOptObserver sets the observer notified of key lookups and, when used
+// as a VerifyOpt, of verification results
+func DNSOptObserver(observer Observer) DNSOpt {
+	return dnsOpt(func(opts *DNSOptions) {
+		opts.observer = observer
+	})
+}
+
+// MultiObserver returns an Observer notifying each of observers
+func MultiObserver(observers ...Observer) Observer {
+	return multiObserver(observers)
+}
+
+type multiObserver []Observer
+
+func (m multiObserver) OnSign(e SignEvent) {
+	for _, o := range m {
+		o.OnSign(e)
+	}
+}
+
+func (m multiObserver) OnVerifyResult(e VerifyEvent) {
+	for _, o := range m {
+		o.OnVerifyResult(e)
+	}
+}
+
+func (m multiObserver) OnKeyLookup(e KeyLookupEvent) {
+	for _, o := range m {
+		o.OnKeyLookup(e)
+	}
+}
+
+// observeSign notifies the observer of options, if any
+func observeSign(options SigOptions, start time.Time, err error) {
+	if options.Observer != nil {
+		options.Observer.OnSign(SignEvent{
+			Domain:   options.Domain,
+			Selector: options.Selector,
+			Algo:     options.Algo,
+			Start:    start,
+			Duration: time.Since(start),
+			Err:      err,
+		})
+	}
+}
+
+// observeVerify notifies the observer of opts, if any, and returns res
+func (opts *DNSOptions) observeVerify(res *VerifyResult, start time.Time) *VerifyResult {
+	if opts.observer != nil {
+		opts.observer.OnVerifyResult(VerifyEvent{Result: res, Start: start, Duration: time.Since(start)})
+	}
+	return res
+}
+
+// observeKeyLookup notifies the observer of opts, if any
+func (opts *DNSOptions) observeKeyLookup(name string, cached bool, start time.Time, err error) {
+	if opts.observer != nil {
+		opts.observer.OnKeyLookup(KeyLookupEvent{Name: name, Cached: cached, Start: start, Duration: time.Since(start), Err: err})
+	}
+}
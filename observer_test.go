@@ -0,0 +1,90 @@
+package dkim
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recorder is an Observer recording events
+type recorder struct {
+	mu      sync.Mutex
+	signs   []SignEvent
+	results []VerifyEvent
+	lookups []KeyLookupEvent
+}
+
+func (r *recorder) OnSign(e SignEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.signs = append(r.signs, e)
+}
+
+func (r *recorder) OnVerifyResult(e VerifyEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.results = append(r.results, e)
+}
+
+func (r *recorder) OnKeyLookup(e KeyLookupEvent) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.lookups = append(r.lookups, e)
+}
+
+func TestObserver(t *testing.T) {
+	rec, rec2 := &recorder{}, &recorder{}
+	observer := MultiObserver(rec, rec2)
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	})
+
+	before := time.Now()
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	options.Observer = observer
+	email := []byte(emailBase)
+	require.NoError(t, Sign(&email, options))
+	bad := options
+	bad.Selector = ""
+	assert.Error(t, SignMulti(&email, options, bad))
+
+	require.Len(t, rec.signs, 2)
+	assert.Equal(t, domain, rec.signs[0].Domain)
+	assert.Equal(t, selector, rec.signs[0].Selector)
+	assert.Equal(t, "rsa-sha256", rec.signs[0].Algo)
+	assert.NoError(t, rec.signs[0].Err)
+	assert.False(t, rec.signs[0].Start.Before(before))
+	assert.Equal(t, ErrSignSelectorRequired, rec.signs[1].Err)
+
+	status, err := Verify(&email, resolveTXT, DNSOptObserver(observer))
+	require.NoError(t, err)
+	assert.Equal(t, SUCCESS, status)
+	unsigned := []byte(emailBase)
+	status, _ = Verify(&unsigned, resolveTXT, DNSOptObserver(observer))
+	assert.Equal(t, NOTSIGNED, status)
+
+	cache := NewKeyCache(time.Hour)
+	VerifyAll(&email, resolveTXT, DNSOptObserver(observer), VerifyOptKeyCache(cache))
+	VerifyAll(&email, resolveTXT, DNSOptObserver(observer), VerifyOptKeyCache(cache))
+
+	require.Len(t, rec.results, 4)
+	assert.Equal(t, SUCCESS, rec.results[0].Result.Status)
+	assert.Equal(t, domain, rec.results[0].Domain())
+	assert.Equal(t, NOTSIGNED, rec.results[1].Result.Status)
+	assert.Equal(t, "", rec.results[1].Domain())
+
+	require.Len(t, rec.lookups, 3)
+	for i, cached := range []bool{false, false, true} {
+		assert.Equal(t, selector+"._domainkey."+domain, rec.lookups[i].Name)
+		assert.Equal(t, cached, rec.lookups[i].Cached)
+		assert.NoError(t, rec.lookups[i].Err)
+	}
+
+	assert.Equal(t, rec, rec2)
+}
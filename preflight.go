@@ -0,0 +1,191 @@
+package dkim
+
+import (
+	"context"
+	"strings"
+	"sync"
+	"time"
+)
+
+// KeyMismatchError is returned by CheckSigningKey when signatures made with
+// the signing options wouldn't verify with the published key record.
+// errors.Is(err, ErrSignKeyMismatch) is true.
+type KeyMismatchError struct {
+	// Name is the key record name (selector._domainkey.domain)
+	Name string
+
+	// Reasons lists the mismatches
+	Reasons []string
+}
+
+// Error implements error
+func (e *KeyMismatchError) Error() string {
+	return ErrSignKeyMismatch.Error() + ": " + e.Name + ": " + strings.Join(e.Reasons, "; ")
+}
+
+// Is matches ErrSignKeyMismatch
+func (e *KeyMismatchError) Is(target error) bool {
+	return target == ErrSignKeyMismatch
+}
+
+// CheckSigningKey resolves the key record of the selector and domain of
+// options and checks that signatures made with options will verify: the
+// public key and its type must match the private key, and the h=, s= and
+// t=s constraints of the record must allow the signatures.
+//
+// It returns a *KeyLookupError or a key record error if the record can't be
+// retrieved or parsed, and a *KeyMismatchError if it doesn't match.
+func CheckSigningKey(options SigOptions, opts ...DNSOpt) error {
+	if options.Domain == "" {
+		return ErrSignDomainRequired
+	}
+	if options.Selector == "" {
+		return ErrSignSelectorRequired
+	}
+	signer, err := parsePrivateKey(options.PrivateKey)
+	if err != nil {
+		return err
+	}
+	algo := strings.ToLower(options.Algo)
+	if algo == "" {
+		algo = "rsa-sha256"
+	}
+	if !isValidAlgo(algo) {
+		return ErrSignBadAlgo
+	}
+
+	pkr, _, err := NewPubKeyRespFromDNS(options.Selector, options.Domain, opts...)
+	if err != nil {
+		return err
+	}
+
+	mismatch := &KeyMismatchError{Name: options.Selector + "._domainkey." + options.Domain}
+	sigKeyType, sigHash, _ := strings.Cut(algo, "-")
+	if pkr.KeyType != sigKeyType {
+		mismatch.Reasons = append(mismatch.Reasons, "k="+pkr.KeyType+" can't verify "+algo+" signatures")
+	} else if !pkr.matches(signer.Public()) {
+		mismatch.Reasons = append(mismatch.Reasons, "p= doesn't match the private key")
+	}
+	if keyType(signer.Public()) != sigKeyType {
+		mismatch.Reasons = append(mismatch.Reasons, "private key can't make "+algo+" signatures")
+	}
+	hashAllowed := false
+	for _, h := range pkr.HashAlgo {
+		if h == sigHash {
+			hashAllowed = true
+		}
+	}
+	if !hashAllowed {
+		mismatch.Reasons = append(mismatch.Reasons, "h="+strings.Join(pkr.HashAlgo, ":")+" doesn't allow "+sigHash)
+	}
+	if !pkr.allowsEmail() {
+		mismatch.Reasons = append(mismatch.Reasons, "s="+strings.Join(pkr.ServiceType, ":")+" doesn't allow email")
+	}
+	if pkr.FlagIMustBeD && options.Auid != "" {
+		auidDomain := options.Auid[strings.LastIndex(options.Auid, "@")+1:]
+		if !strings.EqualFold(auidDomain, options.Domain) {
+			mismatch.Reasons = append(mismatch.Reasons, "t=s doesn't allow i= domain "+auidDomain)
+		}
+	}
+	if len(mismatch.Reasons) > 0 {
+		return mismatch
+	}
+	return nil
+}
+
+// KeyStatus is the result of a KeyMonitor check
+type KeyStatus struct {
+	// Checked is the time of the check
+	Checked time.Time
+
+	// Selector and Domain which were checked
+	Selector string
+	Domain   string
+
+	// Err is the error returned by CheckSigningKey (nil if the key is OK)
+	Err error
+}
+
+// OK returns true if the key was checked and matches
+func (s KeyStatus) OK() bool {
+	return !s.Checked.IsZero() && s.Err == nil
+}
+
+// DefaultKeyMonitorInterval is the interval between checks of a KeyMonitor
+// without Interval
+const DefaultKeyMonitorInterval = time.Hour
+
+// KeyMonitor periodically checks that the signing key matches the
+// published key record (see CheckSigningKey).
+type KeyMonitor struct {
+	// Options returns the signing options to check, called before each
+	// check (eg a Rotation.SigOptions wrapper)
+	Options func() (SigOptions, error)
+
+	// Interval between checks (DefaultKeyMonitorInterval if zero or negative)
+	Interval time.Duration
+
+	// DNSOpts used to retrieve the key record
+	DNSOpts []DNSOpt
+
+	// OnCheck, if not nil, is called with the status after each check
+	OnCheck func(KeyStatus)
+
+	mu     sync.RWMutex
+	status KeyStatus
+}
+
+// NewKeyMonitor returns a KeyMonitor checking options every interval
+func NewKeyMonitor(options SigOptions, interval time.Duration, opts ...DNSOpt) *KeyMonitor {
+	return &KeyMonitor{
+		Options: func() (SigOptions, error) {
+			return options, nil
+		},
+		Interval: interval,
+		DNSOpts:  opts,
+	}
+}
+
+// Check checks the key now and returns the status
+func (m *KeyMonitor) Check() KeyStatus {
+	status := KeyStatus{Checked: time.Now()}
+	options, err := m.Options()
+	if err == nil {
+		status.Selector, status.Domain = options.Selector, options.Domain
+		err = CheckSigningKey(options, m.DNSOpts...)
+	}
+	status.Err = err
+
+	m.mu.Lock()
+	m.status = status
+	m.mu.Unlock()
+	if m.OnCheck != nil {
+		m.OnCheck(status)
+	}
+	return status
+}
+
+// Status returns the status of the last check
+func (m *KeyMonitor) Status() KeyStatus {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.status
+}
+
+// Run checks the key immediately and then every Interval until ctx is done
+func (m *KeyMonitor) Run(ctx context.Context) {
+	interval := m.Interval
+	if interval <= 0 {
+		interval = DefaultKeyMonitorInterval
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		m.Check()
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package dkim
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCheckSigningKey(t *testing.T) {
+	t.Parallel()
+
+	edKey, err := GenerateKey("ed25519-sha256")
+	require.NoError(t, err)
+	edRecord, err := PublicKeyRecord(edKey)
+	require.NoError(t, err)
+	otherKey, err := GenerateKey("ed25519-sha256")
+	require.NoError(t, err)
+
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+
+	withRecord := func(record string) DNSOpt {
+		return DNSOptLookupTXT(func(string) ([]string, error) {
+			return []string{record}, nil
+		})
+	}
+
+	testCases := []struct {
+		Name    string
+		Record  string
+		Modify  func(o *SigOptions)
+		Reasons []string
+		Err     error
+	}{
+		{
+			Name:   "match",
+			Record: "v=DKIM1; t=y; p=" + pubKey,
+		},
+		{
+			Name:   "ed25519 match",
+			Record: edRecord,
+			Modify: func(o *SigOptions) {
+				o.PrivateKey = edKey
+				o.Algo = "ed25519-sha256"
+			},
+		},
+		{
+			Name:   "other key",
+			Record: edRecord,
+			Modify: func(o *SigOptions) {
+				o.PrivateKey = otherKey
+				o.Algo = "ed25519-sha256"
+			},
+			Reasons: []string{"p= doesn't match the private key"},
+		},
+		{
+			Name:    "key type",
+			Record:  edRecord,
+			Reasons: []string{"k=ed25519 can't verify rsa-sha256 signatures"},
+		},
+		{
+			Name:    "all constraints",
+			Record:  "v=DKIM1; h=sha1; s=tlsrpt; t=s; p=" + pubKey,
+			Modify:  func(o *SigOptions) { o.Auid = "joe@sub." + domain },
+			Reasons: []string{"h=sha1 doesn't allow sha256", "s=tlsrpt doesn't allow email", "t=s doesn't allow i= domain sub." + domain},
+		},
+		{
+			Name:   "revoked",
+			Record: "v=DKIM1; p=",
+			Err:    ErrVerifyRevokedKey,
+		},
+		{
+			Name:   "upper case algo",
+			Record: "v=DKIM1; p=" + pubKey,
+			Modify: func(o *SigOptions) { o.Algo = "RSA-SHA256" },
+		},
+		{
+			Name:   "bad algo",
+			Record: edRecord,
+			Modify: func(o *SigOptions) { o.Algo = "rsa-md5" },
+			Err:    ErrSignBadAlgo,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			o := options
+			if tc.Modify != nil {
+				tc.Modify(&o)
+			}
+			err := CheckSigningKey(o, withRecord(tc.Record))
+			switch {
+			case tc.Reasons != nil:
+				assert.ErrorIs(t, err, ErrSignKeyMismatch)
+				assert.Equal(t, &KeyMismatchError{Name: selector + "._domainkey." + domain, Reasons: tc.Reasons}, err)
+			case tc.Err != nil:
+				assert.Equal(t, tc.Err, err)
+			default:
+				assert.NoError(t, err)
+			}
+		})
+	}
+
+	err = CheckSigningKey(options, DNSOptLookupTXT(func(name string) ([]string, error) {
+		return nil, &net.DNSError{Err: "server misbehaving", Name: name, IsTemporary: true}
+	}))
+	assert.ErrorIs(t, err, ErrVerifyKeyUnavailable)
+}
+
+func TestKeyMonitor(t *testing.T) {
+	t.Parallel()
+
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+
+	records := make(chan string, 3)
+	records <- "v=DKIM1; p=" + pubKey
+	records <- "v=DKIM1; p="
+	records <- "v=DKIM1; p=" + pubKey
+	m := NewKeyMonitor(options, time.Millisecond, DNSOptLookupTXT(func(string) ([]string, error) {
+		return []string{<-records}, nil
+	}))
+	assert.False(t, m.Status().OK())
+
+	ctx, cancel := context.WithCancel(context.Background())
+	statuses := []KeyStatus{}
+	m.OnCheck = func(s KeyStatus) {
+		statuses = append(statuses, s)
+		if len(statuses) == 3 {
+			cancel()
+		}
+	}
+	m.Run(ctx)
+
+	require.Len(t, statuses, 3)
+	assert.True(t, statuses[0].OK())
+	assert.Equal(t, selector, statuses[0].Selector)
+	assert.Equal(t, domain, statuses[0].Domain)
+	assert.False(t, statuses[1].OK())
+	assert.Equal(t, ErrVerifyRevokedKey, statuses[1].Err)
+	assert.True(t, statuses[2].OK())
+	assert.Equal(t, statuses[2], m.Status())
+}
+
+func TestKeyMonitor_NoInterval(t *testing.T) {
+	t.Parallel()
+
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	m := NewKeyMonitor(options, 0, DNSOptLookupTXT(func(string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	}))
+
+	// the first check happens immediately, the next one after the default interval
+	ctx, cancel := context.WithCancel(context.Background())
+	checks := 0
+	m.OnCheck = func(KeyStatus) {
+		checks++
+		cancel()
+	}
+	assert.NotPanics(t, func() { m.Run(ctx) })
+	assert.Equal(t, 1, checks)
+	assert.True(t, m.Status().OK())
+}
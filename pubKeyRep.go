@@ -1,6 +1,8 @@
 package dkim
 
 import (
+	"context"
+	"crypto/ed25519"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
@@ -8,27 +10,64 @@ import (
 	"mime/quotedprintable"
 	"net"
 	"strings"
+	"time"
 )
 
 // PubKeyRep represents a parsed version of public key record
 type PubKeyRep struct {
-	Version      string
-	HashAlgo     []string
-	KeyType      string
-	Note         string
-	PubKey       rsa.PublicKey
-	ServiceType  []string
-	FlagTesting  bool // flag y
-	FlagIMustBeD bool // flag i
+	Version  string
+	HashAlgo []string
+	KeyType  string
+	Note     string
+	PubKey   rsa.PublicKey
+	// Ed25519PubKey is set instead of PubKey when KeyType is ed25519
+	Ed25519PubKey ed25519.PublicKey
+	ServiceType   []string
+	FlagTesting   bool // flag y
+	FlagIMustBeD  bool // flag i
+
+	// Report holds the RFC 6651 reporting tags (ra=, rp=, rr=, rs=) if
+	// the key record has them, see also LookupReportRecord
+	Report *ReportRecord
+}
+
+// allowsEmail returns true if the key can be used for email (s= tag)
+func (p *PubKeyRep) allowsEmail() bool {
+	for _, s := range p.ServiceType {
+		if s == "all" || s == "email" {
+			return true
+		}
+	}
+	return false
 }
 
+// MultipleRecordsPolicy tells what to do when a DNS query returns several
+// distinct key records
+type MultipleRecordsPolicy int
+
+const (
+	// MultipleRecordsTryEach tries each record in order and keeps the first
+	// one that is a valid key record (default)
+	MultipleRecordsTryEach MultipleRecordsPolicy = iota
+
+	// MultipleRecordsPermFail considers several distinct records as a PERMFAIL
+	MultipleRecordsPermFail
+)
+
 // DNSOptions holds settings for looking up DNS records
 type DNSOptions struct {
-	netLookupTXT func(name string) ([]string, error)
+	netLookupTXT        func(name string) ([]string, error)
+	netLookupTXTStrings func(name string) ([][]string, error)
+	multipleRecords     MultipleRecordsPolicy
+	lookupTimeout       time.Duration
+	observer            Observer
 }
 
 // DNSOpt represents an optional setting for looking up DNS records
+//
+// A DNSOpt can also be used as a VerifyOpt.
 type DNSOpt interface {
+	VerifyOpt
 	apply(*DNSOptions)
 }
 
@@ -38,6 +77,10 @@ func (opt dnsOpt) apply(dnsOpts *DNSOptions) {
 	opt(dnsOpts)
 }
 
+func (opt dnsOpt) applyVerify(verifyOpts *VerifyOptions) {
+	opt(&verifyOpts.DNSOptions)
+}
+
 // DNSOptLookupTXT sets the function to use to lookup TXT records.
 //
 // This should probably only be used in tests.
@@ -47,37 +90,189 @@ func DNSOptLookupTXT(netLookupTXT func(name string) ([]string, error)) DNSOpt {
 	})
 }
 
+// DNSOptLookupTXTStrings sets the function to use to lookup TXT records
+// when the character-strings of each record are returned separately.
+// The strings of a record are concatenated before parsing (RFC 6376 3.6.2.2).
+//
+// It takes precedence over DNSOptLookupTXT.
+func DNSOptLookupTXTStrings(netLookupTXTStrings func(name string) ([][]string, error)) DNSOpt {
+	return dnsOpt(func(opts *DNSOptions) {
+		opts.netLookupTXTStrings = netLookupTXTStrings
+	})
+}
+
+// DNSOptMultipleRecords sets the policy applied when several distinct key
+// records are published for a selector.
+func DNSOptMultipleRecords(policy MultipleRecordsPolicy) DNSOpt {
+	return dnsOpt(func(opts *DNSOptions) {
+		opts.multipleRecords = policy
+	})
+}
+
+// DNSOptLookupTimeout sets the maximum duration of a key lookup. A lookup
+// which times out is a TEMPFAIL (KeyLookupTimeout).
+func DNSOptLookupTimeout(timeout time.Duration) DNSOpt {
+	return dnsOpt(func(opts *DNSOptions) {
+		opts.lookupTimeout = timeout
+	})
+}
+
 // NewPubKeyRespFromDNS retrieves the TXT record from DNS based on the specified domain and selector
 // and parses it.
 func NewPubKeyRespFromDNS(selector, domain string, opts ...DNSOpt) (*PubKeyRep, verifyOutput, error) {
-	dnsOpts := DNSOptions{}
+	dnsOpts := newDNSOptions(opts)
+	name := selector + "._domainkey." + domain
+	start := time.Now()
+	pubKey, vo, err := lookupPubKey(name, dnsOpts)
+	dnsOpts.observeKeyLookup(name, false, start, err)
+	return pubKey, vo, err
+}
 
+// newDNSOptions applies opts to the default DNS options
+func newDNSOptions(opts []DNSOpt) *DNSOptions {
+	dnsOpts := &DNSOptions{}
 	for _, opt := range opts {
-		opt.apply(&dnsOpts)
+		opt.apply(dnsOpts)
 	}
 
-	if dnsOpts.netLookupTXT == nil {
+	switch {
+	case dnsOpts.netLookupTXTStrings != nil:
+		lookupTXTStrings := dnsOpts.netLookupTXTStrings
+		dnsOpts.netLookupTXT = func(name string) ([]string, error) {
+			strs, err := lookupTXTStrings(name)
+			if err != nil {
+				return nil, err
+			}
+			txt := make([]string, 0, len(strs))
+			for _, s := range strs {
+				txt = append(txt, strings.Join(s, ""))
+			}
+			return txt, nil
+		}
+	case dnsOpts.netLookupTXT == nil:
 		dnsOpts.netLookupTXT = net.LookupTXT
+		if timeout := dnsOpts.lookupTimeout; timeout > 0 {
+			// the resolver enforces the timeout, lookupTXT doesn't need to
+			dnsOpts.netLookupTXT = func(name string) ([]string, error) {
+				ctx, cancel := context.WithTimeout(context.Background(), timeout)
+				defer cancel()
+				return net.DefaultResolver.LookupTXT(ctx, name)
+			}
+			dnsOpts.lookupTimeout = 0
+		}
 	}
+	return dnsOpts
+}
 
-	txt, err := dnsOpts.netLookupTXT(selector + "._domainkey." + domain)
-	if err != nil {
-		if strings.HasSuffix(err.Error(), "no such host") {
-			return nil, PERMFAIL, ErrVerifyNoKeyForSignature
+// lookupTXT looks up the TXT records of name, within the lookup timeout
+func (o *DNSOptions) lookupTXT(name string) ([]string, error) {
+	if o.lookupTimeout > 0 {
+		return lookupTXTTimeout(o.netLookupTXT, name, o.lookupTimeout)
+	}
+	return o.netLookupTXT(name)
+}
+
+// lookupTXTStrings looks up the TXT records of name as their
+// character-strings, within the lookup timeout. Without a strings lookup
+// function, each record is returned as a single string.
+func (o *DNSOptions) lookupTXTStrings(name string) ([][]string, error) {
+	if o.netLookupTXTStrings == nil {
+		txt, err := o.lookupTXT(name)
+		if err != nil {
+			return nil, err
 		}
+		records := make([][]string, 0, len(txt))
+		for _, t := range txt {
+			records = append(records, []string{t})
+		}
+		return records, nil
+	}
+	if o.lookupTimeout <= 0 {
+		return o.netLookupTXTStrings(name)
+	}
+
+	// records is only read once the lookup returned
+	var records [][]string
+	_, err := lookupTXTTimeout(func(name string) ([]string, error) {
+		var err error
+		records, err = o.netLookupTXTStrings(name)
+		return nil, err
+	}, name, o.lookupTimeout)
+	if err != nil {
+		return nil, err
+	}
+	return records, nil
+}
+
+// lookupPubKey retrieves and parses the key record name
+func lookupPubKey(name string, dnsOpts *DNSOptions) (*PubKeyRep, verifyOutput, error) {
+	txt, err := dnsOpts.lookupTXT(name)
+	if err != nil {
+		lookupErr := newKeyLookupError(name, err)
+		return nil, lookupErr.Reason.Status(), lookupErr
+	}
+	return newPubKeyRespFromRecords(name, txt, dnsOpts.multipleRecords)
+}
 
-		return nil, TEMPFAIL, ErrVerifyKeyUnavailable
+// lookupTXTTimeout calls lookup and gives up after timeout. lookup keeps
+// running in the background until it returns.
+func lookupTXTTimeout(lookup func(name string) ([]string, error), name string, timeout time.Duration) ([]string, error) {
+	type answer struct {
+		txt []string
+		err error
+	}
+	c := make(chan answer, 1)
+	go func() {
+		txt, err := lookup(name)
+		c <- answer{txt, err}
+	}()
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case a := <-c:
+		return a.txt, a.err
+	case <-timer.C:
+		return nil, &net.DNSError{Err: "i/o timeout", Name: name, IsTimeout: true}
+	}
+}
+
+// newPubKeyRespFromRecords parses the key records published for name
+func newPubKeyRespFromRecords(name string, txt []string, multipleRecords MultipleRecordsPolicy) (*PubKeyRep, verifyOutput, error) {
+	// remove duplicates
+	records := make([]string, 0, len(txt))
+	for _, t := range txt {
+		dup := false
+		for _, r := range records {
+			if r == t {
+				dup = true
+				break
+			}
+		}
+		if !dup {
+			records = append(records, t)
+		}
 	}
 
-	// empty record
-	if len(txt) == 0 {
-		return nil, PERMFAIL, ErrVerifyNoKeyForSignature
+	// no record
+	if len(records) == 0 {
+		return nil, PERMFAIL, &KeyLookupError{Name: name, Reason: KeyLookupNoData}
 	}
 
-	// parsing, we keep the first record
-	// TODO: if there is multiple record
+	if len(records) > 1 && multipleRecords == MultipleRecordsPermFail {
+		return nil, PERMFAIL, ErrVerifyMultipleKeyRecords
+	}
 
-	return NewPubKeyResp(txt[0])
+	// keep the first valid record
+	var pkr *PubKeyRep
+	var vo verifyOutput
+	var err error
+	for _, record := range records {
+		pkr, vo, err = NewPubKeyResp(record)
+		if err == nil {
+			break
+		}
+	}
+	return pkr, vo, err
 }
 
 // NewPubKeyResp parses DKIM record (usually from DNS)
@@ -89,14 +284,19 @@ func NewPubKeyResp(dkimRecord string) (*PubKeyRep, verifyOutput, error) {
 	pkr.FlagTesting = false
 	pkr.FlagIMustBeD = false
 
-	p := strings.Split(dkimRecord, ";")
-	for i, data := range p {
-		keyVal := strings.SplitN(data, "=", 2)
-		val := ""
-		if len(keyVal) > 1 {
-			val = strings.TrimSpace(keyVal[1])
+	var un64 []byte
+	tags, err := ParseTagList(dkimRecord)
+	if err != nil {
+		if err == ErrTagListDuplicateTag {
+			return nil, PERMFAIL, ErrVerifyDuplicateTag
 		}
-		switch strings.ToLower(strings.TrimSpace(keyVal[0])) {
+		return nil, PERMFAIL, ErrVerifyKeySyntax
+	}
+
+	for i, tag := range tags {
+		// FWS inside values is not significant
+		val := removeFWS(tag.Value)
+		switch strings.ToLower(tag.Name) {
 		case "v":
 			// RFC: is this tag is specified it MUST be the first in the record
 			if i != 0 {
@@ -120,7 +320,8 @@ func NewPubKeyResp(dkimRecord string) (*PubKeyRep, verifyOutput, error) {
 				pkr.HashAlgo = []string{"sha1", "sha256"}
 			}
 		case "k":
-			if strings.ToLower(val) != "rsa" {
+			pkr.KeyType = strings.ToLower(val)
+			if pkr.KeyType != "rsa" && pkr.KeyType != "ed25519" {
 				return nil, PERMFAIL, ErrVerifyBadKeyType
 			}
 		case "n":
@@ -130,26 +331,25 @@ func NewPubKeyResp(dkimRecord string) (*PubKeyRep, verifyOutput, error) {
 			}
 			pkr.Note = val
 		case "p":
-			rawkey := val
+			// whitespace is ignored in base64 data
+			rawkey := strings.Join(strings.Fields(val), "")
 			if rawkey == "" {
 				return nil, PERMFAIL, ErrVerifyRevokedKey
 			}
-			un64, err := base64.StdEncoding.DecodeString(rawkey)
+			un64, err = base64.StdEncoding.DecodeString(rawkey)
 			if err != nil {
 				return nil, PERMFAIL, ErrVerifyBadKey
 			}
-			pk, err := x509.ParsePKIXPublicKey(un64)
-			if pk, ok := pk.(*rsa.PublicKey); ok {
-				pkr.PubKey = *pk
-			}
 		case "s":
 			t := strings.Split(strings.ToLower(val), ":")
 			for _, tt := range t {
 				tt = strings.TrimSpace(tt)
 				switch tt {
+				case "":
 				case "*":
 					pkr.ServiceType = append(pkr.ServiceType, "all")
-				case "email":
+				default:
+					// unknown service types are kept, they are ignored by Verify
 					pkr.ServiceType = append(pkr.ServiceType, tt)
 				}
 			}
@@ -167,9 +367,9 @@ func NewPubKeyResp(dkimRecord string) (*PubKeyRep, verifyOutput, error) {
 		}
 	}
 
-	// if no pubkey
-	if pkr.PubKey == (rsa.PublicKey{}) {
-		return nil, PERMFAIL, ErrVerifyNoKey
+	// key data depends on key type (k tag can follow p tag)
+	if err := pkr.setKey(un64); err != nil {
+		return nil, PERMFAIL, err
 	}
 
 	// No service type
@@ -177,5 +377,34 @@ func NewPubKeyResp(dkimRecord string) (*PubKeyRep, verifyOutput, error) {
 		pkr.ServiceType = []string{"all"}
 	}
 
+	// reporting tags, invalid ones don't make the key unusable
+	if report, err := parseReportTags(tags); err == nil {
+		pkr.Report = report
+	}
+
 	return pkr, SUCCESS, nil
 }
+
+// setKey sets the public key of pkr from the decoded p= tag, according to
+// pkr.KeyType
+func (pkr *PubKeyRep) setKey(data []byte) error {
+	switch pkr.KeyType {
+	case "rsa":
+		pk, _ := x509.ParsePKIXPublicKey(data)
+		if pk, ok := pk.(*rsa.PublicKey); ok {
+			pkr.PubKey = *pk
+		}
+	case "ed25519":
+		// RFC 8463: the raw public key, not a SubjectPublicKeyInfo
+		if data != nil && len(data) != ed25519.PublicKeySize {
+			return ErrVerifyBadKey
+		}
+		pkr.Ed25519PubKey = data
+	}
+
+	// if no pubkey
+	if pkr.PubKey == (rsa.PublicKey{}) && pkr.Ed25519PubKey == nil {
+		return ErrVerifyNoKey
+	}
+	return nil
+}
@@ -1,7 +1,10 @@
 package dkim
 
 import (
+	"crypto/ed25519"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -71,6 +74,31 @@ func TestPubKeyRep(t *testing.T) {
 			Err:          ErrVerifyBadKey,
 		},
 
+		{
+			Name:         "duplicate tag",
+			Txt:          "v=DKIM1; p=" + pubKey + "; p=" + pubKey,
+			VerifyOutput: PERMFAIL,
+			Err:          ErrVerifyDuplicateTag,
+		},
+		{
+			Name:         "tag without value",
+			Txt:          "v=DKIM1; k; p=" + pubKey,
+			VerifyOutput: PERMFAIL,
+			Err:          ErrVerifyKeySyntax,
+		},
+		{
+			Name: "unknown tag and whitespace",
+			Txt:  " v = DKIM1 ;\r\n\tfoo=bar ;  p =\r\n " + pubKey[:20] + " \r\n " + pubKey[20:] + " ; ",
+			Expect: &PubKeyRep{
+				Version:     "DKIM1",
+				HashAlgo:    []string{"sha1", "sha256"},
+				KeyType:     "rsa",
+				ServiceType: []string{"all"},
+				PubKey:      privKeyRSA(t).PublicKey,
+			},
+			VerifyOutput: SUCCESS,
+		},
+
 		// h=
 		{
 			Name: "all supported hashes",
@@ -158,6 +186,24 @@ func TestPubKeyRep(t *testing.T) {
 			},
 			VerifyOutput: SUCCESS,
 		},
+		{
+			Name: "key type ed25519",
+			Txt:  "v=DKIM1; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=; k=ed25519",
+			Expect: &PubKeyRep{
+				Version:       "DKIM1",
+				HashAlgo:      []string{"sha1", "sha256"},
+				KeyType:       "ed25519",
+				ServiceType:   []string{"all"},
+				Ed25519PubKey: ed25519.PublicKey{0xd7, 0x5a, 0x98, 0x01, 0x82, 0xb1, 0x0a, 0xb7, 0xd5, 0x4b, 0xfe, 0xd3, 0xc9, 0x64, 0x07, 0x3a, 0x0e, 0xe1, 0x72, 0xf3, 0xda, 0xa6, 0x23, 0x25, 0xaf, 0x02, 0x1a, 0x68, 0xf7, 0x07, 0x51, 0x1a},
+			},
+			VerifyOutput: SUCCESS,
+		},
+		{
+			Name:         "ed25519 key with wrong size",
+			Txt:          "v=DKIM1; k=ed25519; p=" + pubKey,
+			VerifyOutput: PERMFAIL,
+			Err:          ErrVerifyBadKey,
+		},
 		{
 			Name:         "unsupported key type",
 			Txt:          "v=DKIM1; k=dsa; p=" + pubKey,
@@ -268,7 +314,7 @@ func TestPubKeyRep(t *testing.T) {
 				Version:     "DKIM1",
 				HashAlgo:    []string{"sha1", "sha256"},
 				KeyType:     "rsa",
-				ServiceType: []string{"all"},
+				ServiceType: []string{"unknown"},
 				PubKey:      privKeyRSA(t).PublicKey,
 			},
 			VerifyOutput: SUCCESS,
@@ -280,7 +326,7 @@ func TestPubKeyRep(t *testing.T) {
 				Version:     "DKIM1",
 				HashAlgo:    []string{"sha1", "sha256"},
 				KeyType:     "rsa",
-				ServiceType: []string{"email"},
+				ServiceType: []string{"unknown", "email"},
 				PubKey:      privKeyRSA(t).PublicKey,
 			},
 			VerifyOutput: SUCCESS,
@@ -377,6 +423,38 @@ func TestPubKeyRep(t *testing.T) {
 			},
 			VerifyOutput: SUCCESS,
 		},
+
+		// ra=, rp=, rr=, rs=
+		{
+			Name: "report tags",
+			Txt:  "v=DKIM1; p=" + pubKey + "; ra=dkim-reports; rp=50; rr=d:x; rs=see=20postmaster",
+			Expect: &PubKeyRep{
+				Version:     "DKIM1",
+				HashAlgo:    []string{"sha1", "sha256"},
+				KeyType:     "rsa",
+				ServiceType: []string{"all"},
+				PubKey:      privKeyRSA(t).PublicKey,
+				Report: &ReportRecord{
+					LocalPart:  "dkim-reports",
+					Percentage: 50,
+					Requested:  []string{"d", "x"},
+					SMTPString: "see postmaster",
+				},
+			},
+			VerifyOutput: SUCCESS,
+		},
+		{
+			Name: "invalid report tags",
+			Txt:  "v=DKIM1; p=" + pubKey + "; ra=dkim-reports; rp=200",
+			Expect: &PubKeyRep{
+				Version:     "DKIM1",
+				HashAlgo:    []string{"sha1", "sha256"},
+				KeyType:     "rsa",
+				ServiceType: []string{"all"},
+				PubKey:      privKeyRSA(t).PublicKey,
+			},
+			VerifyOutput: SUCCESS,
+		},
 	}
 
 	for _, tc := range testCases {
@@ -395,3 +473,107 @@ func TestPubKeyRep(t *testing.T) {
 		})
 	}
 }
+
+func TestNewPubKeyRespFromDNS(t *testing.T) {
+	t.Parallel()
+
+	record := "v=DKIM1; p=" + pubKey
+
+	type testCase struct {
+		Name         string
+		Strings      [][]string
+		Delay        time.Duration
+		Opts         []DNSOpt
+		VerifyOutput verifyOutput
+		Err          error
+	}
+
+	testCases := []testCase{
+		{
+			Name:         "multiple strings",
+			Strings:      [][]string{{record[:30], record[30:60], record[60:]}},
+			VerifyOutput: SUCCESS,
+		},
+		{
+			Name:         "duplicate records",
+			Strings:      [][]string{{record}, {record[:30], record[30:]}},
+			Opts:         []DNSOpt{DNSOptMultipleRecords(MultipleRecordsPermFail)},
+			VerifyOutput: SUCCESS,
+		},
+		{
+			Name:         "multiple records, try each",
+			Strings:      [][]string{{"v=DKIM1; p=badBase64"}, {record}},
+			VerifyOutput: SUCCESS,
+		},
+		{
+			Name:         "multiple records, permfail",
+			Strings:      [][]string{{"v=DKIM1; p=badBase64"}, {record}},
+			Opts:         []DNSOpt{DNSOptMultipleRecords(MultipleRecordsPermFail)},
+			VerifyOutput: PERMFAIL,
+			Err:          ErrVerifyMultipleKeyRecords,
+		},
+		{
+			Name:         "multiple invalid records",
+			Strings:      [][]string{{"v=DKIM1; p=badBase64"}, {"v=DKIM1; p="}},
+			VerifyOutput: PERMFAIL,
+			Err:          ErrVerifyRevokedKey,
+		},
+		{
+			Name:         "no record",
+			Strings:      [][]string{},
+			VerifyOutput: PERMFAIL,
+			Err:          &KeyLookupError{Name: selector + "._domainkey." + domain, Reason: KeyLookupNoData},
+		},
+		{
+			Name:         "timeout",
+			Strings:      [][]string{{record}},
+			Delay:        500 * time.Millisecond,
+			Opts:         []DNSOpt{DNSOptLookupTimeout(50 * time.Millisecond)},
+			VerifyOutput: TEMPFAIL,
+			Err: &KeyLookupError{
+				Name:   selector + "._domainkey." + domain,
+				Reason: KeyLookupTimeout,
+				Err:    &net.DNSError{Err: "i/o timeout", Name: selector + "._domainkey." + domain, IsTimeout: true},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			opts := append([]DNSOpt{DNSOptLookupTXTStrings(func(name string) ([][]string, error) {
+				assert.Equal(t, selector+"._domainkey."+domain, name)
+				time.Sleep(tc.Delay)
+				return tc.Strings, nil
+			})}, tc.Opts...)
+			pubKeyRep, vo, err := NewPubKeyRespFromDNS(selector, domain, opts...)
+			assert.Equal(t, tc.Err, err)
+			assert.Equal(t, tc.VerifyOutput, vo)
+			if tc.Err == nil {
+				assert.Equal(t, privKeyRSA(t).PublicKey, pubKeyRep.PubKey)
+			}
+		})
+	}
+}
+
+func FuzzNewPubKeyResp(f *testing.F) {
+	f.Add("p=" + pubKey)
+	f.Add("v=DKIM1; k=rsa; h=sha1:sha256; s=email:*; t=y:s; n=a=20note; p=" + pubKey)
+	f.Add("v=DKIM1; p=")
+	f.Add("v = DKIM1 ;\r\n p=" + pubKey[:20] + "\r\n\t" + pubKey[20:] + ";")
+	f.Add("v=DKIM1; p=a; p=b")
+	f.Add("v=DKIM1; k=ed25519; p=11qYAYKxCrfVS/7TyWQHOg7hcvPapiMlrwIaaPcHURo=")
+	f.Fuzz(func(t *testing.T, record string) {
+		pubKeyRep, vo, err := NewPubKeyResp(record)
+		if err != nil {
+			if vo != PERMFAIL || pubKeyRep != nil {
+				t.Fatalf("unexpected output for error %v: %v %v", err, vo, pubKeyRep)
+			}
+			return
+		}
+		hasKey := pubKeyRep.PubKey.N != nil || len(pubKeyRep.Ed25519PubKey) == ed25519.PublicKeySize
+		if vo != SUCCESS || !hasKey || len(pubKeyRep.HashAlgo) == 0 || len(pubKeyRep.ServiceType) == 0 {
+			t.Fatalf("invalid key record accepted: %+v", pubKeyRep)
+		}
+	})
+}
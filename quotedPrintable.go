@@ -0,0 +1,81 @@
+package dkim
+
+import (
+	"strings"
+)
+
+const upperHex = "0123456789ABCDEF"
+
+// EncodeQuotedPrintable encodes s as dkim-quoted-printable (RFC 6376 section 2.11)
+//
+//	dkim-quoted-printable =  *(FWS / hex-octet / dkim-safe-char)
+//	dkim-safe-char        =  %x21-3A / %x3C / %x3E-7E
+//
+// Every octet which is not a dkim-safe-char (whitespace, ";", "=", control
+// and non-ASCII octets) is encoded as "=" followed by two hex digits.
+func EncodeQuotedPrintable(s string) string {
+	return encodeQuotedPrintable(s, "")
+}
+
+// encodeQuotedPrintable encodes s as dkim-quoted-printable, chars in extra
+// are also encoded (eg "|" for z tag)
+func encodeQuotedPrintable(s string, extra string) string {
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if isDkimSafeChar(c) && strings.IndexByte(extra, c) == -1 {
+			b.WriteByte(c)
+			continue
+		}
+		b.WriteByte('=')
+		b.WriteByte(upperHex[c>>4])
+		b.WriteByte(upperHex[c&0x0f])
+	}
+	return b.String()
+}
+
+// DecodeQuotedPrintable decodes a dkim-quoted-printable string (RFC 6376 section 2.11)
+//
+// Whitespace (FWS) is not part of the value and is removed before decoding.
+func DecodeQuotedPrintable(s string) (string, error) {
+	s = removeWS(s)
+	var b strings.Builder
+	b.Grow(len(s))
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		if c != '=' {
+			b.WriteByte(c)
+			continue
+		}
+		if i+2 >= len(s) {
+			return "", ErrQuotedPrintable
+		}
+		h, ok1 := unhex(s[i+1])
+		l, ok2 := unhex(s[i+2])
+		if !ok1 || !ok2 {
+			return "", ErrQuotedPrintable
+		}
+		b.WriteByte(h<<4 | l)
+		i += 2
+	}
+	return b.String(), nil
+}
+
+// isDkimSafeChar returns true if c is a dkim-safe-char
+func isDkimSafeChar(c byte) bool {
+	return c >= 0x21 && c <= 0x3A || c == 0x3C || c >= 0x3E && c <= 0x7E
+}
+
+// unhex returns the value of the hex digit c
+func unhex(c byte) (byte, bool) {
+	switch {
+	case c >= '0' && c <= '9':
+		return c - '0', true
+	case c >= 'A' && c <= 'F':
+		return c - 'A' + 10, true
+	case c >= 'a' && c <= 'f':
+		return c - 'a' + 10, true
+	}
+	return 0, false
+}
@@ -0,0 +1,101 @@
+package dkim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQuotedPrintable(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		Name    string
+		Decoded string
+		Encoded string
+	}
+
+	testCases := []testCase{
+		{
+			Name:    "safe chars",
+			Decoded: "joe@football.example.com",
+			Encoded: "joe@football.example.com",
+		},
+		{
+			Name:    "semicolon and equal",
+			Decoded: "joe;x=y@example.com",
+			Encoded: "joe=3Bx=3Dy@example.com",
+		},
+		{
+			Name:    "whitespace",
+			Decoded: "Subject: demo\trun",
+			Encoded: "Subject:=20demo=09run",
+		},
+		{
+			Name:    "non ascii",
+			Decoded: "jöe@example.com",
+			Encoded: "j=C3=B6e@example.com",
+		},
+		{
+			Name:    "empty",
+			Decoded: "",
+			Encoded: "",
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			assert.Equal(t, tc.Encoded, EncodeQuotedPrintable(tc.Decoded))
+			decoded, err := DecodeQuotedPrintable(tc.Encoded)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Decoded, decoded)
+		})
+	}
+}
+
+func TestDecodeQuotedPrintable(t *testing.T) {
+	t.Parallel()
+
+	// FWS is removed
+	decoded, err := DecodeQuotedPrintable("Subject:=20demo=\r\n 20run")
+	assert.NoError(t, err)
+	assert.Equal(t, "Subject: demo run", decoded)
+
+	// lower case hex digits are accepted
+	decoded, err = DecodeQuotedPrintable("a=3bb")
+	assert.NoError(t, err)
+	assert.Equal(t, "a;b", decoded)
+
+	for _, bad := range []string{"=", "=3", "=G0", "abc=Z"} {
+		_, err = DecodeQuotedPrintable(bad)
+		assert.Equal(t, ErrQuotedPrintable, err, bad)
+	}
+}
+
+func Test_SignQuotedPrintableTags(t *testing.T) {
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	options.Auid = "jöe;smith@tmail.io"
+	options.CopiedHeaderFields = []string{
+		"From:=?UTF-8?Q?St=C3=A9phane_Depierrepont?= <toorop@tmail.io>",
+		"Subject:a | b",
+		"X-Long:" + "abcdefghijklmnopqrstuvwxyz;abcdefghijklmnopqrstuvwxyz;abcdefghijklmnopqrstuvwxyz",
+	}
+	email := []byte(emailBase)
+	require.NoError(t, Sign(&email, options))
+
+	dkimHeader, err := GetHeader(&email)
+	require.NoError(t, err)
+	assert.Equal(t, options.Auid, dkimHeader.Auid)
+	assert.Equal(t, options.CopiedHeaderFields, dkimHeader.CopiedHeaderFields)
+
+	status, err := Verify(&email, DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, SUCCESS, status)
+}
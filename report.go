@@ -0,0 +1,318 @@
+package dkim
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"math/rand"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ReportRecord is a DKIM reporting record (RFC 6651 section 3.3). It is
+// published at _report._domainkey.<d> and tells where the Signer wants its
+// failure reports to be sent.
+type ReportRecord struct {
+	// LocalPart of the address reports are sent to, at the d= domain
+	// tag ra
+	LocalPart string
+
+	// Percentage of the failures to report, 0 to 100 (default 100)
+	// tag rp
+	Percentage int
+
+	// Requested lists the reports requested (default "all")
+	// tag rr
+	Requested []string
+
+	// SMTPString is the text the Signer wants in the SMTP reply when a
+	// message is rejected because of a failed signature. Records with
+	// control characters (CR, LF, ...) in ra= or rs= are rejected.
+	// tag rs
+	SMTPString string
+}
+
+// Address returns the address reports for signatures of domain are sent to
+func (r *ReportRecord) Address(domain string) string {
+	return r.LocalPart + "@" + domain
+}
+
+// Requests returns true if reports of type token (eg "all", see RFC 6651
+// section 4.1 for the types) are requested
+func (r *ReportRecord) Requests(token string) bool {
+	for _, rr := range r.Requested {
+		if rr == "all" || rr == strings.ToLower(token) {
+			return true
+		}
+	}
+	return false
+}
+
+// ParseReportRecord parses a DKIM reporting record
+func ParseReportRecord(record string) (*ReportRecord, error) {
+	tags, err := ParseTagList(record)
+	if err != nil {
+		return nil, ErrReportRecordSyntax
+	}
+	return parseReportTags(tags)
+}
+
+// parseReportTags parses the reporting tags of a tag list
+func parseReportTags(tags TagList) (*ReportRecord, error) {
+	ra, ok := tags.Get("ra")
+	if !ok {
+		return nil, ErrReportRecordNoAddress
+	}
+	r := &ReportRecord{Percentage: 100, Requested: []string{"all"}}
+	var err error
+	r.LocalPart, err = DecodeQuotedPrintable(removeWS(ra))
+	if err != nil || r.LocalPart == "" || hasControl(r.LocalPart) {
+		return nil, ErrReportRecordSyntax
+	}
+	if rp, ok := tags.Get("rp"); ok {
+		r.Percentage, err = strconv.Atoi(removeWS(rp))
+		if err != nil || r.Percentage < 0 || r.Percentage > 100 {
+			return nil, ErrReportRecordSyntax
+		}
+	}
+	if rr, ok := tags.Get("rr"); ok {
+		r.Requested = nil
+		for _, token := range strings.Split(strings.ToLower(removeWS(rr)), ":") {
+			if token != "" {
+				r.Requested = append(r.Requested, token)
+			}
+		}
+	}
+	if rs, ok := tags.Get("rs"); ok {
+		// the string goes in SMTP replies
+		r.SMTPString, err = DecodeQuotedPrintable(removeWS(rs))
+		if err != nil || hasControl(r.SMTPString) {
+			return nil, ErrReportRecordSyntax
+		}
+	}
+	return r, nil
+}
+
+// LookupReportRecord retrieves the reporting record of domain from DNS. It
+// returns a *KeyLookupError if the record can't be retrieved.
+func LookupReportRecord(domain string, opts ...DNSOpt) (*ReportRecord, error) {
+	dnsOpts := newDNSOptions(opts)
+	name := "_report._domainkey." + domain
+	txt, err := dnsOpts.lookupTXT(name)
+	if err != nil {
+		return nil, newKeyLookupError(name, err)
+	}
+	if len(txt) == 0 {
+		return nil, &KeyLookupError{Name: name, Reason: KeyLookupNoData}
+	}
+	// keep the first valid record
+	for _, record := range txt {
+		var r *ReportRecord
+		if r, err = ParseReportRecord(record); err == nil {
+			return r, nil
+		}
+	}
+	return nil, err
+}
+
+// ShouldReport returns true if a failure report should be sent for res: the
+// signature failed, it has r=y and record is the reporting record of its
+// domain. The rp= percentage of the failures is sampled.
+func ShouldReport(res *VerifyResult, record *ReportRecord) bool {
+	if res == nil || res.Header == nil || record == nil || !res.Header.ReportRequested {
+		return false
+	}
+	if res.Status != PERMFAIL && res.Status != TESTINGPERMFAIL {
+		return false
+	}
+	return rand.Intn(100) < record.Percentage
+}
+
+// ReportOptions holds the settings of a failure report
+type ReportOptions struct {
+	// From is the address of the reporter (required)
+	From string
+
+	// To is the address of the Signer (required), see ReportRecord.Address
+	To string
+
+	// AuthServID identifies the verifier in the Authentication-Results
+	// field (default is the domain of From)
+	AuthServID string
+
+	// UserAgent of the reporter (default "go-dkim")
+	UserAgent string
+
+	// Optional information about the reported message
+	OriginalMailFrom string
+	OriginalRcptTo   []string
+	SourceIP         string
+	ArrivalDate      time.Time
+
+	// IncludeMessage includes the whole message in the report instead of
+	// its header only
+	IncludeMessage bool
+}
+
+// NewFailureReport returns an RFC 6591 authentication failure report, an
+// ARF message (RFC 5965), for the failed signature res of email. The report
+// is ready to be sent to options.To.
+//
+// Auth-Failure is "bodyhash" if the body hash didn't verify, "revoked" if
+// the key is revoked and "signature" otherwise. The canonicalized header
+// (or body for "bodyhash") is included to help the Signer debugging.
+func NewFailureReport(email []byte, res *VerifyResult, options ReportOptions) ([]byte, error) {
+	if res == nil || res.Header == nil || (res.Status != PERMFAIL && res.Status != TESTINGPERMFAIL) {
+		return nil, ErrReportNotFailed
+	}
+	if options.From == "" || options.To == "" {
+		return nil, ErrReportAddressRequired
+	}
+	if options.AuthServID == "" {
+		options.AuthServID = options.From[strings.LastIndex(options.From, "@")+1:]
+	}
+	if options.UserAgent == "" {
+		options.UserAgent = "go-dkim"
+	}
+	dkh := res.Header
+	// values from the message can't add lines to the report
+	domain, selector, auid := reportSafe(dkh.Domain), reportSafe(dkh.Selector), reportSafe(dkh.Auid)
+	rawHeaders, rawBody, err := getHeadersBody(&email, LineEndingsAuto)
+	if err != nil {
+		return nil, err
+	}
+
+	authFailure := "signature"
+	switch {
+	case errors.Is(res.Err, ErrVerifyBodyHash):
+		authFailure = "bodyhash"
+	case errors.Is(res.Err, ErrVerifyRevokedKey):
+		authFailure = "revoked"
+	}
+
+	// feedback report
+	reason := "unknown"
+	if res.Err != nil {
+		reason = reportSafe(res.Err.Error())
+	}
+	var fr bytes.Buffer
+	field := func(name, value string) {
+		fr.WriteString(name + ": " + value + CRLF)
+	}
+	field("Feedback-Type", "auth-failure")
+	field("User-Agent", options.UserAgent)
+	field("Version", "1")
+	if options.OriginalMailFrom != "" {
+		field("Original-Mail-From", "<"+options.OriginalMailFrom+">")
+	}
+	for _, rcpt := range options.OriginalRcptTo {
+		field("Original-Rcpt-To", "<"+rcpt+">")
+	}
+	if !options.ArrivalDate.IsZero() {
+		field("Arrival-Date", options.ArrivalDate.Format(time.RFC1123Z))
+	}
+	if options.SourceIP != "" {
+		field("Source-IP", options.SourceIP)
+	}
+	field("Authentication-Results", options.AuthServID+"; dkim=fail reason="+strconv.Quote(reason)+
+		" header.d="+domain+" header.s="+selector)
+	field("Auth-Failure", authFailure)
+	field("Reported-Domain", domain)
+	field("DKIM-Domain", domain)
+	field("DKIM-Identity", auid)
+	field("DKIM-Selector", selector)
+	canonicalizations := strings.Split(dkh.MessageCanonicalization, "/")
+	switch authFailure {
+	case "bodyhash":
+		var body bytes.Buffer
+		bc, err := NewBodyCanonicalizer(&body, canonicalizations[1], dkh.BodyLength)
+		if err != nil {
+			return nil, err
+		}
+		bc.Write(rawBody)
+		bc.Close()
+		field("DKIM-Canonicalized-Body", foldBase64(body.Bytes()))
+	case "signature":
+		headers, err := canonicalizeHeaders(rawHeaders, canonicalizations[0], dkh.Headers)
+		if err != nil {
+			return nil, err
+		}
+		dkimHeaderCano, err := canonicalizeHeader(dkh.rawForSign, canonicalizations[0])
+		if err != nil {
+			return nil, err
+		}
+		field("DKIM-Canonicalized-Header", foldBase64(bytes.TrimRight(append(headers, dkimHeaderCano...), " \r\n")))
+	}
+
+	var parts bytes.Buffer
+	w := multipart.NewWriter(&parts)
+	part, err := w.CreatePart(textproto.MIMEHeader{"Content-Type": {"text/plain; charset=us-ascii"}})
+	if err != nil {
+		return nil, err
+	}
+	part.Write([]byte("This is an authentication failure report for a message with a DKIM" + CRLF +
+		"signature of " + domain + " (selector " + selector + ") which failed:" + CRLF +
+		reason + CRLF))
+	if part, err = w.CreatePart(textproto.MIMEHeader{"Content-Type": {"message/feedback-report"}}); err != nil {
+		return nil, err
+	}
+	part.Write(fr.Bytes())
+	original := "text/rfc822-headers"
+	if options.IncludeMessage {
+		original = "message/rfc822"
+	}
+	if part, err = w.CreatePart(textproto.MIMEHeader{"Content-Type": {original}}); err != nil {
+		return nil, err
+	}
+	part.Write(rawHeaders)
+	part.Write([]byte(CRLF))
+	if options.IncludeMessage {
+		part.Write([]byte(CRLF))
+		part.Write(rawBody)
+	}
+	if err = w.Close(); err != nil {
+		return nil, err
+	}
+
+	report := "From: " + options.From + CRLF +
+		"To: " + options.To + CRLF +
+		"Date: " + time.Now().Format(time.RFC1123Z) + CRLF +
+		"Subject: DKIM failure report for " + domain + CRLF +
+		"MIME-Version: 1.0" + CRLF +
+		"Content-Type: multipart/report; report-type=feedback-report;" + FWS + "boundary=\"" + w.Boundary() + "\"" + CRLF + CRLF
+	return append([]byte(report), parts.Bytes()...), nil
+}
+
+// hasControl returns true if s contains a control character
+func hasControl(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] < 0x20 || s[i] == 0x7f {
+			return true
+		}
+	}
+	return false
+}
+
+// reportSafe returns s, dkim-quoted-printable encoded if it contains a
+// control character (eg a decoded i= with CRLF)
+func reportSafe(s string) string {
+	if hasControl(s) {
+		return EncodeQuotedPrintable(s)
+	}
+	return s
+}
+
+// foldBase64 encodes data in base64 folded for a header field
+func foldBase64(data []byte) string {
+	b64 := base64.StdEncoding.EncodeToString(data)
+	var folded strings.Builder
+	for len(b64) > MaxHeaderLineLength {
+		folded.WriteString(b64[:MaxHeaderLineLength] + FWS)
+		b64 = b64[MaxHeaderLineLength:]
+	}
+	folded.WriteString(b64)
+	return folded.String()
+}
@@ -0,0 +1,221 @@
+package dkim
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseReportRecord(t *testing.T) {
+	t.Parallel()
+
+	testCases := []struct {
+		Name   string
+		Record string
+		Expect *ReportRecord
+		Err    error
+	}{
+		{
+			Name:   "defaults",
+			Record: "ra=dkim-reports",
+			Expect: &ReportRecord{LocalPart: "dkim-reports", Percentage: 100, Requested: []string{"all"}},
+		},
+		{
+			Name:   "all tags",
+			Record: "ra=dkim=2Breports; rp=10; rr=D : X; rs=5.7.1=20signature=20failed",
+			Expect: &ReportRecord{
+				LocalPart:  "dkim+reports",
+				Percentage: 10,
+				Requested:  []string{"d", "x"},
+				SMTPString: "5.7.1 signature failed",
+			},
+		},
+		{Name: "no ra", Record: "rp=10", Err: ErrReportRecordNoAddress},
+		{Name: "empty ra", Record: "ra=", Err: ErrReportRecordSyntax},
+		{Name: "rp too big", Record: "ra=r; rp=101", Err: ErrReportRecordSyntax},
+		{Name: "rp not a number", Record: "ra=r; rp=all", Err: ErrReportRecordSyntax},
+		{Name: "syntax", Record: "ra", Err: ErrReportRecordSyntax},
+		{Name: "CRLF in rs", Record: "ra=r; rs=5.7.1=0D=0A250=20ok", Err: ErrReportRecordSyntax},
+		{Name: "control in ra", Record: "ra=r=00", Err: ErrReportRecordSyntax},
+	}
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			r, err := ParseReportRecord(tc.Record)
+			assert.Equal(t, tc.Err, err)
+			assert.Equal(t, tc.Expect, r)
+		})
+	}
+}
+
+func TestReportRecord_Requests(t *testing.T) {
+	r := &ReportRecord{Requested: []string{"d", "x"}}
+	assert.True(t, r.Requests("x"))
+	assert.True(t, r.Requests("D"))
+	assert.False(t, r.Requests("s"))
+	assert.True(t, (&ReportRecord{Requested: []string{"all"}}).Requests("s"))
+	assert.Equal(t, "dkim-reports@tmail.io", (&ReportRecord{LocalPart: "dkim-reports"}).Address("tmail.io"))
+}
+
+func TestLookupReportRecord(t *testing.T) {
+	lookup := DNSOptLookupTXT(func(name string) ([]string, error) {
+		if name == "_report._domainkey."+domain {
+			return []string{"v=DKIM1; p=", "ra=dkim-reports; rp=5"}, nil
+		}
+		return nil, &net.DNSError{Err: "no such host", Name: name, IsNotFound: true}
+	})
+
+	r, err := LookupReportRecord(domain, lookup)
+	require.NoError(t, err)
+	assert.Equal(t, &ReportRecord{LocalPart: "dkim-reports", Percentage: 5, Requested: []string{"all"}}, r)
+
+	_, err = LookupReportRecord("example.com", lookup)
+	var lookupErr *KeyLookupError
+	assert.True(t, errors.As(err, &lookupErr))
+}
+
+// failedSignature signs email with r=y and the changes of modify to the
+// options, modifies it with tamper and verifies it
+func failedSignature(t *testing.T, tamper func(string) string, modify ...func(o *SigOptions)) ([]byte, *VerifyResult) {
+	t.Helper()
+	options := NewSigOptions()
+	options.PrivateKey = []byte(privKey)
+	options.Domain = domain
+	options.Selector = selector
+	options.Headers = []string{"from", "subject"}
+	options.RequestReports = true
+	for _, m := range modify {
+		m(&options)
+	}
+	dHeader, err := SignHeader([]byte(emailBase), options)
+	require.NoError(t, err)
+	assert.Contains(t, dHeader, " r=y;")
+
+	email := []byte(tamper(dHeader + emailBase))
+	res := VerifyWithResult(&email, DNSOptLookupTXT(func(name string) ([]string, error) {
+		return []string{"v=DKIM1; p=" + pubKey}, nil
+	}))
+	require.Equal(t, PERMFAIL, res.Status)
+	require.True(t, res.Header.ReportRequested)
+	return email, res
+}
+
+func TestShouldReport(t *testing.T) {
+	_, res := failedSignature(t, func(email string) string {
+		return strings.Replace(email, "Hello world", "Hello", 1)
+	})
+	record := &ReportRecord{LocalPart: "r", Percentage: 100}
+	assert.True(t, ShouldReport(res, record))
+	assert.False(t, ShouldReport(res, nil))
+	assert.False(t, ShouldReport(res, &ReportRecord{LocalPart: "r", Percentage: 0}))
+	assert.False(t, ShouldReport(&VerifyResult{Status: SUCCESS, Header: res.Header}, record))
+}
+
+func TestNewFailureReport(t *testing.T) {
+	testCases := []struct {
+		Name        string
+		Tamper      func(string) string
+		AuthFailure string
+		DKIMField   string
+	}{
+		{
+			Name: "body",
+			Tamper: func(email string) string {
+				return strings.Replace(email, "Hello world", "Hello", 1)
+			},
+			AuthFailure: "bodyhash",
+			DKIMField:   "Dkim-Canonicalized-Body",
+		},
+		{
+			Name: "header",
+			Tamper: func(email string) string {
+				return strings.Replace(email, "Subject: Test DKIM", "Subject: Test", 1)
+			},
+			AuthFailure: "signature",
+			DKIMField:   "Dkim-Canonicalized-Header",
+		},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			email, res := failedSignature(t, tc.Tamper)
+			report, err := NewFailureReport(email, res, ReportOptions{
+				From:             "postmaster@example.net",
+				To:               "dkim-reports@" + domain,
+				OriginalMailFrom: "toorop@tmail.io",
+				OriginalRcptTo:   []string{"toorop@toorop.fr"},
+				SourceIP:         "192.0.2.1",
+			})
+			require.NoError(t, err)
+
+			msg, err := mail.ReadMessage(bytes.NewReader(report))
+			require.NoError(t, err)
+			assert.Equal(t, "dkim-reports@"+domain, msg.Header.Get("To"))
+			mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+			require.NoError(t, err)
+			assert.Equal(t, "multipart/report", mediaType)
+			assert.Equal(t, "feedback-report", params["report-type"])
+
+			mr := multipart.NewReader(msg.Body, params["boundary"])
+			_, err = mr.NextPart()
+			require.NoError(t, err)
+
+			part, err := mr.NextPart()
+			require.NoError(t, err)
+			assert.Equal(t, "message/feedback-report", part.Header.Get("Content-Type"))
+			fields, err := mail.ReadMessage(io.MultiReader(part, strings.NewReader(CRLF)))
+			require.NoError(t, err)
+			assert.Equal(t, "auth-failure", fields.Header.Get("Feedback-Type"))
+			assert.Equal(t, "1", fields.Header.Get("Version"))
+			assert.Equal(t, tc.AuthFailure, fields.Header.Get("Auth-Failure"))
+			assert.Equal(t, domain, fields.Header.Get("DKIM-Domain"))
+			assert.Equal(t, selector, fields.Header.Get("DKIM-Selector"))
+			assert.Equal(t, "@"+domain, fields.Header.Get("DKIM-Identity"))
+			assert.Equal(t, "<toorop@tmail.io>", fields.Header.Get("Original-Mail-From"))
+			assert.Contains(t, fields.Header.Get("Authentication-Results"), "example.net; dkim=fail")
+			assert.NotEmpty(t, fields.Header[tc.DKIMField])
+
+			part, err = mr.NextPart()
+			require.NoError(t, err)
+			assert.Equal(t, "text/rfc822-headers", part.Header.Get("Content-Type"))
+			original, err := io.ReadAll(part)
+			require.NoError(t, err)
+			assert.Contains(t, string(original), "DKIM-Signature: ")
+			assert.NotContains(t, string(original), "Toorop")
+		})
+	}
+
+	t.Run("header injection", func(t *testing.T) {
+		email, res := failedSignature(t, func(email string) string {
+			return strings.Replace(email, "Hello world", "Hello", 1)
+		}, func(o *SigOptions) {
+			o.Auid = "\r\nX-Injected: yes@" + domain
+		})
+		require.Contains(t, string(email), "i==0D=0AX-Injected:=20yes@"+domain)
+		require.Equal(t, "\r\nX-Injected: yes@"+domain, res.Header.Auid)
+
+		report, err := NewFailureReport(email, res, ReportOptions{From: "postmaster@example.net", To: "dkim-reports@" + domain})
+		require.NoError(t, err)
+		assert.NotContains(t, string(report), "\nX-Injected")
+		assert.Contains(t, string(report), "DKIM-Identity: =0D=0AX-Injected:=20yes@"+domain+CRLF)
+	})
+	t.Run("not failed", func(t *testing.T) {
+		_, err := NewFailureReport([]byte(emailBase), &VerifyResult{Status: SUCCESS, Header: &DKIMHeader{}}, ReportOptions{From: "a@b", To: "c@d"})
+		assert.Equal(t, ErrReportNotFailed, err)
+	})
+	t.Run("no address", func(t *testing.T) {
+		email, res := failedSignature(t, func(email string) string {
+			return strings.Replace(email, "Hello world", "Hello", 1)
+		})
+		_, err := NewFailureReport(email, res, ReportOptions{From: "postmaster@example.net"})
+		assert.Equal(t, ErrReportAddressRequired, err)
+	})
+}
@@ -0,0 +1,226 @@
+package dkim
+
+import (
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// RotationKey is a signing key of a Rotation
+type RotationKey struct {
+	Selector string `json:"selector"`
+
+	// Algo is the signing algorithm ("rsa-sha256", "ed25519-sha256", ...)
+	Algo string `json:"algo"`
+
+	// PrivateKey is the PEM encoded private key
+	PrivateKey []byte `json:"private_key"`
+
+	// The key signs from NotBefore to NotAfter. A zero NotAfter means the
+	// key doesn't expire.
+	NotBefore time.Time `json:"not_before"`
+	NotAfter  time.Time `json:"not_after,omitempty"`
+}
+
+// DNSRecord is a TXT record
+type DNSRecord struct {
+	Name  string
+	Value string
+}
+
+// Rotation manages the signing keys of a domain and their selectors.
+//
+// The life of a key is:
+//   - published, unused: from NotBefore - Overlap, so that the record is
+//     in DNS caches when the key starts signing
+//   - published, signing: from NotBefore to NotAfter
+//   - published, unused: until NotAfter + Overlap, for messages in transit
+//   - revoked (p= empty): until NotAfter + Overlap + RevokedFor
+//   - removed
+//
+// A Rotation is safe for concurrent use.
+type Rotation struct {
+	Domain string
+
+	// Overlap is the time a key is published before it signs and after it
+	// stops signing
+	Overlap time.Duration
+
+	// RevokedFor is the time a retired key is published as revoked before
+	// its record is removed (0: the revoked record is never removed)
+	RevokedFor time.Duration
+
+	mu   sync.RWMutex
+	keys []RotationKey
+}
+
+// NewRotation returns a Rotation for domain without keys
+func NewRotation(domain string, overlap, revokedFor time.Duration) *Rotation {
+	return &Rotation{Domain: domain, Overlap: overlap, RevokedFor: revokedFor}
+}
+
+// Add adds a key to the rotation. The key must match its algorithm and its
+// selector must not be used by another key.
+func (r *Rotation) Add(key RotationKey) error {
+	if key.Selector == "" {
+		return ErrSignSelectorRequired
+	}
+	if err := validateRotationKey(key); err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.hasSelector(key.Selector) {
+		return ErrRotationSelectorExists
+	}
+	r.insert(key)
+	return nil
+}
+
+// validateRotationKey checks that the private key of key matches its algorithm
+func validateRotationKey(key RotationKey) error {
+	if !isValidAlgo(key.Algo) {
+		return ErrSignBadAlgo
+	}
+	signer, err := parsePrivateKey(key.PrivateKey)
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(key.Algo, keyType(signer.Public())+"-") {
+		return ErrSignKeyTypeMismatch
+	}
+	return nil
+}
+
+// hasSelector returns true if a key uses selector. r.mu must be held.
+func (r *Rotation) hasSelector(selector string) bool {
+	for _, k := range r.keys {
+		if strings.EqualFold(k.Selector, selector) {
+			return true
+		}
+	}
+	return false
+}
+
+// insert adds key, keeping keys ordered by NotBefore. r.mu must be held.
+func (r *Rotation) insert(key RotationKey) {
+	r.keys = append(r.keys, key)
+	sort.SliceStable(r.keys, func(i, j int) bool {
+		return r.keys[i].NotBefore.Before(r.keys[j].NotBefore)
+	})
+}
+
+// Keys returns the keys of the rotation, ordered by NotBefore
+func (r *Rotation) Keys() []RotationKey {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]RotationKey(nil), r.keys...)
+}
+
+// Next generates a key for algo which signs for period after the last key
+// of the rotation. A last key without NotAfter stops signing Overlap after
+// now, the time needed to publish the new key. If selector is empty, it is
+// derived from the start date ("s20060102"). The rotation is left unchanged
+// when an error is returned.
+func (r *Rotation) Next(algo, selector string, period time.Duration, now time.Time) (RotationKey, error) {
+	privateKey, err := GenerateKey(algo)
+	if err != nil {
+		return RotationKey{}, err
+	}
+	key := RotationKey{Algo: algo, PrivateKey: privateKey}
+	if err := validateRotationKey(key); err != nil {
+		return RotationKey{}, err
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	start := now.Add(r.Overlap)
+	var last *RotationKey
+	if n := len(r.keys); n > 0 {
+		last = &r.keys[n-1]
+		if last.NotAfter.After(start) {
+			start = last.NotAfter
+		}
+	}
+	if selector == "" {
+		selector = start.UTC().Format("s20060102")
+	}
+	if r.hasSelector(selector) {
+		return RotationKey{}, ErrRotationSelectorExists
+	}
+
+	if last != nil && last.NotAfter.IsZero() {
+		last.NotAfter = start
+	}
+	key.Selector = selector
+	key.NotBefore = start
+	key.NotAfter = start.Add(period)
+	r.insert(key)
+	return key, nil
+}
+
+// Current returns the key signing at t, the one which started last if
+// several keys sign at t
+func (r *Rotation) Current(t time.Time) (RotationKey, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for i := len(r.keys) - 1; i >= 0; i-- {
+		k := r.keys[i]
+		if !t.Before(k.NotBefore) && (k.NotAfter.IsZero() || t.Before(k.NotAfter)) {
+			return k, nil
+		}
+	}
+	return RotationKey{}, ErrRotationNoKey
+}
+
+// SigOptions returns options with the domain, selector, algorithm and key
+// of the key signing at t
+func (r *Rotation) SigOptions(options SigOptions, t time.Time) (SigOptions, error) {
+	k, err := r.Current(t)
+	if err != nil {
+		return options, err
+	}
+	options.Domain = r.Domain
+	options.Selector = k.Selector
+	options.Algo = k.Algo
+	options.PrivateKey = k.PrivateKey
+	return options, nil
+}
+
+// Sign signs email with the current key. The other signing options are
+// taken from options.
+func (r *Rotation) Sign(email *[]byte, options SigOptions) error {
+	options, err := r.SigOptions(options, time.Now())
+	if err != nil {
+		return err
+	}
+	return Sign(email, options)
+}
+
+// DNSChanges returns the records which must be published at t (key
+// records and revoked records, replacing any existing record of the same
+// name) and the records which must be removed.
+func (r *Rotation) DNSChanges(t time.Time) (publish, remove []DNSRecord, err error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	for _, k := range r.keys {
+		name := k.Selector + "._domainkey." + r.Domain
+		switch {
+		case t.Before(k.NotBefore.Add(-r.Overlap)):
+			// not published yet
+		case k.NotAfter.IsZero() || t.Before(k.NotAfter.Add(r.Overlap)):
+			record, err := PublicKeyRecord(k.PrivateKey)
+			if err != nil {
+				return nil, nil, err
+			}
+			publish = append(publish, DNSRecord{Name: name, Value: record})
+		case r.RevokedFor == 0 || t.Before(k.NotAfter.Add(r.Overlap+r.RevokedFor)):
+			publish = append(publish, DNSRecord{Name: name, Value: RevokedKeyRecord})
+		default:
+			remove = append(remove, DNSRecord{Name: name})
+		}
+	}
+	return publish, remove, nil
+}
@@ -0,0 +1,180 @@
+package dkim
+
+import (
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRotation(t *testing.T) {
+	t.Parallel()
+
+	day := 24 * time.Hour
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRotation(domain, 7*day, 30*day)
+
+	_, err := r.Current(t0)
+	assert.Equal(t, ErrRotationNoKey, err)
+
+	// first key, no end
+	require.NoError(t, r.Add(RotationKey{Selector: "test", Algo: "rsa-sha256", PrivateKey: []byte(privKey), NotBefore: t0}))
+	assert.Equal(t, ErrRotationSelectorExists, r.Add(RotationKey{Selector: "TEST", Algo: "rsa-sha256", PrivateKey: []byte(privKey), NotBefore: t0}))
+	assert.Equal(t, ErrSignKeyTypeMismatch, r.Add(RotationKey{Selector: "other", Algo: "ed25519-sha256", PrivateKey: []byte(privKey), NotBefore: t0}))
+
+	// quarterly rotation decided 10 days after t0
+	next, err := r.Next("ed25519-sha256", "", 90*day, t0.Add(10*day))
+	require.NoError(t, err)
+	assert.Equal(t, "s20260118", next.Selector)
+	assert.Equal(t, t0.Add(17*day), next.NotBefore)
+	assert.Equal(t, t0.Add(107*day), next.NotAfter)
+	third, err := r.Next("ed25519-sha256", "q3", 90*day, t0.Add(20*day))
+	require.NoError(t, err)
+	assert.Equal(t, next.NotAfter, third.NotBefore)
+	keys := r.Keys()
+	require.Len(t, keys, 3)
+	assert.Equal(t, next.NotBefore, keys[0].NotAfter)
+
+	nextRecord, err := PublicKeyRecord(next.PrivateKey)
+	require.NoError(t, err)
+	thirdRecord, err := PublicKeyRecord(third.PrivateKey)
+	require.NoError(t, err)
+	firstRecord := "v=DKIM1; k=rsa; p=" + removeWS(pubKey)
+	name := func(s string) string { return s + "._domainkey." + domain }
+
+	tests := []struct {
+		name     string
+		at       time.Time
+		selector string
+		publish  []DNSRecord
+		remove   []DNSRecord
+	}{
+		{
+			name:     "first key only",
+			at:       t0.Add(day),
+			selector: "test",
+			publish:  []DNSRecord{{name("test"), firstRecord}},
+		},
+		{
+			name:     "next key published, unused",
+			at:       t0.Add(12 * day),
+			selector: "test",
+			publish:  []DNSRecord{{name("test"), firstRecord}, {name(next.Selector), nextRecord}},
+		},
+		{
+			name:     "next key signs, first key still published",
+			at:       t0.Add(20 * day),
+			selector: next.Selector,
+			publish:  []DNSRecord{{name("test"), firstRecord}, {name(next.Selector), nextRecord}},
+		},
+		{
+			name:     "first key revoked",
+			at:       t0.Add(30 * day),
+			selector: next.Selector,
+			publish:  []DNSRecord{{name("test"), RevokedKeyRecord}, {name(next.Selector), nextRecord}},
+		},
+		{
+			name:     "first key removed, third key published",
+			at:       t0.Add(101 * day),
+			selector: next.Selector,
+			publish:  []DNSRecord{{name(next.Selector), nextRecord}, {name("q3"), thirdRecord}},
+			remove:   []DNSRecord{{Name: name("test")}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			current, err := r.Current(tt.at)
+			require.NoError(t, err)
+			assert.Equal(t, tt.selector, current.Selector)
+
+			publish, remove, err := r.DNSChanges(tt.at)
+			require.NoError(t, err)
+			assert.Equal(t, tt.publish, publish)
+			assert.Equal(t, tt.remove, remove)
+		})
+	}
+
+	// SigOptions
+	options, err := r.SigOptions(NewSigOptions(), t0.Add(20*day))
+	require.NoError(t, err)
+	assert.Equal(t, domain, options.Domain)
+	assert.Equal(t, next.Selector, options.Selector)
+	assert.Equal(t, "ed25519-sha256", options.Algo)
+	email := []byte(emailBase)
+	require.NoError(t, Sign(&email, options))
+	status, err := Verify(&email, DNSOptLookupTXT(func(string) ([]string, error) {
+		return []string{nextRecord}, nil
+	}))
+	assert.NoError(t, err)
+	assert.Equal(t, SUCCESS, status)
+
+	_, err = r.Current(third.NotAfter)
+	assert.Equal(t, ErrRotationNoKey, err)
+}
+
+func TestRotation_NextSelectorExists(t *testing.T) {
+	t.Parallel()
+
+	day := 24 * time.Hour
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRotation(domain, 7*day, 30*day)
+	require.NoError(t, r.Add(RotationKey{Selector: "s20260118", Algo: "rsa-sha256", PrivateKey: []byte(privKey), NotBefore: t0}))
+
+	// the default selector of the next key is the selector of the current one
+	_, err := r.Next("ed25519-sha256", "", 90*day, t0.Add(10*day))
+	assert.Equal(t, ErrRotationSelectorExists, err)
+	_, err = r.Next("ed25519-sha256", "S20260118", 90*day, t0.Add(10*day))
+	assert.Equal(t, ErrRotationSelectorExists, err)
+	keys := r.Keys()
+	require.Len(t, keys, 1)
+	assert.True(t, keys[0].NotAfter.IsZero(), "current key expires without successor")
+
+	_, err = r.Next("rsa-md5", "q1", 90*day, t0.Add(10*day))
+	assert.Error(t, err)
+	assert.Len(t, r.Keys(), 1)
+
+	next, err := r.Next("ed25519-sha256", "q1", 90*day, t0.Add(10*day))
+	require.NoError(t, err)
+	keys = r.Keys()
+	require.Len(t, keys, 2)
+	assert.Equal(t, next.NotBefore, keys[0].NotAfter)
+
+	// two keys starting the same day
+	r = NewRotation(domain, 0, 0)
+	first, err := r.Next("ed25519-sha256", "", 12*time.Hour, t0)
+	require.NoError(t, err)
+	assert.Equal(t, "s20260101", first.Selector)
+	_, err = r.Next("ed25519-sha256", "", 12*time.Hour, t0)
+	assert.Equal(t, ErrRotationSelectorExists, err)
+	assert.Equal(t, []RotationKey{first}, r.Keys())
+}
+
+func TestRotation_NextConcurrent(t *testing.T) {
+	t.Parallel()
+
+	day := 24 * time.Hour
+	t0 := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	r := NewRotation(domain, 7*day, 30*day)
+	require.NoError(t, r.Add(RotationKey{Selector: "test", Algo: "rsa-sha256", PrivateKey: []byte(privKey), NotBefore: t0}))
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, err := r.Next("ed25519-sha256", "k"+strconv.Itoa(i), 30*day, t0)
+			assert.NoError(t, err)
+		}(i)
+	}
+	wg.Wait()
+
+	// each key starts when the previous one stops
+	keys := r.Keys()
+	require.Len(t, keys, 9)
+	for i := 1; i < len(keys); i++ {
+		assert.Equal(t, keys[i-1].NotAfter, keys[i].NotBefore)
+	}
+}
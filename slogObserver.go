@@ -0,0 +1,67 @@
+package dkim
+
+import (
+	"context"
+	"log/slog"
+)
+
+// slogObserver logs events with log/slog
+type slogObserver struct {
+	logger *slog.Logger
+}
+
+// NewSlogObserver returns an Observer logging events to logger: failures
+// at warn level (error level for signatures), others at debug level
+func NewSlogObserver(logger *slog.Logger) Observer {
+	return slogObserver{logger}
+}
+
+func (o slogObserver) OnSign(e SignEvent) {
+	level := slog.LevelDebug
+	attrs := []slog.Attr{
+		slog.String("domain", e.Domain),
+		slog.String("selector", e.Selector),
+		slog.String("algo", e.Algo),
+		slog.Duration("duration", e.Duration),
+	}
+	if e.Err != nil {
+		level = slog.LevelError
+		attrs = append(attrs, slog.String("error", e.Err.Error()))
+	}
+	o.logger.LogAttrs(context.Background(), level, "dkim sign", attrs...)
+}
+
+func (o slogObserver) OnVerifyResult(e VerifyEvent) {
+	level := slog.LevelDebug
+	attrs := []slog.Attr{slog.String("status", e.Result.Status.String())}
+	if h := e.Result.Header; h != nil {
+		attrs = append(attrs, slog.String("domain", h.Domain), slog.String("selector", h.Selector))
+	}
+	attrs = append(attrs, slog.Duration("duration", e.Duration))
+	if e.Result.KeySource != KeySourceNone {
+		attrs = append(attrs, slog.String("key_source", e.Result.KeySource.String()))
+	}
+	switch e.Result.Status {
+	case SUCCESS, TESTINGSUCCESS, NOTSIGNED:
+	default:
+		level = slog.LevelWarn
+	}
+	if e.Result.Err != nil && e.Result.Status != NOTSIGNED {
+		attrs = append(attrs, slog.String("error", e.Result.Err.Error()))
+	}
+	o.logger.LogAttrs(context.Background(), level, "dkim verify", attrs...)
+}
+
+func (o slogObserver) OnKeyLookup(e KeyLookupEvent) {
+	level := slog.LevelDebug
+	attrs := []slog.Attr{
+		slog.String("name", e.Name),
+		slog.Bool("cached", e.Cached),
+		slog.Duration("duration", e.Duration),
+	}
+	if e.Err != nil {
+		level = slog.LevelWarn
+		attrs = append(attrs, slog.String("error", e.Err.Error()))
+	}
+	o.logger.LogAttrs(context.Background(), level, "dkim key lookup", attrs...)
+}
@@ -0,0 +1,45 @@
+package dkim
+
+import (
+	"bytes"
+	"errors"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSlogObserver(t *testing.T) {
+	var buf bytes.Buffer
+	logger := slog.New(slog.NewTextHandler(&buf, &slog.HandlerOptions{
+		Level: slog.LevelDebug,
+		ReplaceAttr: func(groups []string, a slog.Attr) slog.Attr {
+			if a.Key == slog.TimeKey {
+				return slog.Attr{}
+			}
+			return a
+		},
+	}))
+	o := NewSlogObserver(logger)
+
+	o.OnSign(SignEvent{Domain: domain, Selector: selector, Algo: "rsa-sha256", Duration: time.Millisecond})
+	o.OnSign(SignEvent{Domain: domain, Err: ErrSignSelectorRequired})
+	o.OnVerifyResult(VerifyEvent{
+		Result:   &VerifyResult{Status: SUCCESS, Header: &DKIMHeader{Domain: domain, Selector: selector}, KeySource: KeySourceDNS},
+		Duration: 2 * time.Millisecond,
+	})
+	o.OnVerifyResult(VerifyEvent{Result: &VerifyResult{Status: NOTSIGNED, Err: ErrDkimHeaderNotFound}})
+	o.OnVerifyResult(VerifyEvent{Result: &VerifyResult{Status: PERMFAIL, Err: ErrVerifyBodyHash, Header: &DKIMHeader{Domain: domain, Selector: selector}}})
+	o.OnKeyLookup(KeyLookupEvent{Name: "s._domainkey.example.com", Cached: true})
+	o.OnKeyLookup(KeyLookupEvent{Name: "s._domainkey.example.com", Duration: time.Second, Err: errors.New("timeout")})
+
+	assert.Equal(t, `level=DEBUG msg="dkim sign" domain=tmail.io selector=test algo=rsa-sha256 duration=1ms
+level=ERROR msg="dkim sign" domain=tmail.io selector="" algo="" duration=0s error="Selector is required"
+level=DEBUG msg="dkim verify" status=SUCCESS domain=tmail.io selector=test duration=2ms key_source=dns
+level=DEBUG msg="dkim verify" status=NOTSIGNED duration=0s
+level=WARN msg="dkim verify" status=PERMFAIL domain=tmail.io selector=test duration=0s error="body hash did not verify"
+level=DEBUG msg="dkim key lookup" name=s._domainkey.example.com cached=true duration=0s
+level=WARN msg="dkim key lookup" name=s._domainkey.example.com cached=false duration=1s error=timeout
+`, buf.String())
+}
@@ -0,0 +1,92 @@
+package dkim
+
+import (
+	"strings"
+)
+
+// Tag represents a tag=value pair of a tag list
+type Tag struct {
+	// Name of the tag (case sensitive)
+	Name string
+
+	// Value is the raw value of the tag. Whitespace and FWS surrounding the
+	// value are removed, whitespace inside the value is kept as is.
+	Value string
+}
+
+// TagList represents a tag list as defined in RFC 6376 section 3.2.
+// Tags are kept in the order they appear.
+//
+// Tag lists are used by DKIM-Signature header fields, DKIM key records and
+// other records sharing the same syntax (ARC, DMARC, ...).
+type TagList []Tag
+
+// ParseTagList parses a tag list (RFC 6376 section 3.2)
+//
+//	tag-list  =  tag-spec *( ";" tag-spec ) [ ";" ]
+//	tag-spec  =  [FWS] tag-name [FWS] "=" [FWS] tag-value [FWS]
+//
+// Empty tag-specs are ignored. Duplicate tag names make the whole list
+// invalid (ErrTagListDuplicateTag).
+func ParseTagList(s string) (TagList, error) {
+	list := TagList{}
+	for _, spec := range strings.Split(s, ";") {
+		if strings.Trim(spec, " \t\r\n") == "" {
+			continue
+		}
+		nameVal := strings.SplitN(spec, "=", 2)
+		if len(nameVal) != 2 {
+			return nil, ErrTagListSyntax
+		}
+		name := strings.Trim(nameVal[0], " \t\r\n")
+		if !isTagName(name) {
+			return nil, ErrTagListSyntax
+		}
+		if _, found := list.Get(name); found {
+			return nil, ErrTagListDuplicateTag
+		}
+		list = append(list, Tag{
+			Name:  name,
+			Value: strings.Trim(nameVal[1], " \t\r\n"),
+		})
+	}
+	return list, nil
+}
+
+// Get returns the value of the tag name and true if this tag is in the list
+func (l TagList) Get(name string) (string, bool) {
+	for _, t := range l {
+		if t.Name == name {
+			return t.Value, true
+		}
+	}
+	return "", false
+}
+
+// String returns the tag list as a string ("name=value; name=value")
+func (l TagList) String() string {
+	s := make([]string, 0, len(l))
+	for _, t := range l {
+		s = append(s, t.Name+"="+t.Value)
+	}
+	return strings.Join(s, "; ")
+}
+
+// isTagName checks tag name syntax
+//
+//	tag-name  =  ALPHA *ALNUMPUNC
+//	ALNUMPUNC =  ALPHA / DIGIT / "_"
+func isTagName(name string) bool {
+	if name == "" {
+		return false
+	}
+	for i, c := range name {
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z':
+		case i > 0 && (c >= '0' && c <= '9' || c == '_'):
+		default:
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+package dkim
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTagList(t *testing.T) {
+	t.Parallel()
+
+	type testCase struct {
+		Name   string
+		Input  string
+		Expect TagList
+		Err    error
+	}
+
+	testCases := []testCase{
+		{
+			Name:   "empty",
+			Input:  "",
+			Expect: TagList{},
+		},
+		{
+			Name:  "order is kept",
+			Input: "v=1; a=rsa-sha256; d=example.net",
+			Expect: TagList{
+				{Name: "v", Value: "1"},
+				{Name: "a", Value: "rsa-sha256"},
+				{Name: "d", Value: "example.net"},
+			},
+		},
+		{
+			Name:  "trailing semicolon and FWS",
+			Input: " v = 1 ;\r\n\tz=From:foo@eng.example.net|To:joe@example.com|\r\n Subject:demo=20run ; ",
+			Expect: TagList{
+				{Name: "v", Value: "1"},
+				{Name: "z", Value: "From:foo@eng.example.net|To:joe@example.com|\r\n Subject:demo=20run"},
+			},
+		},
+		{
+			Name:  "empty value",
+			Input: "p=",
+			Expect: TagList{
+				{Name: "p", Value: ""},
+			},
+		},
+		{
+			Name:  "value with equal",
+			Input: "bh=2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8=",
+			Expect: TagList{
+				{Name: "bh", Value: "2jUSOH9NhtVGCQWNr9BrIAPreKQjO6Sn7XIkfJVOzv8="},
+			},
+		},
+		{
+			Name:  "dmarc record",
+			Input: "v=DMARC1; p=reject; rua=mailto:dmarc@example.com; pct=100",
+			Expect: TagList{
+				{Name: "v", Value: "DMARC1"},
+				{Name: "p", Value: "reject"},
+				{Name: "rua", Value: "mailto:dmarc@example.com"},
+				{Name: "pct", Value: "100"},
+			},
+		},
+		{
+			Name:  "duplicate tag",
+			Input: "v=1; d=example.net; d=example.com",
+			Err:   ErrTagListDuplicateTag,
+		},
+		{
+			Name:  "missing equal",
+			Input: "v=1; d",
+			Err:   ErrTagListSyntax,
+		},
+		{
+			Name:  "empty name",
+			Input: "=1",
+			Err:   ErrTagListSyntax,
+		},
+		{
+			Name:  "invalid name",
+			Input: "1v=1",
+			Err:   ErrTagListSyntax,
+		},
+	}
+
+	for _, tc := range testCases {
+		tc := tc
+		t.Run(tc.Name, func(t *testing.T) {
+			tags, err := ParseTagList(tc.Input)
+			assert.Equal(t, tc.Err, err)
+			assert.Equal(t, tc.Expect, tags)
+		})
+	}
+}
+
+func TestTagList_Get(t *testing.T) {
+	tags, err := ParseTagList("v=1; a=rsa-sha256")
+	assert.NoError(t, err)
+
+	v, found := tags.Get("a")
+	assert.True(t, found)
+	assert.Equal(t, "rsa-sha256", v)
+
+	_, found = tags.Get("A")
+	assert.False(t, found)
+
+	assert.Equal(t, "v=1; a=rsa-sha256", tags.String())
+}
+
+func FuzzParseTagList(f *testing.F) {
+	f.Add("v=1; a=rsa-sha256; d=example.net")
+	f.Add(" v = 1 ;\r\n\tz=From:foo@eng.example.net|To:joe@example.com|\r\n Subject:demo=20run ; ")
+	f.Add("v=DKIM1; p=a; p=b")
+	f.Add("=;;a")
+	f.Fuzz(func(t *testing.T, s string) {
+		list, err := ParseTagList(s)
+		if err != nil {
+			return
+		}
+		// the string form of a tag list must parse to the same tag list
+		again, err := ParseTagList(list.String())
+		if err != nil {
+			t.Fatalf("%q: can't parse %q: %v", s, list.String(), err)
+		}
+		assert.Equal(t, list, again)
+	})
+}
@@ -0,0 +1,70 @@
+//go:build ignore
+
+// gen signs the messages below with github.com/emersion/go-msgauth and
+// writes vectors.json (see README.md)
+package main
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/json"
+	"log"
+	"os"
+
+	"github.com/emersion/go-msgauth/dkim"
+)
+
+type vector struct {
+	Name    string `json:"name"`
+	Message string `json:"message"`
+	Simple  string `json:"simple"`
+	Relaxed string `json:"relaxed"`
+}
+
+var messages = []vector{
+	{Name: "RFC 6376 example", Message: "From: joe@tmail.io\r\nA: X\r\nB : Y\t\r\n\tZ  \r\n\r\n C \r\nD \t E\r\n\r\n\r\n"},
+	{Name: "empty body", Message: "From: joe@tmail.io\r\nSubject: empty\r\n\r\n"},
+	{Name: "only empty lines", Message: "From: joe@tmail.io\r\n\r\n\r\n\r\n"},
+	{Name: "only whitespace lines", Message: "From: joe@tmail.io\r\n\r\n  \r\n\t\r\n"},
+	{Name: "no trailing CRLF", Message: "From: joe@tmail.io\r\n\r\nabc  "},
+	{Name: "empty lines inside body", Message: "From: joe@tmail.io\r\n\r\na\r\n\r\n \r\nb\r\n"},
+	{Name: "single and multiple spaces", Message: "From: joe@tmail.io\r\n\r\na b  c \t\r\n d e\r\n"},
+	{Name: "folded header fields", Message: "FROM:joe@tmail.io\r\nSubject :  a\r\n  folded\r\n\tsubject \r\nX-Empty:\r\nX-Space: \r\n\r\nbody\r\n"},
+	{Name: "8bit", Message: "From: =?UTF-8?Q?St=C3=A9phane?= <joe@tmail.io>\r\nSubject: caf\xc3\xa9  cr\xc3\xa8me\r\n\r\nd\xc3\xa9j\xc3\xa0   vu \r\n"},
+}
+
+func main() {
+	// the "ed" key of the conformance corpus
+	seed := make([]byte, ed25519.SeedSize)
+	copy(seed, "go-dkim conformance ed25519 key")
+	key := ed25519.NewKeyFromSeed(seed)
+
+	for i, v := range messages {
+		for _, c := range []dkim.Canonicalization{dkim.CanonicalizationSimple, dkim.CanonicalizationRelaxed} {
+			var b bytes.Buffer
+			err := dkim.Sign(&b, bytes.NewReader([]byte(v.Message)), &dkim.SignOptions{
+				Domain:                 "tmail.io",
+				Selector:               "ed",
+				Signer:                 key,
+				HeaderCanonicalization: c,
+				BodyCanonicalization:   c,
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+			signature := b.String()[:b.Len()-len(v.Message)]
+			if c == dkim.CanonicalizationSimple {
+				messages[i].Simple = signature
+			} else {
+				messages[i].Relaxed = signature
+			}
+		}
+	}
+
+	enc := json.NewEncoder(os.Stdout)
+	enc.SetIndent("", "  ")
+	enc.SetEscapeHTML(false)
+	if err := enc.Encode(messages); err != nil {
+		log.Fatal(err)
+	}
+}
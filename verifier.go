@@ -0,0 +1,80 @@
+package dkim
+
+import (
+	"sync"
+	"time"
+)
+
+// Verifier verifies the signatures of messages with a pool of workers.
+//
+// The signatures of a message are verified concurrently: their keys are
+// looked up in parallel (use DNSOptLookupTimeout to bound each lookup),
+// identical lookups are made once, and signatures sharing the body
+// canonicalization, algorithm and l= share the body hash. Set a KeyCache
+// with VerifyOptKeyCache to also share lookups across messages.
+//
+// A Verifier is safe for concurrent use.
+type Verifier struct {
+	verifyOpts VerifyOptions
+	jobs       chan func()
+	wg         sync.WaitGroup
+	closeOnce  sync.Once
+}
+
+// NewVerifier starts a Verifier with workers goroutines. Close stops them.
+func NewVerifier(workers int, opts ...VerifyOpt) *Verifier {
+	if workers < 1 {
+		workers = 1
+	}
+	v := &Verifier{jobs: make(chan func())}
+	for _, opt := range opts {
+		opt.applyVerify(&v.verifyOpts)
+	}
+	for i := 0; i < workers; i++ {
+		v.wg.Add(1)
+		go func() {
+			defer v.wg.Done()
+			for job := range v.jobs {
+				job()
+			}
+		}()
+	}
+	return v
+}
+
+// Verify verifies every DKIM-Signature of email, like VerifyAll, and
+// returns the results in signature order
+func (v *Verifier) Verify(email []byte) []*VerifyResult {
+	start := time.Now()
+	dkimHeaders, results := parseSignatures(&email)
+	msg := newVerifyMessage(&email, v.verifyOpts.lineEndings)
+	verifyOpts := v.verifyOpts
+	if verifyOpts.keyCache == nil {
+		// share the lookups of the message only
+		verifyOpts.keyCache = NewKeyCache(time.Hour)
+	}
+	var wg sync.WaitGroup
+	for i, dkimHeader := range dkimHeaders {
+		if dkimHeader == nil {
+			verifyOpts.observeVerify(results[i], start)
+			continue
+		}
+		i, dkimHeader := i, dkimHeader
+		wg.Add(1)
+		v.jobs <- func() {
+			defer wg.Done()
+			results[i] = verifyDkimHeader(msg, dkimHeader, &verifyOpts)
+		}
+	}
+	wg.Wait()
+	return results
+}
+
+// Close stops the workers once the verifications in progress are done.
+// Verify must not be called after Close.
+func (v *Verifier) Close() {
+	v.closeOnce.Do(func() {
+		close(v.jobs)
+	})
+	v.wg.Wait()
+}
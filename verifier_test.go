@@ -0,0 +1,104 @@
+package dkim
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVerifier(t *testing.T) {
+	edKey, err := GenerateKey("ed25519-sha256")
+	require.NoError(t, err)
+	edRecord, err := PublicKeyRecord(edKey)
+	require.NoError(t, err)
+
+	rsaOptions := NewSigOptions()
+	rsaOptions.PrivateKey = []byte(privKey)
+	rsaOptions.Domain = domain
+	rsaOptions.Selector = selector
+	rsaOptions.Canonicalization = "relaxed/relaxed"
+	rsaSimpleOptions := rsaOptions
+	rsaSimpleOptions.Canonicalization = "simple/simple"
+	edOptions := rsaOptions
+	edOptions.PrivateKey = edKey
+	edOptions.Algo = "ed25519-sha256"
+	edOptions.Selector = "ed"
+	slowOptions := rsaOptions
+	slowOptions.Selector = "slow"
+
+	email := []byte(emailBase)
+	require.NoError(t, SignMulti(&email, rsaOptions, edOptions, rsaSimpleOptions, slowOptions))
+
+	// the lookups of test and ed wait for each other: they must run concurrently
+	var mu sync.Mutex
+	lookups := map[string]int{}
+	inFlight := sync.WaitGroup{}
+	inFlight.Add(2)
+	release := make(chan struct{})
+	resolveTXT := DNSOptLookupTXT(func(name string) ([]string, error) {
+		mu.Lock()
+		lookups[name]++
+		mu.Unlock()
+		switch name {
+		case selector + "._domainkey." + domain:
+			inFlight.Done()
+			inFlight.Wait()
+			return []string{"v=DKIM1; p=" + pubKey}, nil
+		case "ed._domainkey." + domain:
+			inFlight.Done()
+			inFlight.Wait()
+			return []string{edRecord}, nil
+		}
+		<-release
+		return nil, errors.New("released")
+	})
+	defer close(release)
+
+	v := NewVerifier(4, resolveTXT, DNSOptLookupTimeout(time.Second))
+	defer v.Close()
+	results := v.Verify(email)
+
+	require.Len(t, results, 4)
+	for i, s := range []string{selector, "ed", selector} {
+		assert.Equal(t, SUCCESS, results[i].Status, i)
+		assert.Equal(t, s, results[i].Header.Selector, i)
+	}
+	assert.Equal(t, "relaxed/relaxed", results[0].Header.MessageCanonicalization)
+	assert.Equal(t, "simple/simple", results[2].Header.MessageCanonicalization)
+	assert.Equal(t, TEMPFAIL, results[3].Status)
+	var lookupErr *KeyLookupError
+	require.ErrorAs(t, results[3].Err, &lookupErr)
+	assert.Equal(t, KeyLookupTimeout, lookupErr.Reason)
+
+	// the two signatures with selector test share one lookup
+	mu.Lock()
+	defer mu.Unlock()
+	assert.Equal(t, map[string]int{
+		selector + "._domainkey." + domain: 1,
+		"ed._domainkey." + domain:          1,
+		"slow._domainkey." + domain:        1,
+	}, lookups)
+
+	unsigned := v.Verify([]byte(emailBase))
+	require.Len(t, unsigned, 1)
+	assert.Equal(t, NOTSIGNED, unsigned[0].Status)
+}
+
+func TestVerifyMessageBodyHash(t *testing.T) {
+	email := []byte(emailBase)
+	msg := newVerifyMessage(&email, LineEndingsAuto)
+	_, _, err := msg.headersBody()
+	require.NoError(t, err)
+
+	relaxed := msg.bodyHash(bodyHashKey{"relaxed", "sha256", 0})
+	assert.Same(t, relaxed, msg.bodyHash(bodyHashKey{"relaxed", "sha256", 0}))
+	assert.NotSame(t, relaxed, msg.bodyHash(bodyHashKey{"simple", "sha256", 0}))
+
+	limited := msg.bodyHash(bodyHashKey{"simple", "sha256", 5})
+	assert.NoError(t, limited.err)
+	assert.Equal(t, limited.length-5, int64(len(limited.unsigned)))
+}